@@ -3,32 +3,34 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/AnVladic/DentalTelegramBot/internal/bot"
+	"github.com/AnVladic/DentalTelegramBot/internal/config"
 	"github.com/AnVladic/DentalTelegramBot/internal/crm"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/AnVladic/DentalTelegramBot/internal/health"
+	"github.com/AnVladic/DentalTelegramBot/migrations"
 	"github.com/AnVladic/DentalTelegramBot/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-func LoadEnv() {
-	err := godotenv.Load("configs/.env")
-	if err != nil {
-		panic(fmt.Errorf("error loading .env file: %w", err))
-	}
-}
-
-func OpenDB() *sql.DB {
-	db, err := sql.Open("pgx", os.Getenv("DATABASE_URL"))
+func OpenDB(databaseURL string) *sql.DB {
+	db, err := sql.Open("pgx", databaseURL)
 	if err != nil {
 		panic(err)
 	}
@@ -39,81 +41,428 @@ func OpenDB() *sql.DB {
 	return db
 }
 
-func InitTelegramBot(stopCtx context.Context, dentalProClient crm.IDentalProClient, db *sql.DB, debug bool) {
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		logrus.Panic("TELEGRAM_BOT_TOKEN environment variable not set")
-	}
-
-	tgBot, err := tgbotapi.NewBotAPI(botToken)
-	rgBotAPI := &bot.TelegramBotAPI{BotAPI: tgBot}
+// prepareSchema применяет миграции, если включен AUTO_MIGRATE, и проверяет версию схемы
+func prepareSchema(ctx context.Context, tenant config.Tenant, db *sql.DB, autoMigrate bool) {
+	migrator, err := database.NewMigrator(db, migrations.FS)
 	if err != nil {
 		logrus.Panic(err)
 	}
+	if autoMigrate {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			logrus.Panicf("tenant %s: %s", tenant.Name, err)
+		}
+		for _, migration := range applied {
+			logrus.Printf("Tenant %s: applied migration %s", tenant.Name, migration)
+		}
+	}
+	if err := migrator.CheckVersion(ctx); err != nil {
+		logrus.Panicf("tenant %s: %s", tenant.Name, err)
+	}
+}
 
-	branchID, err := strconv.ParseInt(os.Getenv("BRANCH_ID"), 10, 64)
+// runMigrate выполняет подкоманду migrate up|down [N]|status для базы каждой клиники
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return fmt.Errorf("usage: migrate up|down [N]|status")
+	}
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value <= 0 {
+			return fmt.Errorf("migrate down: invalid number of steps %q", args[1])
+		}
+		steps = value
+	}
+
+	for _, tenant := range cfg.Tenants {
+		if err := migrateTenant(context.Background(), tenant, args[0], steps); err != nil {
+			return fmt.Errorf("%s: %w", tenant.Name, err)
+		}
+	}
+	return nil
+}
+
+func migrateTenant(ctx context.Context, tenant config.Tenant, command string, steps int) error {
+	db := OpenDB(tenant.DatabaseURL)
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+	migrator, err := database.NewMigrator(db, migrations.FS)
 	if err != nil {
-		branchID = 3
-		logrus.Warnf("BRANCH_ID is nil. Set BRANCH_ID = %d", branchID)
+		return err
 	}
 
-	tgBot.Debug = debug
-	logrus.Printf("Authorized on account %s", tgBot.Self.UserName)
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("%s: applied %s\n", tenant.Name, migration)
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("%s: reverted %s\n", tenant.Name, migration)
+		}
+		return err
+	default:
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: version %d of %d, dirty: %t\n", tenant.Name, status.Version, status.Latest, status.Dirty)
+		for _, migration := range status.Pending {
+			fmt.Printf("%s: pending %s\n", tenant.Name, migration)
+		}
+	}
+	return nil
+}
+
+// tenantApp - бот одной клиники со всеми фоновыми задачами
+type tenantApp struct {
+	name        string
+	db          *sql.DB
+	tgBot       *tgbotapi.BotAPI
+	router      *bot.Router
+	reminders   *bot.ReminderScheduler
+	waitlist    *bot.WaitlistScheduler
+	broadcaster *bot.Broadcaster
+	// dentalPro - клиент без кэша для проверки готовности
+	dentalPro crm.IDentalProClient
+}
 
-	userTexts := bot.NewUserTexts()
+func NewDentalProClient(tenant config.Tenant, cfg *config.Config) *crm.CachedDentalProClient {
+	var dentalProClient crm.IDentalProClient
+	if cfg.Test {
+		dentalProClient = crm.NewDentalProClient(tenant.DentalPro.Token, tenant.DentalPro.Secret, true, "internal/crm")
+	} else {
+		limits := crm.DefaultRateLimitConfig()
+		limits.RequestsPerSecond = cfg.CRM.RateLimit
+		limits.Burst = cfg.CRM.RateBurst
+		limits.MaxConcurrent = cfg.CRM.MaxConcurrent
+		limits.MaxAttempts = cfg.CRM.MaxAttempts
+		client := crm.NewDentalProClientWithLimits(tenant.DentalPro.URL,
+			tenant.DentalPro.Token, tenant.DentalPro.Secret, limits)
+		client.SetTenant(tenant.Name)
+		dentalProClient = client
+	}
+	return crm.NewCachedDentalProClient(dentalProClient, crm.CacheTTL{
+		Doctors:       cfg.CRM.CacheDoctorsTTL,
+		Appointments:  cfg.CRM.CacheAppointmentsTTL,
+		FreeIntervals: cfg.CRM.CacheFreeIntervalsTTL,
+	})
+}
+
+func InitTelegramBot(
+	tenant config.Tenant, cfg *config.Config, catalog *bot.Catalog, dentalProClient *crm.CachedDentalProClient,
+	db *sql.DB,
+) *tenantApp {
+	tgBot, err := tgbotapi.NewBotAPI(tenant.TelegramBotToken)
+	if err != nil {
+		logrus.Panicf("tenant %s: %s", tenant.Name, err)
+	}
+	rgBotAPI := &bot.TelegramBotAPI{BotAPI: tgBot}
 
-	locStr := os.Getenv("LOCATION")
-	if locStr == "" {
-		locStr = "Europe/Moscow"
+	branches := make([]bot.Branch, 0, len(tenant.Branches))
+	for _, branch := range tenant.Branches {
+		branches = append(branches, bot.Branch{ID: branch.ID, Name: branch.Name, Address: branch.Address})
 	}
-	location, err := time.LoadLocation(locStr)
+
+	tgBot.Debug = cfg.Debug
+	logrus.Printf("Tenant %s authorized on account %s", tenant.Name, tgBot.Self.UserName)
+
+	userTexts := catalog.Texts(cfg.Language)
+
+	location, err := time.LoadLocation(tenant.Location)
 	if err != nil {
 		logrus.Panic(err)
 	}
 
 	telegramBotHandler := bot.NewTelegramBotHandler(
-		rgBotAPI, *userTexts, dentalProClient, db, branchID, location, bot.RealTimeProvider{},
+		rgBotAPI, *userTexts, dentalProClient, db, branches, location, bot.RealTimeProvider{},
+	)
+	telegramBotHandler.SetTenant(tenant.Name)
+	// Врачи сохраняются в базу, только когда кэш заново запрашивает их у DentalPro
+	dentalProClient.SetDoctorsHook(telegramBotHandler.SyncDoctors)
+	telegramBotHandler.SetAdmins(tenant.AdminIDs)
+	telegramBotHandler.SetCatalog(catalog)
+	broadcaster := bot.NewBroadcaster(telegramBotHandler, cfg.BroadcastRate)
+	telegramBotHandler.SetBroadcaster(broadcaster)
+	var chatStates bot.ChatStateStore
+	switch cfg.ChatState.Store {
+	case "memory":
+		chatStates = bot.NewMemoryChatStateStore(cfg.ChatState.TTL)
+	case "postgres":
+		chatStates = bot.NewDBChatStateStore(db, cfg.ChatState.TTL)
+	}
+	router := bot.NewRouter(tgBot, telegramBotHandler, chatStates, cfg.WorkerPoolSize, false)
+	reminderScheduler := bot.NewReminderScheduler(
+		telegramBotHandler, cfg.Reminders.Offsets, cfg.Reminders.Interval,
 	)
-	router := bot.NewRouter(tgBot, telegramBotHandler, false)
-	runServer(stopCtx, router)
+	waitlistScheduler := bot.NewWaitlistScheduler(
+		telegramBotHandler, cfg.Waitlist.Interval, cfg.Waitlist.Hold, cfg.Waitlist.Cooldown,
+	)
+	return &tenantApp{
+		name:        tenant.Name,
+		db:          db,
+		tgBot:       tgBot,
+		router:      router,
+		reminders:   reminderScheduler,
+		waitlist:    waitlistScheduler,
+		broadcaster: broadcaster,
+	}
+}
+
+// setWebhook регистрирует webhook в Telegram вместе с секретным токеном
+func setWebhook(tgBot *tgbotapi.BotAPI, url, secret string) {
+	params := tgbotapi.Params{"url": url}
+	if secret != "" {
+		params["secret_token"] = secret
+	}
+	if _, err := tgBot.MakeRequest("setWebhook", params); err != nil {
+		logrus.Panicf("set webhook: %s", err)
+	}
+}
+
+// deleteWebhook снимает webhook, оставшийся после работы в режиме webhook:
+// пока он зарегистрирован, Telegram отвечает 409 Conflict на getUpdates
+func deleteWebhook(tgBot *tgbotapi.BotAPI) {
+	if _, err := tgBot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		logrus.Panicf("delete webhook: %s", err)
+	}
+}
+
+func startUpdates(updates config.Updates, apps []*tenantApp) {
+	switch updates.Mode {
+	case "polling":
+		for _, app := range apps {
+			deleteWebhook(app.tgBot)
+			go app.router.StartListening()
+		}
+	case "webhook":
+		// Если клиник несколько, каждая получает обновления по своему пути: <WEBHOOK_PATH>/<name>
+		mux := http.NewServeMux()
+		for _, app := range apps {
+			tenantPath, tenantURL := updates.WebhookPath, updates.WebhookURL
+			if len(apps) > 1 {
+				tenantPath = strings.TrimRight(updates.WebhookPath, "/") + "/" + app.name
+				tenantURL = strings.TrimRight(updates.WebhookURL, "/") + "/" + app.name
+			}
+			mux.Handle(tenantPath, app.router.WebhookHandler(updates.WebhookSecret))
+			setWebhook(app.tgBot, tenantURL, updates.WebhookSecret)
+		}
+		server := &http.Server{Addr: updates.WebhookListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		// Сервер общий, его останавливает Shutdown первой клиники
+		go apps[0].router.StartWebhook(server)
+	}
 }
 
-func runServer(stopCtx context.Context, router *bot.Router) {
-	go bot.CleanupUserStates(router.ChatStatesMu, router.TgChatStates)
-	go router.StartListening()
+// watchLocales перечитывает тексты из LOCALES_DIR по SIGHUP и при изменении файлов.
+// Если новые тексты не прошли проверку, бот продолжает работать на прежних
+func watchLocales(ctx context.Context, catalog *bot.Catalog, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reload := func(reason string) {
+		if err := catalog.Reload(); err != nil {
+			logrus.Errorf("reload locales (%s): %s", reason, err)
+			return
+		}
+		logrus.Printf("Locales reloaded (%s)", reason)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload("SIGHUP")
+		case <-ticker.C:
+			changed, err := catalog.Changed()
+			if err != nil {
+				logrus.Errorf("check locales: %s", err)
+			} else if changed {
+				reload("files changed")
+			}
+		}
+	}
+}
+
+const (
+	// readyTimeout ограничивает время всех проверок одного запроса /readyz
+	readyTimeout = 5 * time.Second
+	// dentalProProbeTTL - как часто /readyz обращается к DentalPro, чтобы не расходовать лимит запросов
+	dentalProProbeTTL = 30 * time.Second
+)
+
+// newHealthChecker проверяет для каждой клиники базу, DentalPro и получение обновлений от Telegram
+func newHealthChecker(updates config.Updates, apps []*tenantApp) *health.Checker {
+	checker := health.NewChecker(readyTimeout)
+	for _, app := range apps {
+		checker.Add(app.name+"/postgres", app.db.PingContext)
+		checker.Add(app.name+"/dentalpro", health.Cached(func(ctx context.Context) error {
+			_, err := app.dentalPro.DoctorsList(ctx)
+			return err
+		}, dentalProProbeTTL))
+		// Общий сервер webhook запущен в router первой клиники, см. startUpdates
+		router := app.router
+		if updates.Mode == "webhook" {
+			router = apps[0].router
+		}
+		checker.Add(app.name+"/updates", func(context.Context) error {
+			if !router.Listening() {
+				return errors.New("not receiving updates")
+			}
+			return nil
+		})
+	}
+	return checker
+}
+
+// chatStatesCollector отдает число состояний чатов каждой клиники, посчитанное в момент запроса /metrics
+type chatStatesCollector struct {
+	apps []*tenantApp
+	desc *prometheus.Desc
+}
+
+func newChatStatesCollector(apps []*tenantApp) *chatStatesCollector {
+	return &chatStatesCollector{
+		apps: apps,
+		desc: prometheus.NewDesc("dentalbot_chat_states", "Stored chat states by tenant", []string{"tenant"}, nil),
+	}
+}
+
+func (c *chatStatesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *chatStatesCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, app := range c.apps {
+		count, err := app.router.ChatStateCount(ctx)
+		if err != nil {
+			logrus.Errorf("count chat states %s: %s", app.name, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), app.name)
+	}
+}
+
+// startStatusServer запускает HTTP сервер с /metrics, /healthz и /readyz
+func startStatusServer(listen string, apps []*tenantApp, checker *health.Checker) *http.Server {
+	prometheus.MustRegister(newChatStatesCollector(apps))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", checker.LiveHandler())
+	mux.Handle("/readyz", checker.ReadyHandler())
+	server := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("status server: %s", err)
+		}
+	}()
+	return server
+}
+
+func runServer(stopCtx context.Context, cfg *config.Config, apps []*tenantApp) {
+	checker := newHealthChecker(cfg.Updates, apps)
+	var statusServer *http.Server
+	if cfg.Metrics.Listen != "" {
+		statusServer = startStatusServer(cfg.Metrics.Listen, apps, checker)
+	}
+	for _, app := range apps {
+		go app.router.StartChatStateExpiry(time.Hour)
+	}
+	startUpdates(cfg.Updates, apps)
+	for _, app := range apps {
+		app.reminders.Start()
+		app.waitlist.Start()
+		app.broadcaster.ResumeRunning(stopCtx)
+	}
 	fmt.Println("Server is ready")
 	<-stopCtx.Done()
+	// Сервер статуса работает до конца остановки и отвечает на /readyz 503
+	checker.Shutdown()
+	for _, app := range apps {
+		app.reminders.Stop()
+		app.waitlist.Stop()
+		app.broadcaster.Stop()
+	}
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := router.Shutdown(shutdownCtx); err != nil {
-		logrus.Errorf("shutdown: %s", err)
-		return
+	for _, app := range apps {
+		if err := app.router.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("shutdown %s: %s", app.name, err)
+		}
+	}
+	if statusServer != nil {
+		if err := statusServer.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("shutdown status server: %s", err)
+		}
 	}
 }
 
 func main() {
-	pkg.InitLogger("app.log")
-	LoadEnv()
-	db := OpenDB()
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logrus.Fatal(err)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML file with settings")
+	envPath := flag.String("env", "configs/.env", ".env file with settings, may be missing")
+	checkConfig := flag.Bool("check-config", false, "validate settings, print them without secrets and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath, *envPath)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+	catalog, err := bot.LoadCatalog(cfg.LocalesDir, cfg.Language)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid locales:\n%s\n", err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Print(cfg)
+		return
+	}
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(cfg, flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}(db)
+		return
+	}
+
+	err = pkg.InitLogger(pkg.LoggerOptions{Format: cfg.Log.Format, Level: cfg.Log.Level, Path: cfg.Log.File})
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "init logger: %s\n", err)
+		os.Exit(1)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	DEBUG := os.Getenv("DEBUG") == "true"
-	TEST := os.Getenv("TEST") != "false"
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		logrus.Panic("TELEGRAM_BOT_TOKEN environment variable not set")
+	apps := make([]*tenantApp, 0, len(cfg.Tenants))
+	for _, tenant := range cfg.Tenants {
+		db := OpenDB(tenant.DatabaseURL)
+		prepareSchema(ctx, tenant, db, cfg.AutoMigrate)
+		dentalPro := NewDentalProClient(tenant, cfg)
+		app := InitTelegramBot(tenant, cfg, catalog, dentalPro, db)
+		app.dentalPro = dentalPro.IDentalProClient
+		apps = append(apps, app)
 	}
-
-	dentalProClient := crm.NewDentalProClient(
-		os.Getenv("DENTAL_PRO_TOKEN"), os.Getenv("DENTAL_PRO_SECRET"), TEST, "internal/crm")
-	InitTelegramBot(ctx, dentalProClient, db, DEBUG)
+	if cfg.LocalesDir != "" {
+		go watchLocales(ctx, catalog, cfg.LocalesReload)
+	}
+	defer func() {
+		for _, app := range apps {
+			if err := app.db.Close(); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}()
+	runServer(ctx, cfg, apps)
 }
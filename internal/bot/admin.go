@@ -0,0 +1,310 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/AnVladic/DentalTelegramBot/internal/crm"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetAdmins задает Telegram ID сотрудников клиники, которым доступны админские команды
+func (h *TelegramBotHandler) SetAdmins(tgUserIDs []int64) {
+	h.admins = make(map[int64]bool, len(tgUserIDs))
+	for _, id := range tgUserIDs {
+		h.admins[id] = true
+	}
+}
+
+func (h *TelegramBotHandler) SetBroadcaster(broadcaster *Broadcaster) {
+	h.broadcaster = broadcaster
+}
+
+func (h *TelegramBotHandler) IsAdmin(tgUserID int64) bool {
+	return h.admins[tgUserID]
+}
+
+func (h *TelegramBotHandler) startOfToday() time.Time {
+	now := h.nowTime.Now().In(h.location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, h.location)
+}
+
+func (h *TelegramBotHandler) StatsHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "StatsHandler",
+	})
+
+	repository := database.StatsRepository{DB: h.db}
+	stats, err := repository.Get(ctx, h.startOfToday().UTC())
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+
+	text := h.userTexts.AdminStats.Execute(AdminStatsData{
+		Users:       stats.Users,
+		Patients:    stats.Patients,
+		Booked:      stats.Booked,
+		Confirmed:   stats.Confirmations[database.RecordConfirmed],
+		Cancelled:   stats.Confirmations[database.RecordCancelled],
+		Rescheduled: stats.Confirmations[database.RecordRescheduled],
+	})
+	response := tgbotapi.NewMessage(message.Chat.ID, text)
+	response.ParseMode = HTML
+	_, _ = h.Send(response, true)
+}
+
+func (h *TelegramBotHandler) TodayHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "TodayHandler",
+	})
+
+	repository := database.BookingRepository{DB: h.db}
+	records, err := repository.ListBookedSince(ctx, h.startOfToday().UTC())
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+	if len(records) == 0 {
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminTodayEmpty), true)
+		return
+	}
+
+	items := make([]string, len(records))
+	for i, record := range records {
+		datetime := "-"
+		if record.Datetime != nil {
+			datetime = record.Datetime.Format("2006-01-02 15:04")
+		}
+		items[i] = h.userTexts.AdminTodayItem.Execute(AdminTodayItemData{
+			Number:    i + 1,
+			BookedAt:  record.BookedAt.In(h.location).Format("15:04"),
+			LastName:  derefOr(record.Lastname, ""),
+			FirstName: derefOr(record.Name, ""),
+			Phone:     derefOr(record.Phone, "-"),
+			Datetime:  datetime,
+			Doctor:    derefOr(record.DoctorFIO, "-"),
+			RecordID:  record.RecordID,
+		})
+	}
+	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminToday+strings.Join(items, "\n\n"))
+	response.ParseMode = HTML
+	_, _ = h.Send(response, true)
+}
+
+// cacheInvalidator - клиент DentalPro с кэшем, см. crm.CachedDentalProClient
+type cacheInvalidator interface {
+	InvalidateAll()
+}
+
+// RefreshHandler сбрасывает кэш DentalPro, например после изменения врачей или услуг в DentalPro
+func (h *TelegramBotHandler) RefreshHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	if cache, ok := h.dentalProClient.(cacheInvalidator); ok {
+		cache.InvalidateAll()
+	}
+	pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "RefreshHandler",
+	}).Info("DentalPro cache invalidated")
+	_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminRefreshed), true)
+}
+
+func (h *TelegramBotHandler) FindPatientHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "FindPatientHandler",
+	})
+
+	phone := strings.TrimSpace(message.CommandArguments())
+	if phone == "" {
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminFindPatientUsage), true)
+		return
+	}
+
+	patient, err := h.dentalProClient.PatientByPhone(ctx, phone)
+	if errors.Is(err, crm.ErrNotFound) {
+		text := h.userTexts.AdminPatientNotFound.Execute(PhoneData{Phone: phone})
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, text), true)
+		return
+	}
+	if h.checkAndLogError(err, log, message, "PatientByPhone %s", phone) {
+		return
+	}
+
+	tgUser := "-"
+	repository := database.UserRepository{DB: h.db}
+	user, err := repository.GetByDentalProID(ctx, patient.ExternalID)
+	if err == nil {
+		tgUser = fmt.Sprintf("%d", user.TgUserID)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.WithError(err).Errorf("GetByDentalProID %d", patient.ExternalID)
+	}
+
+	text := h.userTexts.AdminPatient.Execute(AdminPatientData{
+		LastName:    patient.Surname,
+		FirstName:   patient.Name,
+		Phone:       patient.Phone,
+		DentalProID: patient.ExternalID,
+		TelegramID:  tgUser,
+	})
+	response := tgbotapi.NewMessage(message.Chat.ID, text)
+	response.ParseMode = HTML
+	_, _ = h.Send(response, true)
+}
+
+func (h *TelegramBotHandler) BroadcastHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "BroadcastHandler",
+	})
+
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" {
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminBroadcastUsage), true)
+		return
+	}
+	if h.broadcaster == nil {
+		h.checkAndLogError(errors.New("broadcaster is not configured"), log, message, "")
+		return
+	}
+
+	broadcast, err := h.broadcaster.Start(ctx, message.Chat.ID, text)
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+	repository := database.BroadcastRepository{DB: h.db}
+	counts, err := repository.CountByStatus(ctx, broadcast.ID)
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+	recipients := 0
+	for _, count := range counts {
+		recipients += count
+	}
+	started := h.userTexts.AdminBroadcastStarted.Execute(BroadcastData{ID: broadcast.ID, Recipients: recipients})
+	_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, started), true)
+}
+
+func (h *TelegramBotHandler) BroadcastPauseHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "BroadcastPauseHandler",
+	})
+	broadcast, ok := h.findBroadcast(ctx, message, log)
+	if !ok {
+		return
+	}
+	if h.sendBroadcastFinishedError(h.broadcaster.Pause(ctx, broadcast.ID), broadcast.ID, message, log) {
+		return
+	}
+	text := h.userTexts.AdminBroadcastPaused.Execute(BroadcastData{ID: broadcast.ID})
+	_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, text), true)
+}
+
+func (h *TelegramBotHandler) BroadcastResumeHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "BroadcastResumeHandler",
+	})
+	broadcast, ok := h.findBroadcast(ctx, message, log)
+	if !ok {
+		return
+	}
+	if h.sendBroadcastFinishedError(h.broadcaster.Resume(ctx, broadcast.ID), broadcast.ID, message, log) {
+		return
+	}
+	text := h.userTexts.AdminBroadcastResumed.Execute(BroadcastData{ID: broadcast.ID})
+	_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, text), true)
+}
+
+func (h *TelegramBotHandler) BroadcastStatusHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.admin",
+		"func":   "BroadcastStatusHandler",
+	})
+	broadcast, ok := h.findBroadcast(ctx, message, log)
+	if !ok {
+		return
+	}
+	repository := database.BroadcastRepository{DB: h.db}
+	counts, err := repository.CountByStatus(ctx, broadcast.ID)
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+	status, ok := h.userTexts.BroadcastStatuses[broadcast.Status]
+	if !ok {
+		status = broadcast.Status
+	}
+	text := h.userTexts.AdminBroadcastStatus.Execute(BroadcastData{
+		ID:      broadcast.ID,
+		Status:  status,
+		Sent:    counts[database.DeliverySent],
+		Pending: counts[database.DeliveryPending],
+		Blocked: counts[database.DeliveryBlocked],
+		Failed:  counts[database.DeliveryFailed],
+	})
+	_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, text), true)
+}
+
+// findBroadcast ищет рассылку по номеру из аргумента команды, без номера - последнюю
+func (h *TelegramBotHandler) findBroadcast(
+	ctx context.Context, message *tgbotapi.Message, log *logrus.Entry) (*database.Broadcast, bool) {
+	if h.broadcaster == nil {
+		h.checkAndLogError(errors.New("broadcaster is not configured"), log, message, "")
+		return nil, false
+	}
+
+	repository := database.BroadcastRepository{DB: h.db}
+	var broadcast *database.Broadcast
+	var err error
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		id, parseErr := strconv.ParseInt(arg, 10, 64)
+		if parseErr != nil {
+			_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminBroadcastNotFound), true)
+			return nil, false
+		}
+		broadcast, err = repository.Get(ctx, id)
+	} else {
+		broadcast, err = repository.GetLast(ctx)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.AdminBroadcastNotFound), true)
+		return nil, false
+	}
+	if h.checkAndLogError(err, log, message, "") {
+		return nil, false
+	}
+	return broadcast, true
+}
+
+func (h *TelegramBotHandler) sendBroadcastFinishedError(
+	err error, broadcastID int64, message *tgbotapi.Message, log *logrus.Entry) bool {
+	if errors.Is(err, ErrBroadcastFinished) {
+		text := h.userTexts.AdminBroadcastAlreadyFinished.Execute(BroadcastData{ID: broadcastID})
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, text), true)
+		return true
+	}
+	return h.checkAndLogError(err, log, message, "")
+}
+
+func derefOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
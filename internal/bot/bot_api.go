@@ -7,7 +7,7 @@ import (
 
 type TelegramBotAPIWrapper interface {
 	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
-	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error)
 }
 
 type TimeProvider interface {
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Branch - филиал клиники, доступный для записи
+type Branch struct {
+	ID      int64
+	Name    string
+	Address string
+}
+
+type TelegramBranchCallbackData struct {
+	CallbackData
+	BranchID int64 `json:"b"`
+}
+
+func (h *TelegramBotHandler) multiBranch() bool {
+	return len(h.branches) > 1
+}
+
+// registerBranchID возвращает филиал записи. Записи без филиала относятся к первому из списка
+func (h *TelegramBotHandler) registerBranchID(register *database.Register) int64 {
+	if register != nil && register.BranchID != nil && h.branchEnabled(*register.BranchID) {
+		return *register.BranchID
+	}
+	return h.branches[0].ID
+}
+
+func (h *TelegramBotHandler) branchEnabled(branchID int64) bool {
+	for _, branch := range h.branches {
+		if branch.ID == branchID {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeToBranchesOrDoctorsMarkup показывает выбор филиала, а если филиал один - сразу список врачей
+func (h *TelegramBotHandler) ChangeToBranchesOrDoctorsMarkup(ctx context.Context, message *tgbotapi.Message) {
+	if h.multiBranch() {
+		h.ChangeToBranchesMarkup(message)
+		return
+	}
+	h.ChangeToDoctorsMarkup(ctx, message, h.branches[0].ID)
+}
+
+func (h *TelegramBotHandler) ChangeToBranchesMarkup(message *tgbotapi.Message) {
+	keyboard := tgbotapi.InlineKeyboardMarkup{}
+	for _, branch := range h.branches {
+		data := TelegramBranchCallbackData{
+			CallbackData: CallbackData{"branch"},
+			BranchID:     branch.ID,
+		}
+		bytesData, _ := json.Marshal(data)
+		title := branch.Name
+		if branch.Address != "" {
+			title = h.userTexts.BranchItem.Execute(BranchItemData{Name: branch.Name, Address: branch.Address})
+		}
+		btn := tgbotapi.NewInlineKeyboardButtonData(title, string(bytesData))
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tgbotapi.InlineKeyboardButton{btn})
+	}
+	response := tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID,
+		h.userTexts.ChooseBranch, keyboard)
+	_, _ = h.Edit(response, true)
+}
+
+func (h *TelegramBotHandler) SelectBranchCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.branch",
+		"func":   "SelectBranchCallback",
+	})
+
+	var data TelegramBranchCallbackData
+	err := json.Unmarshal([]byte(query.Data), &data)
+	if h.checkAndLogError(err, log, query.Message, "SelectBranchCallback %s", query.Data) {
+		return
+	}
+	if !h.branchEnabled(data.BranchID) {
+		h.checkAndLogError(fmt.Errorf("branch %d is not enabled", data.BranchID), log, query.Message, "")
+		return
+	}
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+
+	repository := database.RegisterRepository{DB: h.db}
+	_, err = repository.UpsertBranchID(ctx, database.Register{
+		UserID:    user.ID,
+		MessageID: query.Message.MessageID,
+		ChatID:    query.Message.Chat.ID,
+		BranchID:  &data.BranchID,
+	})
+	if h.checkAndLogError(err, log, query.Message, "") {
+		return
+	}
+	h.ChangeToDoctorsMarkup(ctx, query.Message, data.BranchID)
+}
+
+// BackToDoctorsCallback возвращает к списку врачей филиала, выбранного в записи
+func (h *TelegramBotHandler) BackToDoctorsCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.branch",
+		"func":   "BackToDoctorsCallback",
+	})
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+	repository := database.RegisterRepository{DB: h.db}
+	register, err := repository.Get(ctx, user.ID, query.Message.Chat.ID, query.Message.MessageID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.WithError(err).Error("Get register")
+		}
+		register = nil
+	}
+	h.ChangeToDoctorsMarkup(ctx, query.Message, h.registerBranchID(register))
+}
@@ -0,0 +1,18 @@
+package bot
+
+import (
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegisterBranchID(t *testing.T) {
+	h := &TelegramBotHandler{branches: []Branch{{ID: 3}, {ID: 5}}}
+	branchID := int64(5)
+	disabledID := int64(9)
+
+	require.Equal(t, int64(3), h.registerBranchID(nil))
+	require.Equal(t, int64(3), h.registerBranchID(&database.Register{}))
+	require.Equal(t, int64(5), h.registerBranchID(&database.Register{BranchID: &branchID}))
+	require.Equal(t, int64(3), h.registerBranchID(&database.Register{BranchID: &disabledID}))
+}
@@ -0,0 +1,324 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	broadcastBatchSize  = 100
+	broadcastMaxRetries = 3
+)
+
+var ErrBroadcastFinished = errors.New("broadcast already finished")
+
+// sendLimiter распределяет отправку сообщений так, чтобы не превышать
+// общий лимит Telegram и лимит на один чат
+type sendLimiter struct {
+	mu         sync.Mutex
+	global     time.Duration
+	perChat    time.Duration
+	next       time.Time
+	nextByChat map[int64]time.Time
+	now        func() time.Time
+}
+
+func newSendLimiter(global, perChat time.Duration) *sendLimiter {
+	return &sendLimiter{global: global, perChat: perChat, nextByChat: map[int64]time.Time{}, now: time.Now}
+}
+
+// reserve возвращает момент, когда можно отправить сообщение в чат, и резервирует его
+func (l *sendLimiter) reserve(chatID int64) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	slot := now
+	if l.next.After(slot) {
+		slot = l.next
+	}
+	if chatNext := l.nextByChat[chatID]; chatNext.After(slot) {
+		slot = chatNext
+	}
+	l.next = slot.Add(l.global)
+	l.nextByChat[chatID] = slot.Add(l.perChat)
+
+	if len(l.nextByChat) > 1000 {
+		for id, next := range l.nextByChat {
+			if next.Before(now) {
+				delete(l.nextByChat, id)
+			}
+		}
+	}
+	return slot
+}
+
+// Wait ждет своей очереди на отправку. Возвращает false, если ожидание прервано через stop
+func (l *sendLimiter) Wait(chatID int64, stop <-chan struct{}) bool {
+	return sleepOrStop(time.Until(l.reserve(chatID)), stop)
+}
+
+func sleepOrStop(d time.Duration, stop <-chan struct{}) bool {
+	if d <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Broadcaster рассылает сообщение всем пользователям бота. Статус доставки хранится в базе,
+// поэтому приостановленная или прерванная перезапуском рассылка продолжается с того же места
+type Broadcaster struct {
+	tgBotHandler *TelegramBotHandler
+	limiter      *sendLimiter
+	mu           sync.Mutex
+	running      map[int64]*broadcastRun
+	wg           *sync.WaitGroup
+}
+
+// broadcastRun - выполняющаяся рассылка, cancel прерывает ее. Запуск остается в running,
+// пока run не вернется: done закрывается после последней отправки
+type broadcastRun struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopping bool
+}
+
+// stop прерывает запуск. Вызывается под Broadcaster.mu
+func (r *broadcastRun) stop() {
+	r.stopping = true
+	r.cancel()
+}
+
+// NewBroadcaster создает рассыльщик, отправляющий не больше perSecond сообщений в секунду
+func NewBroadcaster(tgBotHandler *TelegramBotHandler, perSecond int) *Broadcaster {
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+	return &Broadcaster{
+		tgBotHandler: tgBotHandler,
+		limiter:      newSendLimiter(time.Second/time.Duration(perSecond), time.Second),
+		running:      map[int64]*broadcastRun{},
+		wg:           new(sync.WaitGroup),
+	}
+}
+
+func (b *Broadcaster) repository() *database.BroadcastRepository {
+	return &database.BroadcastRepository{DB: b.tgBotHandler.db}
+}
+
+func (b *Broadcaster) Start(ctx context.Context, adminChatID int64, text string) (*database.Broadcast, error) {
+	broadcast := &database.Broadcast{AdminChatID: adminChatID, Text: text, Status: database.BroadcastRunning}
+	if err := b.repository().Create(ctx, broadcast); err != nil {
+		return nil, err
+	}
+	b.launch(*broadcast)
+	return broadcast, nil
+}
+
+func (b *Broadcaster) Pause(ctx context.Context, id int64) error {
+	broadcast, err := b.repository().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if broadcast.Status == database.BroadcastFinished {
+		return ErrBroadcastFinished
+	}
+
+	b.mu.Lock()
+	if run, ok := b.running[id]; ok {
+		run.stop()
+	}
+	b.mu.Unlock()
+	return b.repository().SetStatus(ctx, id, database.BroadcastPaused)
+}
+
+func (b *Broadcaster) Resume(ctx context.Context, id int64) error {
+	broadcast, err := b.repository().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if broadcast.Status == database.BroadcastFinished {
+		return ErrBroadcastFinished
+	}
+	if err := b.repository().SetStatus(ctx, id, database.BroadcastRunning); err != nil {
+		return err
+	}
+	b.launch(*broadcast)
+	return nil
+}
+
+// ResumeRunning продолжает рассылки, прерванные остановкой бота
+func (b *Broadcaster) ResumeRunning(ctx context.Context) {
+	broadcasts, err := b.repository().ListByStatus(ctx, database.BroadcastRunning)
+	if err != nil {
+		logrus.WithError(err).Error("list running broadcasts")
+		return
+	}
+	for _, broadcast := range broadcasts {
+		b.launch(broadcast)
+	}
+}
+
+// Stop прерывает рассылки, не меняя их статус, и ждет завершения текущих отправок
+func (b *Broadcaster) Stop() {
+	b.mu.Lock()
+	for _, run := range b.running {
+		run.stop()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *Broadcaster) launch(broadcast database.Broadcast) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		run, ok := b.running[broadcast.ID]
+		if !ok {
+			break
+		}
+		if !run.stopping {
+			return
+		}
+		// Приостановленный запуск может еще отправлять сообщение: ждем его,
+		// иначе новый запуск возьмет ту же доставку из ListPending и отправит ее второй раз
+		b.mu.Unlock()
+		<-run.done
+		b.mu.Lock()
+	}
+	// рассылка живет дольше обновления, которое ее запустило, поэтому у нее свой контекст
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &broadcastRun{cancel: cancel, done: make(chan struct{})}
+	b.running[broadcast.ID] = run
+	b.wg.Add(1)
+	go b.run(ctx, broadcast, run)
+}
+
+func (b *Broadcaster) run(ctx context.Context, broadcast database.Broadcast, run *broadcastRun) {
+	log := logrus.WithFields(logrus.Fields{
+		"module":       "bot.broadcast",
+		"func":         "run",
+		"broadcast_id": broadcast.ID,
+	})
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		if b.running[broadcast.ID] == run {
+			delete(b.running, broadcast.ID)
+		}
+		b.mu.Unlock()
+		run.cancel()
+		close(run.done)
+	}()
+
+	repository := b.repository()
+	// Отправленное сообщение нужно отметить и после паузы, иначе следующий запуск отправит его снова
+	saveCtx := context.WithoutCancel(ctx)
+	for {
+		deliveries, err := repository.ListPending(ctx, broadcast.ID, broadcastBatchSize)
+		if err != nil {
+			log.WithError(err).Error("ListPending")
+			return
+		}
+		if len(deliveries) == 0 {
+			b.finish(ctx, broadcast, log)
+			return
+		}
+		for _, delivery := range deliveries {
+			if !b.limiter.Wait(delivery.TgUserID, ctx.Done()) {
+				log.Println("broadcast interrupted")
+				return
+			}
+			status, deliveryErr := b.deliver(delivery.TgUserID, broadcast.Text, ctx.Done())
+			if status == "" {
+				return
+			}
+			if status == database.DeliveryBlocked {
+				userRepo := database.UserRepository{DB: b.tgBotHandler.db}
+				if err := userRepo.MarkBlocked(saveCtx, delivery.UserID, time.Now().UTC()); err != nil {
+					log.WithError(err).Errorf("MarkBlocked %d", delivery.UserID)
+				}
+			}
+			if err := repository.UpdateDelivery(saveCtx, delivery.ID, status, deliveryErr); err != nil {
+				log.WithError(err).Errorf("UpdateDelivery %d", delivery.ID)
+				return
+			}
+		}
+	}
+}
+
+// deliver отправляет сообщение и возвращает статус доставки.
+// Пустой статус означает, что отправка прервана через stop и доставку нужно повторить позже
+func (b *Broadcaster) deliver(tgUserID int64, text string, stop <-chan struct{}) (string, *string) {
+	var err error
+	for attempt := 0; attempt < broadcastMaxRetries; attempt++ {
+		_, err = b.tgBotHandler.bot.Send(tgbotapi.NewMessage(tgUserID, text))
+		if err == nil {
+			return database.DeliverySent, nil
+		}
+
+		var tgErr *tgbotapi.Error
+		if !errors.As(err, &tgErr) {
+			break
+		}
+		if tgErr.Code == http.StatusForbidden {
+			return database.DeliveryBlocked, nil
+		}
+		if tgErr.Code != http.StatusTooManyRequests {
+			break
+		}
+		retryAfter := time.Duration(tgErr.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		if !sleepOrStop(retryAfter, stop) {
+			return "", nil
+		}
+	}
+	errText := err.Error()
+	return database.DeliveryFailed, &errText
+}
+
+func (b *Broadcaster) finish(ctx context.Context, broadcast database.Broadcast, log *logrus.Entry) {
+	repository := b.repository()
+	finished, err := repository.Finish(ctx, broadcast.ID)
+	if err != nil {
+		log.WithError(err).Error("Finish")
+		return
+	}
+	if !finished {
+		log.Println("broadcast is not running anymore, finish skipped")
+		return
+	}
+	counts, err := repository.CountByStatus(ctx, broadcast.ID)
+	if err != nil {
+		log.WithError(err).Error("CountByStatus")
+		return
+	}
+	h := b.tgBotHandler.ForUser(ctx, &tgbotapi.User{ID: broadcast.AdminChatID})
+	text := h.userTexts.AdminBroadcastFinished.Execute(BroadcastData{
+		ID:      broadcast.ID,
+		Sent:    counts[database.DeliverySent],
+		Blocked: counts[database.DeliveryBlocked],
+		Failed:  counts[database.DeliveryFailed],
+	})
+	_, _ = h.Send(tgbotapi.NewMessage(broadcast.AdminChatID, text), false)
+}
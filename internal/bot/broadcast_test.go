@@ -0,0 +1,28 @@
+package bot
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSendLimiterReserve(t *testing.T) {
+	now := time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC)
+	limiter := newSendLimiter(100*time.Millisecond, time.Second)
+	limiter.now = func() time.Time { return now }
+
+	require.Equal(t, now, limiter.reserve(1))
+	require.Equal(t, now.Add(100*time.Millisecond), limiter.reserve(2))
+	require.Equal(t, now.Add(200*time.Millisecond), limiter.reserve(3))
+	// второй раз в тот же чат - не раньше, чем через perChat
+	require.Equal(t, now.Add(time.Second), limiter.reserve(1))
+	require.Equal(t, now.Add(1100*time.Millisecond), limiter.reserve(4))
+}
+
+func TestSleepOrStop(t *testing.T) {
+	stop := make(chan struct{})
+	require.True(t, sleepOrStop(0, stop))
+	close(stop)
+	require.False(t, sleepOrStop(0, stop))
+	require.False(t, sleepOrStop(time.Hour, stop))
+}
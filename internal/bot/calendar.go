@@ -12,16 +12,16 @@ import (
 const BTN_PREV = "<"
 const BTN_NEXT = ">"
 
-func addMonthYearRow(year int, month time.Month, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.InlineKeyboardMarkup {
+func addMonthYearRow(year int, monthName string, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.InlineKeyboardMarkup {
 	var row []tgbotapi.InlineKeyboardButton
-	btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s %v", month, year), "1")
+	btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s %v", monthName, year), "1")
 	row = append(row, btn)
 	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
 	return keyboard
 }
 
-func addDaysNamesRow(keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.InlineKeyboardMarkup {
-	days := [7]string{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"}
+// addDaysNamesRow добавляет строку с названиями дней недели, начиная с понедельника
+func addDaysNamesRow(days []string, keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.InlineKeyboardMarkup {
 	var rowDays []tgbotapi.InlineKeyboardButton
 	for _, day := range days {
 		btn := tgbotapi.NewInlineKeyboardButtonData(day, day)
@@ -1,10 +1,12 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/AnVladic/DentalTelegramBot/internal/crm"
 	"github.com/AnVladic/DentalTelegramBot/internal/database"
 	"github.com/AnVladic/DentalTelegramBot/pkg"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -54,8 +56,25 @@ type TelegramRecordChangeCallback struct {
 	RecordID int64 `json:"r"`
 }
 
-func (h *TelegramBotHandler) ShowCalendarCallback(query *tgbotapi.CallbackQuery) {
-	log := logrus.WithFields(logrus.Fields{
+// approveRecordPayload - удаляемая запись и источник удаления. Поля записи встроены,
+// поэтому сохраненные ранее состояния с одной crm.ShortRecord тоже читаются
+type approveRecordPayload struct {
+	crm.ShortRecord
+	Source string `json:"source,omitempty"`
+}
+
+// callbackSource определяет источник действия по команде кнопки
+func callbackSource(command string) string {
+	switch command {
+	case "rem_mv", "rem_del":
+		return database.BookingSourceReminder
+	default:
+		return database.BookingSourceMenu
+	}
+}
+
+func (h *TelegramBotHandler) ShowCalendarCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.callbacks",
 		"func":   "ShowCalendarCallback",
 	})
@@ -65,28 +84,28 @@ func (h *TelegramBotHandler) ShowCalendarCallback(query *tgbotapi.CallbackQuery)
 		return
 	}
 
-	user, err := h.getOrCreateUser(query.From.ID, query.Message, log)
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	err = h.updateAppointmentRegister(
+	err = h.updateAppointmentRegister(ctx,
 		*user, query.Message, telegramChoiceAppointmentCallback.AppointmentID, log)
 	if err != nil {
 		return
 	}
 
-	register, err := h.getRegister(*user, query.Message, log)
+	register, err := h.getRegister(ctx, *user, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	doctor, err := h.getCRMDoctor(register.DoctorID, query.Message, log)
+	doctor, err := h.getCRMDoctor(ctx, register.DoctorID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	appointment, err := h.getAppointment(
+	appointment, err := h.getAppointment(ctx,
 		user, register.DoctorID, register.AppointmentID, query.Data, log, query.Message)
 	if err != nil {
 		return
@@ -94,14 +113,13 @@ func (h *TelegramBotHandler) ShowCalendarCallback(query *tgbotapi.CallbackQuery)
 
 	now := h.nowTime.Now()
 
-	text := fmt.Sprintf(
-		"%s - %s\n%s\n🟢 Доступные дни", h.userTexts.Calendar, doctor.FIO, appointment.Name,
-	)
-	h.ChangeTimesheet(query, now, &text, doctor.ID, appointment.Time)
+	text := h.userTexts.CalendarHeader.Execute(CalendarData{Doctor: doctor.FIO, Appointment: appointment.Name})
+	h.ChangeTimesheet(ctx,
+		query, now, &text, doctor.ID, h.registerBranchID(register), appointment.Time, "appointments")
 }
 
-func (h *TelegramBotHandler) SwitchTimesheetMonthCallback(query *tgbotapi.CallbackQuery) {
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) SwitchTimesheetMonthCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.callbacks",
 		"func":   "SwitchTimesheetMonthCallback",
 	})
@@ -114,12 +132,12 @@ func (h *TelegramBotHandler) SwitchTimesheetMonthCallback(query *tgbotapi.Callba
 		return
 	}
 
-	user, err := h.getOrCreateUser(query.From.ID, query.Message, log)
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	register, err := h.getRegister(*user, query.Message, log)
+	register, err := h.getRegister(ctx, *user, query.Message, log)
 	if err != nil {
 		return
 	}
@@ -134,26 +152,25 @@ func (h *TelegramBotHandler) SwitchTimesheetMonthCallback(query *tgbotapi.Callba
 		month = int(register.Datetime.Month())
 	}
 
-	doctor, err := h.getDoctor(register.DoctorID, query.Message, log)
+	doctor, err := h.getDoctor(ctx, register.DoctorID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	appointment, err := h.getAppointment(
+	appointment, err := h.getAppointment(ctx,
 		user, register.DoctorID, register.AppointmentID, query.Data, log, query.Message)
 	if err != nil {
 		return
 	}
 	newDate := time.Date(
 		year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	text := fmt.Sprintf(
-		"%s - %s\n%s\n🟢 Доступные дни", h.userTexts.Calendar, doctor.FIO, appointment.Name,
-	)
-	h.ChangeTimesheet(query, newDate, &text, *register.DoctorID, appointment.Time)
+	text := h.userTexts.CalendarHeader.Execute(CalendarData{Doctor: doctor.FIO, Appointment: appointment.Name})
+	h.ChangeTimesheet(ctx, query, newDate, &text, *register.DoctorID, h.registerBranchID(register),
+		appointment.Time, calendarBack(register))
 }
 
-func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) ShowAppointments(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.callbacks",
 		"func":   "ShowAppointments",
 	})
@@ -163,13 +180,13 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 		return
 	}
 
-	user, err := h.getOrCreateUser(query.From.ID, query.Message, log)
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
 	if err != nil {
 		return
 	}
 
 	if callbackData.DoctorID > 0 {
-		ok := h.upsertRegisterDoctorID(
+		ok := h.upsertRegisterDoctorID(ctx,
 			user.ID, query.Message.Chat.ID, query.Message.MessageID, callbackData.DoctorID,
 			query.Message, log,
 		)
@@ -177,7 +194,7 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 			return
 		}
 	} else {
-		register, err := h.getRegister(*user, query.Message, log)
+		register, err := h.getRegister(ctx, *user, query.Message, log)
 		if err != nil {
 			return
 		}
@@ -185,7 +202,7 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 	}
 
 	if user.DentalProID != nil {
-		record, err := h.findRecordByPatientAndDoctor(
+		record, err := h.findRecordByPatientAndDoctor(ctx,
 			callbackData.DoctorID, *user.DentalProID, query.Message, log)
 		if h.checkAndLogError(err, log, query.Message, "PatientRecords %s", err) {
 			return
@@ -200,7 +217,7 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 		}
 	}
 
-	appointments, err := h.getAvailableAppointments(
+	appointments, err := h.getAvailableAppointments(ctx,
 		user, callbackData.DoctorID, query.Data, log, query.Message)
 	if err != nil {
 		return
@@ -210,7 +227,9 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 
 	text := h.userTexts.ChooseAppointments
 	if len(appointments) == 0 {
-		text = h.noAppointmentsText(callbackData.DoctorID, query, log)
+		text = h.noAppointmentsText(ctx, callbackData.DoctorID, query, log)
+		keyboard.InlineKeyboard = append(
+			[][]tgbotapi.InlineKeyboardButton{{h.createWaitlistDoctorButton()}}, keyboard.InlineKeyboard...)
 	}
 
 	edit := tgbotapi.NewEditMessageTextAndMarkup(
@@ -218,8 +237,8 @@ func (h *TelegramBotHandler) ShowAppointments(query *tgbotapi.CallbackQuery) {
 	_, _ = h.Edit(edit, true)
 }
 
-func (h *TelegramBotHandler) ChoiceDayCallback(query *tgbotapi.CallbackQuery) {
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) ChoiceDayCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "callback",
 		"func":   "ChoiceDayCallback",
 	})
@@ -229,17 +248,17 @@ func (h *TelegramBotHandler) ChoiceDayCallback(query *tgbotapi.CallbackQuery) {
 		return
 	}
 
-	user, err := h.getOrCreateUser(query.From.ID, query.Message, log)
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	register, err := h.getRegister(*user, query.Message, log)
+	register, err := h.getRegister(ctx, *user, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	doctor, err := h.getDoctor(register.DoctorID, query.Message, log)
+	doctor, err := h.getDoctor(ctx, register.DoctorID, query.Message, log)
 	if err != nil {
 		return
 	}
@@ -250,18 +269,19 @@ func (h *TelegramBotHandler) ChoiceDayCallback(query *tgbotapi.CallbackQuery) {
 	}
 	register.Datetime = &date
 
-	appointment, err := h.getAppointment(
+	appointment, err := h.getAppointment(ctx,
 		user, &doctor.ID, register.AppointmentID, "", log, query.Message)
 	if err != nil {
 		return
 	}
 
-	intervals, err := h.getCRMFreeIntervals(register.DoctorID, date, appointment.Time, query.Message, log)
+	intervals, err := h.getCRMFreeIntervals(ctx, h.dentalProClient,
+		register.DoctorID, h.registerBranchID(register), date, appointment.Time, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	err = h.updateRegisterDatetime(*register, query.Message, log)
+	err = h.updateRegisterDatetime(ctx, *register, query.Message, log)
 	if err != nil {
 		return
 	}
@@ -275,9 +295,16 @@ func (h *TelegramBotHandler) ChoiceDayCallback(query *tgbotapi.CallbackQuery) {
 	var text string
 	dataStr := date.Format("02.01.2006")
 	if len(intervals) == 0 {
-		text = fmt.Sprintf(h.userTexts.DontHasIntervals, dataStr, doctor.FIO, appointment.Name, doctor.FIO)
+		text = h.userTexts.DontHasIntervals.Execute(
+			IntervalsData{Date: dataStr, Doctor: doctor.FIO, Appointment: appointment.Name})
+		// При переносе лист ожидания не предлагаем: по нему создается новая запись
+		if register.MoveRecordID == nil {
+			keyboard.InlineKeyboard = append(
+				h.createWaitlistButtons(telegramChoiceDayCallback.Date), keyboard.InlineKeyboard...)
+		}
 	} else {
-		text = fmt.Sprintf(h.userTexts.ChooseInterval, dataStr, doctor.FIO, appointment.Name)
+		text = h.userTexts.ChooseInterval.Execute(
+			IntervalsData{Date: dataStr, Doctor: doctor.FIO, Appointment: appointment.Name})
 	}
 
 	edit := tgbotapi.NewEditMessageTextAndMarkup(
@@ -285,37 +312,39 @@ func (h *TelegramBotHandler) ChoiceDayCallback(query *tgbotapi.CallbackQuery) {
 	_, _ = h.Edit(edit, true)
 }
 
-func (h *TelegramBotHandler) BackCallback(query *tgbotapi.CallbackQuery) {
+func (h *TelegramBotHandler) BackCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
 	var backCallback TelegramBackCallback
 	err := json.Unmarshal([]byte(query.Data), &backCallback)
 	if err != nil {
-		logrus.Error(err)
+		pkg.LoggerFromContext(ctx).Error(err)
 		return
 	}
 
 	switch backCallback.Back {
+	case "branches":
+		h.ChangeToBranchesMarkup(query.Message)
 	case "doctors":
-		h.ChangeToDoctorsMarkup(query.Message)
+		h.BackToDoctorsCallback(ctx, query)
 	case "calendar":
-		h.SwitchTimesheetMonthCallback(query)
+		h.SwitchTimesheetMonthCallback(ctx, query)
 	case "appointments":
-		h.ShowAppointments(query)
+		h.ShowAppointments(ctx, query)
+	case "move_records":
+		h.ChangeToMoveRecordsMarkup(ctx, query)
 	}
 }
 
 func (h *TelegramBotHandler) UpdateNoAuthRegisterCommandHandler(query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
-	chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-		h.NoAuthApproveRegister(query, message, chatState)
-	})
+	chatState.UpdateChatState(StepNoAuthApproveRegister, newCallbackQueryPayload(query))
 }
 
 func (h *TelegramBotHandler) NoAuthApproveRegister(
-	query *tgbotapi.CallbackQuery, message *tgbotapi.Message, chatState *TelegramChatState) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, query *tgbotapi.CallbackQuery, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.callback",
 		"func":   "NoAuthApproveRegister",
 	})
-	ok, err := h.GetPhoneNumber(message, chatState)
+	ok, err := h.GetPhoneNumber(ctx, message, chatState)
 	if err != nil {
 		_ = fmt.Errorf("GetPhoneNumber error %w", err)
 		return
@@ -330,29 +359,29 @@ func (h *TelegramBotHandler) NoAuthApproveRegister(
 
 	newMessage, _ := h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Wait), true)
 	repository := database.UserRepository{DB: h.db}
-	user, err := repository.GetUserByTelegramID(query.From.ID)
+	user, err := repository.GetUserByTelegramID(ctx, query.From.ID)
 	if err != nil {
 		return
 	}
 
-	register, err := h.getRegister(*user, query.Message, log)
+	register, err := h.getRegister(ctx, *user, query.Message, log)
 	if err != nil {
 		return
 	}
 	register.MessageID = newMessage.MessageID
 
 	registerRepo := database.RegisterRepository{DB: h.db}
-	err = registerRepo.Create(register)
+	err = registerRepo.Create(ctx, register)
 	if h.checkAndLogError(err, log, message, "") {
 		return
 	}
-	h.createApproveMessage(register, user, newMessage, log)
+	h.createApproveMessage(ctx, register, user, newMessage, log)
 }
 
 func (h *TelegramBotHandler) RegisterApproveCallback(
-	query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
+	ctx context.Context, query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
 	var register *database.Register
-	log := logrus.WithFields(logrus.Fields{
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "callback",
 		"func":   "RegisterApproveCallback",
 	})
@@ -363,7 +392,7 @@ func (h *TelegramBotHandler) RegisterApproveCallback(
 	}
 
 	repository := database.UserRepository{DB: h.db}
-	user, err := repository.GetUserByTelegramID(query.From.ID)
+	user, err := repository.GetUserByTelegramID(ctx, query.From.ID)
 
 	if user != nil {
 		startTime, err := time.Parse("15:4", parseData.StartTime)
@@ -371,7 +400,7 @@ func (h *TelegramBotHandler) RegisterApproveCallback(
 			return
 		}
 
-		register, err = h.getRegister(*user, query.Message, log)
+		register, err = h.getRegister(ctx, *user, query.Message, log)
 		if err != nil {
 			return
 		}
@@ -380,7 +409,7 @@ func (h *TelegramBotHandler) RegisterApproveCallback(
 			startTime.Hour(), startTime.Minute(), 0, 0, h.location,
 		)
 		register.Datetime = &datetime
-		err = h.updateRegisterDatetime(*register, query.Message, log)
+		err = h.updateRegisterDatetime(ctx, *register, query.Message, log)
 		if err != nil {
 			return
 		}
@@ -396,47 +425,48 @@ func (h *TelegramBotHandler) RegisterApproveCallback(
 		_, _ = h.Send(response, false)
 	}
 
-	h.createApproveMessage(register, user, query.Message, log)
+	h.createApproveMessage(ctx, register, user, query.Message, log)
 }
 
-func (h *TelegramBotHandler) RegisterCallback(query *tgbotapi.CallbackQuery) {
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) RegisterCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "callback",
 		"func":   "RegisterCallback",
 	})
 
-	user, err := h.getOrCreateUser(query.From.ID, query.Message, log)
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	dentalProUser, _, err := h.getOrCreatePatient(*user.Name, *user.Lastname, *user.Phone, query.Message, log)
+	dentalProUser, _, err := h.getOrCreatePatient(ctx, *user.Name, *user.Lastname, *user.Phone, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	if h.updateDentalProID(query.From.ID, dentalProUser.ExternalID, query.Message, log) != nil {
+	if h.updateDentalProID(ctx, query.From.ID, dentalProUser.ExternalID, query.Message, log) != nil {
 		return
 	}
 
-	register, err := h.getRegister(*user, query.Message, log)
+	register, err := h.getRegister(ctx, *user, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	crmDoctor, err := h.getCRMDoctor(register.DoctorID, query.Message, log)
+	crmDoctor, err := h.getCRMDoctor(ctx, register.DoctorID, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	appointment, err := h.getAppointment(
+	appointment, err := h.getAppointment(ctx,
 		user, register.DoctorID, register.AppointmentID, "", log, query.Message)
 	if err != nil {
 		return
 	}
 
-	intervals, err := h.getCRMFreeIntervals(
-		register.DoctorID, *register.Datetime, appointment.Time, query.Message, log,
+	// Время могли занять в самом DentalPro или из другого процесса, поэтому проверяем его без кэша
+	intervals, err := h.getCRMFreeIntervals(ctx, crm.Uncached(h.dentalProClient),
+		register.DoctorID, h.registerBranchID(register), *register.Datetime, appointment.Time, query.Message, log,
 	)
 	chooseTime := pkg.DatetimeToTime(*register.Datetime)
 	chooseDate := pkg.DatetimeToDate(*register.Datetime)
@@ -446,7 +476,11 @@ func (h *TelegramBotHandler) RegisterCallback(query *tgbotapi.CallbackQuery) {
 	for _, interval := range intervals {
 		begin := time.Time(interval.Begin)
 		if begin.Equal(chooseTime) {
-			record, err := h.dentalProClient.RecordCreate(
+			if register.MoveRecordID != nil {
+				h.moveRecord(ctx, query, register, crmDoctor, appointment, dentalProUser, chooseDate, chooseTime, log)
+				return
+			}
+			record, err := h.dentalProClient.RecordCreate(ctx,
 				chooseDate, chooseTime,
 				chooseTime.Add(time.Duration(appointment.Time)*time.Minute), *register.DoctorID,
 				dentalProUser.ExternalID, appointment.ID, false,
@@ -454,16 +488,27 @@ func (h *TelegramBotHandler) RegisterCallback(query *tgbotapi.CallbackQuery) {
 			if h.checkAndLogError(err, log, query.Message, "") {
 				return
 			}
-
-			text := fmt.Sprintf(h.userTexts.RegisterSuccess,
-				time.Time(record.Date).Format("2006-01-02"),
-				time.Time(record.TimeBegin).Format("15:04:05"),
-				crmDoctor.FIO,
-				appointment.Name,
-				appointment.Time,
-				dentalProUser.Surname,
-				dentalProUser.Name,
-			)
+			h.markRegisterBooked(ctx, register.ID, record.ID, log)
+			h.closeWaitlist(ctx, register, appointment.ID, log)
+			h.saveBooking(ctx, query.From.ID, database.Booking{
+				RecordID:      record.ID,
+				Action:        database.BookingCreated,
+				Source:        register.Source,
+				DoctorID:      register.DoctorID,
+				AppointmentID: &appointment.ID,
+				BranchID:      register.BranchID,
+				Datetime:      register.Datetime,
+			}, log)
+
+			text := h.userTexts.RegisterSuccess.Execute(RecordCreatedData{
+				Date:        time.Time(record.Date).Format("2006-01-02"),
+				Time:        time.Time(record.TimeBegin).Format("15:04:05"),
+				Doctor:      crmDoctor.FIO,
+				Appointment: appointment.Name,
+				Duration:    appointment.Time,
+				LastName:    dentalProUser.Surname,
+				FirstName:   dentalProUser.Name,
+			})
 			edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
 			edit.ParseMode = HTML
 			_, _ = h.Edit(edit, true)
@@ -481,45 +526,45 @@ func (h *TelegramBotHandler) RegisterCallback(query *tgbotapi.CallbackQuery) {
 
 func (h *TelegramBotHandler) ChangeNameCallback(
 	query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
-
-	log := logrus.WithFields(logrus.Fields{
-		"module": "callback",
-		"func":   "RegisterCallback",
-	})
-
 	response := tgbotapi.NewMessage(query.Message.Chat.ID, h.userTexts.ChangeFirstNameRequest)
 	_, _ = h.Send(response, true)
 
-	handler := HandlerMethod(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-		newMessage, _ := h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Wait), true)
-		repository := database.UserRepository{DB: h.db}
-		user, err := repository.GetUserByTelegramID(query.From.ID)
-		if err != nil {
-			return
-		}
-
-		register, err := h.getRegister(*user, query.Message, log)
-		if err != nil {
-			return
-		}
-		register.MessageID = newMessage.MessageID
+	onSuccess := NewChatStep(StepRegisterAfterChangeName, newCallbackQueryPayload(query))
+	chatState.UpdateChatState(StepChangeFirstName, onSuccessPayload{&onSuccess})
+}
 
-		registerRepo := database.RegisterRepository{DB: h.db}
-		err = registerRepo.Create(register)
-		if h.checkAndLogError(err, log, message, "") {
-			return
-		}
-		h.createApproveMessage(register, user, newMessage, log)
+// RegisterAfterChangeName продолжает запись после смены имени в новом сообщении
+func (h *TelegramBotHandler) RegisterAfterChangeName(
+	ctx context.Context, query *tgbotapi.CallbackQuery, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "callback",
+		"func":   "RegisterAfterChangeName",
 	})
 
-	chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-		h.ChangeFirstNameHandler(message, chatState, &handler)
-	})
+	newMessage, _ := h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Wait), true)
+	repository := database.UserRepository{DB: h.db}
+	user, err := repository.GetUserByTelegramID(ctx, query.From.ID)
+	if err != nil {
+		return
+	}
+
+	register, err := h.getRegister(ctx, *user, query.Message, log)
+	if err != nil {
+		return
+	}
+	register.MessageID = newMessage.MessageID
+
+	registerRepo := database.RegisterRepository{DB: h.db}
+	err = registerRepo.Create(ctx, register)
+	if h.checkAndLogError(err, log, message, "") {
+		return
+	}
+	h.createApproveMessage(ctx, register, user, newMessage, log)
 }
 
 func (h *TelegramBotHandler) ApproveDeleteRecord(
-	query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "callback",
 		"func":   "ApproveDeleteRecord",
 	})
@@ -528,21 +573,20 @@ func (h *TelegramBotHandler) ApproveDeleteRecord(
 		return
 	}
 
-	user, err := h.findUserAndCheckPhoneNumber(
-		func(message *tgbotapi.Message, chatState *TelegramChatState) {
-			h.ApproveDeleteRecord(query, chatState)
-		}, chatState, query.From.ID, query.Message, log,
+	user, err := h.findUserAndCheckPhoneNumber(ctx,
+		NewChatStep(StepApproveDeleteRecord, newCallbackQueryPayload(query)),
+		chatState, query.From.ID, query.Message, log,
 	)
 	if err != nil {
 		return
 	}
 
-	_, err = h.getDentalProIDByUser(user, query.Message, log)
+	_, err = h.getDentalProIDByUser(ctx, user, query.Message, log)
 	if err != nil {
 		return
 	}
 
-	records, err := h.getCRMRecordsList(*user.DentalProID, query.Message, log)
+	records, err := h.getCRMRecordsList(ctx, *user.DentalProID, query.Message, log)
 	if err != nil {
 		return
 	}
@@ -550,18 +594,19 @@ func (h *TelegramBotHandler) ApproveDeleteRecord(
 	for _, record := range records {
 		if record.ID == recordData.RecordID {
 			datetime := time.Time(record.DateStart)
-			text := fmt.Sprintf(h.userTexts.ApproveDeleteRecord,
-				datetime.Format("2006-01-02 15:04"), record.DoctorName)
+			text := h.userTexts.ApproveDeleteRecord.Execute(RecordData{
+				Datetime: datetime.Format("2006-01-02 15:04"), Doctor: record.DoctorName})
 			msg := tgbotapi.NewMessage(query.Message.Chat.ID, text)
 			keyboard := tgbotapi.NewReplyKeyboard([]tgbotapi.KeyboardButton{
-				tgbotapi.NewKeyboardButton("✅ Подтвердить"),
-				tgbotapi.NewKeyboardButton("Отменить"),
+				tgbotapi.NewKeyboardButton(h.userTexts.Approve),
+				tgbotapi.NewKeyboardButton(h.userTexts.CancelButton),
 			})
 			keyboard.OneTimeKeyboard = true
 			msg.ReplyMarkup = keyboard
 			_, _ = h.Send(msg, true)
-			chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-				h.ApproveRecordHandler(record, message, chatState)
+			chatState.UpdateChatState(StepApproveRecord, &approveRecordPayload{
+				ShortRecord: record,
+				Source:      callbackSource(recordData.Command),
 			})
 			return
 		}
@@ -570,3 +615,326 @@ func (h *TelegramBotHandler) ApproveDeleteRecord(
 	msg := tgbotapi.NewMessage(query.Message.Chat.ID, h.userTexts.HasNoDeleteRecord)
 	_, _ = h.Send(msg, true)
 }
+
+func (h *TelegramBotHandler) MoveRecordCallback(
+	ctx context.Context, query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "callback",
+		"func":   "MoveRecordCallback",
+	})
+	recordData, err := h.parseTelegramRecordChangeCallback(query, log)
+	if err != nil {
+		return
+	}
+
+	user, err := h.findUserAndCheckPhoneNumber(ctx,
+		NewChatStep(StepMoveRecordCallback, newCallbackQueryPayload(query)),
+		chatState, query.From.ID, query.Message, log,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = h.getDentalProIDByUser(ctx, user, query.Message, log)
+	if err != nil {
+		return
+	}
+
+	record, err := h.findCRMRecord(ctx, *user.DentalProID, recordData.RecordID, query.Message, log)
+	if err != nil {
+		return
+	}
+	if record == nil {
+		edit := tgbotapi.NewEditMessageText(
+			query.Message.Chat.ID, query.Message.MessageID, h.userTexts.HasNoMoveRecord)
+		_, _ = h.Edit(edit, true)
+		return
+	}
+
+	appointment, err := h.findRecordAppointment(ctx, user, *record, query.Message, log)
+	if err != nil {
+		return
+	}
+	if appointment == nil {
+		keyboard := h.AddBackButton(tgbotapi.NewInlineKeyboardMarkup(), "move_records")
+		text := h.userTexts.MoveRecordNoAppointment.Execute(VisitData{
+			Datetime:    time.Time(record.DateStart).Format("2006-01-02 15:04"),
+			Doctor:      record.DoctorName,
+			Appointment: record.Name,
+		})
+		edit := tgbotapi.NewEditMessageTextAndMarkup(
+			query.Message.Chat.ID, query.Message.MessageID, text, keyboard)
+		_, _ = h.Edit(edit, true)
+		return
+	}
+
+	doctor, err := h.getCRMDoctor(ctx, &record.DoctorID, query.Message, log)
+	if err != nil || doctor == nil {
+		return
+	}
+
+	var branchID *int64
+	if record.BranchID != 0 {
+		recordBranchID := int64(record.BranchID)
+		branchID = &recordBranchID
+	}
+	registerRepo := database.RegisterRepository{DB: h.db}
+	register, err := registerRepo.UpsertMoveRecord(ctx, database.Register{
+		UserID:        user.ID,
+		ChatID:        query.Message.Chat.ID,
+		MessageID:     query.Message.MessageID,
+		DoctorID:      &doctor.ID,
+		AppointmentID: &appointment.ID,
+		MoveRecordID:  &record.ID,
+		BranchID:      branchID,
+		Source:        callbackSource(recordData.Command),
+	})
+	if h.checkAndLogError(err, log, query.Message, "UpsertMoveRecord %d", record.ID) {
+		return
+	}
+
+	text := h.userTexts.CalendarHeader.Execute(CalendarData{Doctor: doctor.FIO, Appointment: appointment.Name})
+	h.ChangeTimesheet(ctx, query, h.nowTime.Now(), &text, doctor.ID, h.registerBranchID(register),
+		appointment.Time, "move_records")
+}
+
+func (h *TelegramBotHandler) ChangeToMoveRecordsMarkup(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "callback",
+		"func":   "ChangeToMoveRecordsMarkup",
+	})
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+	if user.DentalProID == nil {
+		h.checkAndLogError(fmt.Errorf("user %d has no dental pro id", user.ID), log, query.Message, "")
+		return
+	}
+
+	records, err := h.getCRMRecordsList(ctx, *user.DentalProID, query.Message, log)
+	if err != nil {
+		return
+	}
+
+	if len(records) == 0 {
+		edit := tgbotapi.NewEditMessageText(
+			query.Message.Chat.ID, query.Message.MessageID, h.userTexts.HasNoRecords)
+		_, _ = h.Edit(edit, true)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(
+		query.Message.Chat.ID, query.Message.MessageID, h.userTexts.MoveRecords,
+		h.createRecordsKeyboard(records, "move_r", h.userTexts.MoveRecordItem))
+	_, _ = h.Edit(edit, true)
+}
+
+// moveRecord сначала создает новую запись и только потом удаляет старую.
+// Если старую запись удалить не удалось, новая запись откатывается
+// moveDeleteTimeout ограничивает удаление записи при переносе
+const moveDeleteTimeout = 15 * time.Second
+
+// deleteRecordDetached удаляет запись не в контексте обновления: после создания новой записи
+// удаление старой и откат должны выполниться, даже если время обработки обновления вышло
+func (h *TelegramBotHandler) deleteRecordDetached(ctx context.Context, recordID int64) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), moveDeleteTimeout)
+	defer cancel()
+	_, err := h.dentalProClient.DeleteRecord(ctx, recordID)
+	return err
+}
+
+func (h *TelegramBotHandler) moveRecord(
+	ctx context.Context, query *tgbotapi.CallbackQuery,
+	register *database.Register,
+	crmDoctor *crm.Doctor,
+	appointment *crm.Appointment,
+	patient crm.Patient,
+	chooseDate, chooseTime time.Time,
+	log *logrus.Entry,
+) {
+	oldRecord, err := h.findCRMRecord(ctx, patient.ExternalID, *register.MoveRecordID, query.Message, log)
+	if err != nil {
+		return
+	}
+	if oldRecord == nil {
+		edit := tgbotapi.NewEditMessageText(
+			query.Message.Chat.ID, query.Message.MessageID, h.userTexts.HasNoMoveRecord)
+		_, _ = h.Edit(edit, true)
+		return
+	}
+
+	record, err := h.dentalProClient.RecordCreate(ctx,
+		chooseDate, chooseTime,
+		chooseTime.Add(time.Duration(appointment.Time)*time.Minute), crmDoctor.ID,
+		patient.ExternalID, appointment.ID, false,
+	)
+	if h.checkAndLogError(err, log, query.Message, "") {
+		return
+	}
+
+	err = h.deleteRecordDetached(ctx, oldRecord.ID)
+	if err != nil {
+		log.WithError(err).Errorf("move record %d failed, rollback record %d", oldRecord.ID, record.ID)
+		if rollbackErr := h.deleteRecordDetached(ctx, record.ID); rollbackErr != nil {
+			log.WithError(rollbackErr).WithFields(logrus.Fields{
+				"old_record_id": oldRecord.ID,
+				"new_record_id": record.ID,
+			}).Errorf("rollback failed: patient %d has both records %d and %d, delete record %d manually",
+				patient.ExternalID, oldRecord.ID, record.ID, record.ID)
+		}
+		edit := tgbotapi.NewEditMessageText(
+			query.Message.Chat.ID, query.Message.MessageID, h.userTexts.MoveRecordError)
+		_, _ = h.Edit(edit, true)
+		return
+	}
+
+	h.saveRecordStatus(ctx, query.From.ID, oldRecord.ID, database.RecordRescheduled, log)
+	h.markRegisterBooked(ctx, register.ID, record.ID, log)
+	h.closeWaitlist(ctx, register, appointment.ID, log)
+	h.saveBooking(ctx, query.From.ID, database.Booking{
+		RecordID:         record.ID,
+		Action:           database.BookingRescheduled,
+		Source:           register.Source,
+		DoctorID:         &crmDoctor.ID,
+		AppointmentID:    &appointment.ID,
+		BranchID:         register.BranchID,
+		Datetime:         register.Datetime,
+		PreviousRecordID: &oldRecord.ID,
+	}, log)
+	text := h.userTexts.MoveRecordSuccess.Execute(RecordCreatedData{
+		Date:        time.Time(record.Date).Format("2006-01-02"),
+		Time:        time.Time(record.TimeBegin).Format("15:04:05"),
+		Doctor:      crmDoctor.FIO,
+		Appointment: appointment.Name,
+		Duration:    appointment.Time,
+		LastName:    patient.Surname,
+		FirstName:   patient.Name,
+	})
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = HTML
+	_, _ = h.Edit(edit, true)
+}
+
+func (h *TelegramBotHandler) ReminderConfirmCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "callback",
+		"func":   "ReminderConfirmCallback",
+	})
+	recordData, err := h.parseTelegramRecordChangeCallback(query, log)
+	if err != nil {
+		return
+	}
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+	if user.DentalProID == nil {
+		h.checkAndLogError(fmt.Errorf("user %d has no dental pro id", user.ID), log, query.Message, "")
+		return
+	}
+
+	record, err := h.findCRMRecord(ctx, *user.DentalProID, recordData.RecordID, query.Message, log)
+	if err != nil {
+		return
+	}
+	if record == nil {
+		_, _ = h.Send(tgbotapi.NewMessage(query.Message.Chat.ID, h.userTexts.HasNoRecords), true)
+		return
+	}
+
+	confirmationRepo := database.RecordConfirmationRepository{DB: h.db}
+	err = confirmationRepo.Upsert(ctx, database.RecordConfirmation{
+		UserID:    user.ID,
+		RecordID:  record.ID,
+		Status:    database.RecordConfirmed,
+		UpdatedAt: h.nowTime.Now().UTC(),
+	})
+	if h.checkAndLogError(err, log, query.Message, "Upsert confirmation %d", record.ID) {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(
+		query.Message.Chat.ID, query.Message.MessageID, tgbotapi.NewInlineKeyboardMarkup())
+	_, _ = h.EditReplyMarkup(edit, false)
+
+	text := h.userTexts.ReminderConfirmed.Execute(RecordData{
+		Datetime: h.recordStart(*record).Format("2006-01-02 15:04"), Doctor: record.DoctorName})
+	_, _ = h.Send(tgbotapi.NewMessage(query.Message.Chat.ID, text), true)
+}
+
+// ReminderMoveCallback запускает перенос в новом сообщении, чтобы напоминание осталось в чате
+func (h *TelegramBotHandler) ReminderMoveCallback(
+	ctx context.Context, query *tgbotapi.CallbackQuery, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "callback",
+		"func":   "ReminderMoveCallback",
+	})
+
+	newMessage, err := h.Send(tgbotapi.NewMessage(query.Message.Chat.ID, h.userTexts.Wait), true)
+	if h.checkAndLogError(err, log, query.Message, "") {
+		return
+	}
+	moveQuery := *query
+	moveQuery.Message = newMessage
+	h.MoveRecordCallback(ctx, &moveQuery, chatState)
+}
+
+func (h *TelegramBotHandler) saveRecordStatus(
+	ctx context.Context, tgUserID, recordID int64, status string, log *logrus.Entry) {
+	userRepo := database.UserRepository{DB: h.db}
+	user, err := userRepo.GetUserByTelegramID(ctx, tgUserID)
+	if err != nil {
+		log.WithError(err).Errorf("GetUserByTelegramID %d", tgUserID)
+		return
+	}
+	confirmationRepo := database.RecordConfirmationRepository{DB: h.db}
+	err = confirmationRepo.Upsert(ctx, database.RecordConfirmation{
+		UserID:    user.ID,
+		RecordID:  recordID,
+		Status:    status,
+		UpdatedAt: h.nowTime.Now().UTC(),
+	})
+	if err != nil {
+		log.WithError(err).Errorf("save record %d status %s", recordID, status)
+	}
+}
+
+func calendarBack(register *database.Register) string {
+	if register.MoveRecordID != nil {
+		return "move_records"
+	}
+	return "appointments"
+}
+
+// markRegisterBooked запоминает созданную запись. Ошибка только логируется: запись в CRM уже создана
+func (h *TelegramBotHandler) markRegisterBooked(ctx context.Context, registerID, recordID int64, log *logrus.Entry) {
+	repository := database.RegisterRepository{DB: h.db}
+	err := repository.MarkBooked(ctx, registerID, recordID, h.nowTime.Now().UTC())
+	if err != nil {
+		log.WithError(err).Errorf("mark register %d booked with record %d", registerID, recordID)
+	}
+}
+
+// saveBooking записывает действие в историю. Ошибка только логируется: запись в CRM уже изменена
+func (h *TelegramBotHandler) saveBooking(
+	ctx context.Context, tgUserID int64, booking database.Booking, log *logrus.Entry) {
+	userRepo := database.UserRepository{DB: h.db}
+	user, err := userRepo.GetUserByTelegramID(ctx, tgUserID)
+	if err != nil {
+		log.WithError(err).Errorf("GetUserByTelegramID %d", tgUserID)
+		return
+	}
+	booking.UserID = user.ID
+	booking.CreatedAt = h.nowTime.Now().UTC()
+	if booking.Source == "" {
+		booking.Source = database.BookingSourceMenu
+	}
+	bookingsTotal.WithLabelValues(h.tenant, booking.Action, booking.Source).Inc()
+	repository := database.BookingRepository{DB: h.db}
+	if err := repository.Create(ctx, &booking); err != nil {
+		log.WithError(err).Errorf("save booking %s of record %d", booking.Action, booking.RecordID)
+	}
+}
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"sync"
+	"time"
+)
+
+// ChatStateStore хранит состояния чатов. Get возвращает nil, если состояния нет или истек его TTL
+type ChatStateStore interface {
+	Get(ctx context.Context, chatID int64) (*TelegramChatState, error)
+	Put(ctx context.Context, chatID int64, chatState *TelegramChatState) error
+	Delete(ctx context.Context, chatID int64) error
+	// Expire удаляет состояния, TTL которых истек к моменту now
+	Expire(ctx context.Context, now time.Time) error
+	// Count возвращает число хранимых состояний, включая еще не удаленные Expire
+	Count(ctx context.Context) (int, error)
+}
+
+type MemoryChatStateStore struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	states map[int64]TelegramChatState
+}
+
+type DBChatStateStore struct {
+	ttl        time.Duration
+	repository *database.ChatStateRepository
+}
+
+func NewMemoryChatStateStore(ttl time.Duration) *MemoryChatStateStore {
+	return &MemoryChatStateStore{ttl: ttl, states: map[int64]TelegramChatState{}}
+}
+
+// Get возвращает копию состояния, поэтому изменения нужно сохранять через Put
+func (s *MemoryChatStateStore) Get(_ context.Context, chatID int64) (*TelegramChatState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chatState, ok := s.states[chatID]
+	if !ok {
+		return nil, nil
+	}
+	if time.Since(chatState.Timestamp) > s.ttl {
+		delete(s.states, chatID)
+		return nil, nil
+	}
+	return &chatState, nil
+}
+
+func (s *MemoryChatStateStore) Put(_ context.Context, chatID int64, chatState *TelegramChatState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[chatID] = *chatState
+	return nil
+}
+
+func (s *MemoryChatStateStore) Delete(_ context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, chatID)
+	return nil
+}
+
+func (s *MemoryChatStateStore) Expire(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for chatID, chatState := range s.states {
+		if now.Sub(chatState.Timestamp) > s.ttl {
+			delete(s.states, chatID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryChatStateStore) Count(_ context.Context) (int, error) {
+	return s.Len(), nil
+}
+
+func (s *MemoryChatStateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+func NewDBChatStateStore(db *sql.DB, ttl time.Duration) *DBChatStateStore {
+	return &DBChatStateStore{ttl: ttl, repository: &database.ChatStateRepository{DB: db}}
+}
+
+func (s *DBChatStateStore) Get(ctx context.Context, chatID int64) (*TelegramChatState, error) {
+	state, err := s.repository.Get(ctx, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(state.UpdatedAt) > s.ttl {
+		return nil, nil
+	}
+	return chatStateFromDB(state), nil
+}
+
+func (s *DBChatStateStore) Put(ctx context.Context, chatID int64, chatState *TelegramChatState) error {
+	return s.repository.Upsert(ctx, chatStateToDB(chatID, chatState))
+}
+
+func (s *DBChatStateStore) Delete(ctx context.Context, chatID int64) error {
+	return s.repository.Delete(ctx, chatID)
+}
+
+func (s *DBChatStateStore) Expire(ctx context.Context, now time.Time) error {
+	return s.repository.DeleteOlderThan(ctx, now.Add(-s.ttl).UTC())
+}
+
+func (s *DBChatStateStore) Count(ctx context.Context) (int, error) {
+	return s.repository.Count(ctx)
+}
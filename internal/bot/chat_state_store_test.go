@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryChatStateStore(t *testing.T) {
+	store := NewMemoryChatStateStore(time.Hour)
+	ctx := context.Background()
+
+	chatState, err := store.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Nil(t, chatState)
+
+	chatState = &TelegramChatState{}
+	chatState.UpdateChatState(StepShowRecordsList, nil)
+	require.NoError(t, store.Put(ctx, 1, chatState))
+
+	chatState.UpdateChatState(StepDeleteRecord, nil)
+	stored, err := store.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, StepShowRecordsList, stored.Name, "store must keep its own copy")
+
+	require.NoError(t, store.Expire(ctx, time.Now().Add(2*time.Hour)))
+	stored, err = store.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+}
+
+func TestMemoryChatStateStoreConcurrent(t *testing.T) {
+	store := NewMemoryChatStateStore(time.Hour)
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+	for i := range 50 {
+		wg.Add(1)
+		go func(chatID int64) {
+			defer wg.Done()
+			chatState := &TelegramChatState{}
+			chatState.UpdateChatState(StepMoveRecord, nil)
+			_ = store.Put(ctx, chatID, chatState)
+			_, _ = store.Get(ctx, chatID)
+			_ = store.Expire(ctx, time.Now())
+			_ = store.Delete(ctx, chatID)
+		}(int64(i % 5))
+	}
+	wg.Wait()
+	require.Equal(t, 0, store.Len())
+}
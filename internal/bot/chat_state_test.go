@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/internal/crm"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestChatStateRoundTrip(t *testing.T) {
+	record := crm.ShortRecord{
+		ID:         7,
+		DateStart:  crm.DateTimeYMDHMS(time.Date(2024, 11, 9, 18, 0, 0, 0, time.UTC)),
+		DateEnd:    crm.DateTimeYMDHMS(time.Date(2024, 11, 9, 19, 0, 0, 0, time.UTC)),
+		DoctorID:   2,
+		DoctorName: "Подаева С.Е.",
+	}
+	chatState := &TelegramChatState{}
+	chatState.UpdateChatState(StepApproveRecord, &record)
+
+	state := chatStateToDB(1, chatState)
+	restored := chatStateFromDB(&state)
+	require.Equal(t, StepApproveRecord, restored.Name)
+
+	var restoredRecord crm.ShortRecord
+	require.NoError(t, json.Unmarshal(restored.Payload, &restoredRecord))
+	require.Equal(t, record, restoredRecord)
+}
+
+func TestChatStateApproveRecordPayload(t *testing.T) {
+	record := crm.ShortRecord{ID: 7, DoctorID: 2, DoctorName: "Подаева С.Е."}
+	chatState := &TelegramChatState{}
+	chatState.UpdateChatState(StepApproveRecord, &approveRecordPayload{
+		ShortRecord: record,
+		Source:      callbackSource("rem_del"),
+	})
+
+	var payload approveRecordPayload
+	require.NoError(t, json.Unmarshal(chatState.Payload, &payload))
+	require.Equal(t, record, payload.ShortRecord)
+	require.Equal(t, database.BookingSourceReminder, payload.Source)
+
+	// Состояние, сохраненное до появления источника, содержит только запись
+	legacy, err := json.Marshal(&record)
+	require.NoError(t, err)
+	payload = approveRecordPayload{}
+	require.NoError(t, json.Unmarshal(legacy, &payload))
+	require.Equal(t, record, payload.ShortRecord)
+	require.Empty(t, payload.Source)
+	require.Equal(t, database.BookingSourceMenu, callbackSource("del_r"))
+}
+
+func TestChatStateOnSuccessPayload(t *testing.T) {
+	onSuccess := NewChatStep(StepMoveRecordCallback, callbackQueryPayload{
+		FromID: 1, ChatID: 2, MessageID: 3, Data: `{"command":"move_r","r":5}`,
+	})
+	step := NewChatStep(StepNoAuthRequest, onSuccessPayload{&onSuccess})
+
+	var data onSuccessPayload
+	require.NoError(t, json.Unmarshal(step.Payload, &data))
+	require.Equal(t, StepMoveRecordCallback, data.OnSuccess.Name)
+
+	var queryData callbackQueryPayload
+	require.NoError(t, json.Unmarshal(data.OnSuccess.Payload, &queryData))
+	query := queryData.Query()
+	require.Equal(t, int64(1), query.From.ID)
+	require.Equal(t, int64(2), query.Message.Chat.ID)
+	require.Equal(t, 3, query.Message.MessageID)
+	require.Equal(t, `{"command":"move_r","r":5}`, query.Data)
+}
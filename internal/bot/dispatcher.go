@@ -0,0 +1,122 @@
+package bot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatcherStats - счетчики очереди обновлений
+type DispatcherStats struct {
+	// Queued - обновления, ожидающие обработки
+	Queued int64
+	// ActiveWorkers - занятые воркеры
+	ActiveWorkers int64
+	// WaitingChats - чаты, ожидающие свободного воркера
+	WaitingChats int64
+	// Processed - всего обработано обновлений
+	Processed uint64
+	// Throttled - сколько раз чату пришлось ждать свободного воркера
+	Throttled uint64
+}
+
+// chatDispatcher обрабатывает обновления одного чата строго по очереди,
+// а разные чаты - параллельно, не более чем в workers горутинах
+type chatDispatcher struct {
+	process func(update tgbotapi.Update)
+	workers chan struct{}
+
+	mu     sync.Mutex
+	queues map[int64][]tgbotapi.Update
+
+	queued        atomic.Int64
+	activeWorkers atomic.Int64
+	waitingChats  atomic.Int64
+	processed     atomic.Uint64
+	throttled     atomic.Uint64
+}
+
+func newChatDispatcher(workers int, process func(update tgbotapi.Update)) *chatDispatcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &chatDispatcher{
+		process: process,
+		workers: make(chan struct{}, workers),
+		queues:  map[int64][]tgbotapi.Update{},
+	}
+}
+
+// Submit ставит обновление в очередь чата. Если все воркеры заняты, Submit блокируется,
+// пока воркер не освободится, тем самым замедляя получение новых обновлений
+func (d *chatDispatcher) Submit(update tgbotapi.Update) {
+	chatID := updateChatID(update)
+	d.queued.Add(1)
+
+	d.mu.Lock()
+	queue, running := d.queues[chatID]
+	d.queues[chatID] = append(queue, update)
+	d.mu.Unlock()
+	if running {
+		return
+	}
+
+	select {
+	case d.workers <- struct{}{}:
+	default:
+		d.throttled.Add(1)
+		d.waitingChats.Add(1)
+		logrus.WithFields(logrus.Fields{
+			"module": "bot.dispatcher",
+			"func":   "Submit",
+		}).Warnf("all %d workers are busy, chat %d is waiting", cap(d.workers), chatID)
+		d.workers <- struct{}{}
+		d.waitingChats.Add(-1)
+	}
+	d.activeWorkers.Add(1)
+	go d.run(chatID)
+}
+
+func (d *chatDispatcher) run(chatID int64) {
+	defer func() {
+		d.activeWorkers.Add(-1)
+		<-d.workers
+	}()
+	for {
+		d.mu.Lock()
+		queue := d.queues[chatID]
+		if len(queue) == 0 {
+			delete(d.queues, chatID)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.queues[chatID] = queue[1:]
+		d.mu.Unlock()
+
+		d.process(update)
+		d.queued.Add(-1)
+		d.processed.Add(1)
+	}
+}
+
+func (d *chatDispatcher) Stats() DispatcherStats {
+	return DispatcherStats{
+		Queued:        d.queued.Load(),
+		ActiveWorkers: d.activeWorkers.Load(),
+		WaitingChats:  d.waitingChats.Load(),
+		Processed:     d.processed.Load(),
+		Throttled:     d.throttled.Load(),
+	}
+}
+
+func updateChatID(update tgbotapi.Update) int64 {
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
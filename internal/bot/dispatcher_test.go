@@ -0,0 +1,77 @@
+package bot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func chatUpdate(chatID int64, updateID int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestChatDispatcherKeepsChatOrder(t *testing.T) {
+	mu := sync.Mutex{}
+	got := map[int64][]int{}
+	wg := sync.WaitGroup{}
+	dispatcher := newChatDispatcher(3, func(update tgbotapi.Update) {
+		defer wg.Done()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := update.Message.Chat.ID
+		got[chatID] = append(got[chatID], update.UpdateID)
+	})
+
+	for i := range 20 {
+		for chatID := int64(1); chatID <= 5; chatID++ {
+			wg.Add(1)
+			dispatcher.Submit(chatUpdate(chatID, i))
+		}
+	}
+	wg.Wait()
+
+	for chatID := int64(1); chatID <= 5; chatID++ {
+		require.Len(t, got[chatID], 20)
+		for i, updateID := range got[chatID] {
+			require.Equal(t, i, updateID, "chat %d processed out of order", chatID)
+		}
+	}
+	require.Eventually(t, func() bool {
+		stats := dispatcher.Stats()
+		return stats.ActiveWorkers == 0 && stats.Queued == 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, uint64(100), dispatcher.Stats().Processed)
+}
+
+func TestChatDispatcherLimitsWorkers(t *testing.T) {
+	var running, maxRunning atomic.Int64
+	wg := sync.WaitGroup{}
+	dispatcher := newChatDispatcher(2, func(update tgbotapi.Update) {
+		defer wg.Done()
+		current := running.Add(1)
+		for {
+			old := maxRunning.Load()
+			if current <= old || maxRunning.CompareAndSwap(old, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	})
+
+	for chatID := int64(1); chatID <= 6; chatID++ {
+		wg.Add(1)
+		dispatcher.Submit(chatUpdate(chatID, 0))
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, maxRunning.Load(), int64(2))
+	require.Positive(t, dispatcher.Stats().Throttled)
+}
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,7 +10,6 @@ import (
 	"github.com/AnVladic/DentalTelegramBot/pkg"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"strings"
 	"time"
 )
@@ -17,33 +17,81 @@ import (
 type TelegramBotHandler struct {
 	bot             TelegramBotAPIWrapper
 	userTexts       UserTexts
+	catalog         *Catalog
 	dentalProClient crm.IDentalProClient
 	db              *sql.DB
-	branchID        int64
+	branches        []Branch
 	location        *time.Location
 	nowTime         TimeProvider
+	steps           map[string]StepMethod
+	admins          map[int64]bool
+	broadcaster     *Broadcaster
+	// tenant - клиника, которую обслуживает обработчик. Метка tenant метрик
+	tenant string
 }
 
-type HandlerMethod func(message *tgbotapi.Message, chatState *TelegramChatState)
-
 func NewTelegramBotHandler(
 	bot TelegramBotAPIWrapper,
 	userTexts UserTexts,
 	dentalProClient crm.IDentalProClient,
 	db *sql.DB,
-	branchID int64,
+	branches []Branch,
 	location *time.Location,
 	nowTime TimeProvider,
 ) *TelegramBotHandler {
 	handler := &TelegramBotHandler{
-		bot: bot, userTexts: userTexts, dentalProClient: dentalProClient, db: db, branchID: branchID,
-		location: location, nowTime: nowTime,
+		bot: bot, userTexts: userTexts, dentalProClient: dentalProClient, db: db, branches: branches,
+		location: location, nowTime: nowTime, steps: newChatSteps(),
 	}
 	return handler
 }
 
-func (h *TelegramBotHandler) StartCommandHandler(message *tgbotapi.Message, chatState *TelegramChatState) {
-	logrus.Print("/start command")
+// SetTenant задает имя клиники для метрик
+func (h *TelegramBotHandler) SetTenant(tenant string) {
+	h.tenant = tenant
+}
+
+// SetCatalog включает выбор языка: без каталога все пользователи получают тексты из конструктора
+func (h *TelegramBotHandler) SetCatalog(catalog *Catalog) {
+	h.catalog = catalog
+}
+
+// WithLanguage возвращает копию обработчика с текстами на языке language
+func (h *TelegramBotHandler) WithLanguage(language string) *TelegramBotHandler {
+	if h.catalog == nil {
+		return h
+	}
+	handler := *h
+	handler.userTexts = *h.catalog.Texts(language)
+	return &handler
+}
+
+// ForUser выбирает язык пользователя: сохраненный командой /language, иначе язык его Telegram
+func (h *TelegramBotHandler) ForUser(ctx context.Context, user *tgbotapi.User) *TelegramBotHandler {
+	if h.catalog == nil || user == nil {
+		return h
+	}
+	return h.WithLanguage(h.userLanguage(ctx, user.ID, user.LanguageCode))
+}
+
+func (h *TelegramBotHandler) userLanguage(ctx context.Context, tgUserID int64, languageCode string) string {
+	repository := database.UserRepository{DB: h.db}
+	language, err := repository.GetLanguage(ctx, tgUserID)
+	if err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Errorf("GetLanguage %d", tgUserID)
+	}
+	if language != nil && h.catalog.Has(*language) {
+		return *language
+	}
+	if h.catalog.Has(languageCode) {
+		return languageCode
+	}
+	return h.catalog.DefaultLanguage()
+}
+
+func (h *TelegramBotHandler) StartCommandHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	pkg.LoggerFromContext(ctx).Info("/start command")
 	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Welcome)
 
 	user := database.User{
@@ -51,13 +99,16 @@ func (h *TelegramBotHandler) StartCommandHandler(message *tgbotapi.Message, chat
 		TgUserID: message.From.ID,
 	}
 	repository := database.UserRepository{DB: h.db}
-	_, _, err := repository.GetOrCreateByTelegramID(user)
+	_, _, err := repository.GetOrCreateByTelegramID(ctx, user)
 	if err != nil {
-		logrus.Error(err)
+		pkg.LoggerFromContext(ctx).Error(err)
 		response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.InternalError)
 		_, _ = h.Send(response, false)
 		return
 	}
+	if err := repository.ClearBlocked(ctx, message.From.ID); err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Errorf("ClearBlocked %d", message.From.ID)
+	}
 	_, _ = h.Send(response, true)
 
 	time.Sleep(3 * time.Second)
@@ -65,9 +116,10 @@ func (h *TelegramBotHandler) StartCommandHandler(message *tgbotapi.Message, chat
 	_, _ = h.Send(response, true)
 }
 
-func (h *TelegramBotHandler) RegisterCommandHandler(message *tgbotapi.Message, chatState *TelegramChatState) {
-	logrus.Print("/register command")
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) RegisterCommandHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	pkg.LoggerFromContext(ctx).Info("/register command")
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot",
 		"func":   "RegisterCommandHandler",
 	})
@@ -77,29 +129,29 @@ func (h *TelegramBotHandler) RegisterCommandHandler(message *tgbotapi.Message, c
 	if h.checkAndLogError(err, log, message, "") {
 		return
 	}
-	h.ChangeToDoctorsMarkup(newMsg)
+	h.ChangeToBranchesOrDoctorsMarkup(ctx, newMsg)
 }
 
-func (h *TelegramBotHandler) CancelCommandHandler(message *tgbotapi.Message, chatState *TelegramChatState) {
-	logrus.Print("/cancel command")
-	chatState.UpdateChatState(nil)
+func (h *TelegramBotHandler) CancelCommandHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	pkg.LoggerFromContext(ctx).Info("/cancel command")
+	chatState.Clear()
 	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Cancel)
 	response.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
 	_, _ = h.Send(response, true)
 }
 
-func (h *TelegramBotHandler) UnknownCommandHandler(message *tgbotapi.Message, chatState *TelegramChatState) {
-	logrus.Print("/unknown command")
+func (h *TelegramBotHandler) UnknownCommandHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	pkg.LoggerFromContext(ctx).Info("/unknown command")
 	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.Welcome)
 	_, _ = h.Send(response, true)
 }
 
 func (h *TelegramBotHandler) GetPhoneNumber(
-	message *tgbotapi.Message, chatState *TelegramChatState) (bool, error) {
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) (bool, error) {
 	if message.Contact == nil {
-		text := "📲 Пожалуйста, нажмите кнопку <b>📞 Отправить номер телефона</b>, \n\n" +
-			"Если передумали, введите команду /cancel ❌"
-		response := tgbotapi.NewMessage(message.Chat.ID, text)
+		response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.SendPhoneRequest)
 		response.ReplyMarkup = h.RequestContactKeyboard()
 		response.ParseMode = "HTML"
 		_, _ = h.Send(response, true)
@@ -107,11 +159,11 @@ func (h *TelegramBotHandler) GetPhoneNumber(
 	}
 
 	repository := database.UserRepository{DB: h.db}
-	err := repository.UpsertContactByTelegramID(
+	err := repository.UpsertContactByTelegramID(ctx,
 		message.From.ID, message.Contact.FirstName, message.Contact.LastName, message.Contact.PhoneNumber,
 	)
 	if err != nil {
-		logrus.Error(err)
+		pkg.LoggerFromContext(ctx).Error(err)
 		response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.InternalError)
 		_, _ = h.Send(response, false)
 		return false, err
@@ -120,37 +172,33 @@ func (h *TelegramBotHandler) GetPhoneNumber(
 }
 
 func (h *TelegramBotHandler) NoAuthChangeNameHandler(
-	message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *HandlerMethod) {
-	ok, err := h.GetPhoneNumber(message, chatState)
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *ChatStep) {
+	ok, err := h.GetPhoneNumber(ctx, message, chatState)
 	if err != nil {
 		_ = fmt.Errorf("GetPhoneNumber error %w", err)
 		return
 	}
 	if !ok {
-		chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-			h.NoAuthChangeNameHandler(message, chatState, onSuccess)
-		})
+		chatState.UpdateChatState(StepNoAuthChangeName, onSuccessPayload{onSuccess})
 		return
 	}
 	msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.ContactsAddedSuccess)
 	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-	h.ChangeNameHandler(message, chatState, onSuccess)
+	h.ChangeNameHandler(ctx, message, chatState, onSuccess)
 }
 
 func (h *TelegramBotHandler) ChangeNameHandler(
-	message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *HandlerMethod) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *ChatStep) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "ChangeNameHandler",
 	})
 
 	repository := database.UserRepository{DB: h.db}
-	user, err := repository.GetUserByTelegramID(message.From.ID)
+	user, err := repository.GetUserByTelegramID(ctx, message.From.ID)
 	if errors.Is(err, sql.ErrNoRows) || user.Phone == nil || *user.Phone == "" {
 		h.RequestPhoneNumber(message)
-		chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-			h.NoAuthChangeNameHandler(message, chatState, onSuccess)
-		})
+		chatState.UpdateChatState(StepNoAuthChangeName, onSuccessPayload{onSuccess})
 		return
 	} else if h.checkAndLogError(err, log, message, "") {
 		return
@@ -159,59 +207,56 @@ func (h *TelegramBotHandler) ChangeNameHandler(
 	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.ChangeFirstNameRequest)
 	_, _ = h.Send(response, true)
 
-	chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-		h.ChangeFirstNameHandler(message, chatState, onSuccess)
-	})
+	chatState.UpdateChatState(StepChangeFirstName, onSuccessPayload{onSuccess})
 }
 
 func (h *TelegramBotHandler) ChangeLastNameHandler(
-	message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *HandlerMethod) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *ChatStep) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "ChangeLastNameHandler",
 	})
 
 	repository := database.UserRepository{DB: h.db}
-	err := repository.UpdateLastName(message.From.ID, message.Text)
+	err := repository.UpdateLastName(ctx, message.From.ID, message.Text)
 	if h.checkAndLogError(err, log, message, "") {
 		return
 	}
-	user, err := repository.GetUserByTelegramID(message.From.ID)
+	user, err := repository.GetUserByTelegramID(ctx, message.From.ID)
 	if h.checkAndLogError(err, log, message, "") {
 		return
 	}
 
-	patient, err := h.upsertCRMPatient(crm.Patient{
+	patient, err := h.upsertCRMPatient(ctx, crm.Patient{
 		Phone: *user.Phone, Name: *user.Name, Surname: *user.Lastname}, message, log)
 	if err != nil {
 		return
 	}
 
-	err = h.updateDentalProID(message.From.ID, patient.ExternalID, message, log)
+	err = h.updateDentalProID(ctx, message.From.ID, patient.ExternalID, message, log)
 	if err != nil {
 		return
 	}
 
-	text := fmt.Sprintf(
-		h.userTexts.ChangeNameSucceed, patient.Surname, patient.Name)
+	text := h.userTexts.ChangeNameSucceed.Execute(NameData{LastName: patient.Surname, FirstName: patient.Name})
 	response := tgbotapi.NewMessage(message.Chat.ID, text)
 	response.ParseMode = "HTML"
 	_, _ = h.Send(response, true)
 
 	if onSuccess != nil {
-		(*onSuccess)(message, chatState)
+		h.RunStep(ctx, *onSuccess, message, chatState)
 	}
 }
 
 func (h *TelegramBotHandler) ChangeFirstNameHandler(
-	message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *HandlerMethod) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *ChatStep) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "ChangeFirstNameHandler",
 	})
 
 	repository := database.UserRepository{DB: h.db}
-	err := repository.UpdateFirstName(message.From.ID, message.Text)
+	err := repository.UpdateFirstName(ctx, message.From.ID, message.Text)
 	if h.checkAndLogError(err, log, message, "") {
 		return
 	}
@@ -219,37 +264,35 @@ func (h *TelegramBotHandler) ChangeFirstNameHandler(
 	response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.ChangeLastNameRequest)
 	_, _ = h.Send(response, true)
 
-	chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-		h.ChangeLastNameHandler(message, chatState, onSuccess)
-	})
+	chatState.UpdateChatState(StepChangeLastName, onSuccessPayload{onSuccess})
 }
 
 func (h *TelegramBotHandler) ShowRecordsListHandler(
-	message *tgbotapi.Message, chatState *TelegramChatState) {
-	log := logrus.WithFields(logrus.Fields{
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "ShowRecordsListHandler",
 	})
 
-	user, err := h.findUserAndCheckPhoneNumber(
-		h.ShowRecordsListHandler, chatState, message.From.ID, message, log)
+	user, err := h.findUserAndCheckPhoneNumber(ctx,
+		NewChatStep(StepShowRecordsList, nil), chatState, message.From.ID, message, log)
 	if err != nil {
 		return
 	}
 
-	patient, _, err := h.getOrCreatePatient(*user.Name, *user.Lastname, *user.Phone, message, log)
+	patient, _, err := h.getOrCreatePatient(ctx, *user.Name, *user.Lastname, *user.Phone, message, log)
 	if err != nil {
 		return
 	}
 
 	if user.DentalProID == nil {
 		user.DentalProID = &patient.ExternalID
-		if h.updateDentalProID(message.From.ID, patient.ExternalID, message, log) != nil {
+		if h.updateDentalProID(ctx, message.From.ID, patient.ExternalID, message, log) != nil {
 			return
 		}
 	}
 
-	records, err := h.getCRMRecordsList(patient.ExternalID, message, log)
+	records, err := h.getCRMRecordsList(ctx, patient.ExternalID, message, log)
 	if err != nil {
 		return
 	}
@@ -262,14 +305,14 @@ func (h *TelegramBotHandler) ShowRecordsListHandler(
 
 	rectorsTexts := make([]string, len(records))
 	for i, record := range records {
-		rectorsTexts[i] = fmt.Sprintf(h.userTexts.RecordItem,
-			i+1,
-			time.Time(record.DateStart).Format("2006-01-02 15:04"),
-			record.DoctorName,
-			record.DoctorGroup,
-			record.Name,
-			record.Duration,
-		)
+		rectorsTexts[i] = h.userTexts.RecordItem.Execute(RecordItemData{
+			Number:      i + 1,
+			Datetime:    time.Time(record.DateStart).Format("2006-01-02 15:04"),
+			Doctor:      record.DoctorName,
+			DoctorGroup: record.DoctorGroup,
+			Appointment: record.Name,
+			Duration:    record.Duration,
+		})
 	}
 	text := h.userTexts.RecordList + strings.Join(rectorsTexts, "\n\n")
 	response := tgbotapi.NewMessage(message.Chat.ID, text)
@@ -277,24 +320,25 @@ func (h *TelegramBotHandler) ShowRecordsListHandler(
 	_, _ = h.Send(response, true)
 }
 
-func (h *TelegramBotHandler) DeleteRecordHandler(message *tgbotapi.Message, chatState *TelegramChatState) {
-	log := logrus.WithFields(logrus.Fields{
+func (h *TelegramBotHandler) DeleteRecordHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "DeleteRecordHandler",
 	})
 
-	user, err := h.findUserAndCheckPhoneNumber(
-		h.DeleteRecordHandler, chatState, message.From.ID, message, log)
+	user, err := h.findUserAndCheckPhoneNumber(ctx,
+		NewChatStep(StepDeleteRecord, nil), chatState, message.From.ID, message, log)
 	if err != nil {
 		return
 	}
 
-	_, err = h.getDentalProIDByUser(user, message, log)
+	_, err = h.getDentalProIDByUser(ctx, user, message, log)
 	if err != nil {
 		return
 	}
 
-	records, err := h.getCRMRecordsList(*user.DentalProID, message, log)
+	records, err := h.getCRMRecordsList(ctx, *user.DentalProID, message, log)
 	if err != nil {
 		return
 	}
@@ -305,27 +349,50 @@ func (h *TelegramBotHandler) DeleteRecordHandler(message *tgbotapi.Message, chat
 		return
 	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup()
-	keyboard.InlineKeyboard = make([][]tgbotapi.InlineKeyboardButton, len(records))
-	for i, record := range records {
-		datetime := time.Time(record.DateStart)
-		keyboard.InlineKeyboard[i] = []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(
-			fmt.Sprintf(
-				h.userTexts.DeleteRecordItem, i+1, datetime.Format("2006-01-02 15:04"),
-				record.DoctorName),
-			fmt.Sprintf(`{"command":"del_r","r":%d}`, record.ID)),
-		}
+	msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.DeleteRecords)
+	msg.ReplyMarkup = h.createRecordsKeyboard(records, "del_r", h.userTexts.DeleteRecordItem)
+	_, _ = h.Send(msg, true)
+}
+
+func (h *TelegramBotHandler) MoveRecordHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.handler",
+		"func":   "MoveRecordHandler",
+	})
+
+	user, err := h.findUserAndCheckPhoneNumber(ctx,
+		NewChatStep(StepMoveRecord, nil), chatState, message.From.ID, message, log)
+	if err != nil {
+		return
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.DeleteRecords)
-	msg.ReplyMarkup = keyboard
+	_, err = h.getDentalProIDByUser(ctx, user, message, log)
+	if err != nil {
+		return
+	}
+
+	records, err := h.getCRMRecordsList(ctx, *user.DentalProID, message, log)
+	if err != nil {
+		return
+	}
+
+	if len(records) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.HasNoRecords)
+		_, _ = h.Send(msg, true)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.MoveRecords)
+	msg.ReplyMarkup = h.createRecordsKeyboard(records, "move_r", h.userTexts.MoveRecordItem)
 	_, _ = h.Send(msg, true)
 }
 
 func (h *TelegramBotHandler) ApproveRecordHandler(
-	record crm.ShortRecord, message *tgbotapi.Message, chatState *TelegramChatState,
+	ctx context.Context, payload approveRecordPayload, message *tgbotapi.Message, chatState *TelegramChatState,
 ) {
-	log := logrus.WithFields(logrus.Fields{
+	record := payload.ShortRecord
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.handler",
 		"func":   "ApproveRecordHandler",
 	})
@@ -335,41 +402,43 @@ func (h *TelegramBotHandler) ApproveRecordHandler(
 	datetime := time.Time(record.DateStart)
 
 	if pkg.IsMatchIgnoreCase(message.Text, h.userTexts.NegativeAnswers) {
-		text := fmt.Sprintf(h.userTexts.CancelDeleteRecord,
-			datetime.Format("2006-01-02 15:04"),
-			record.DoctorName,
-		)
+		text := h.userTexts.CancelDeleteRecord.Execute(RecordData{
+			Datetime: datetime.Format("2006-01-02 15:04"),
+			Doctor:   record.DoctorName,
+		})
 		msg = tgbotapi.NewMessage(message.Chat.ID, text)
 	} else if pkg.IsMatchIgnoreCase(message.Text, h.userTexts.PositiveAnswers) {
-		response, err := h.dentalProClient.DeleteRecord(record.ID)
+		_, err := h.dentalProClient.DeleteRecord(ctx, record.ID)
 		if h.checkAndLogError(err, log, message, "") {
 			return
 		}
-		if !response.Status {
-			err = &crm.RequestError{
-				Code: http.StatusNotFound,
-				Err:  fmt.Errorf(response.Message),
-			}
+		h.saveRecordStatus(ctx, message.From.ID, record.ID, database.RecordCancelled, log)
+		doctorID, branchID := record.DoctorID, int64(record.BranchID)
+		booking := database.Booking{
+			RecordID: record.ID,
+			Action:   database.BookingCancelled,
+			Source:   payload.Source,
+			DoctorID: &doctorID,
+			Datetime: &datetime,
 		}
-		if h.checkAndLogError(err, log, message, "") {
-			return
+		if branchID != 0 {
+			booking.BranchID = &branchID
 		}
-		text := fmt.Sprintf(h.userTexts.SuccessDeleteRecord,
-			datetime.Format("2006-01-02 15:04"),
-			record.DoctorName,
-		)
+		h.saveBooking(ctx, message.From.ID, booking, log)
+		text := h.userTexts.SuccessDeleteRecord.Execute(RecordData{
+			Datetime: datetime.Format("2006-01-02 15:04"),
+			Doctor:   record.DoctorName,
+		})
 		msg = tgbotapi.NewMessage(message.Chat.ID, text)
 	} else {
 		msg = tgbotapi.NewMessage(message.Chat.ID, h.userTexts.UnknownApproveDeleteRecord)
 		keyboard := tgbotapi.NewReplyKeyboard([]tgbotapi.KeyboardButton{
-			tgbotapi.NewKeyboardButton("✅ Подтвердить"),
-			tgbotapi.NewKeyboardButton("Отменить"),
+			tgbotapi.NewKeyboardButton(h.userTexts.Approve),
+			tgbotapi.NewKeyboardButton(h.userTexts.CancelButton),
 		})
 		keyboard.OneTimeKeyboard = true
 		msg.ReplyMarkup = keyboard
-		chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-			h.ApproveRecordHandler(record, message, chatState)
-		})
+		chatState.UpdateChatState(StepApproveRecord, &payload)
 	}
 	_, _ = h.Send(msg, true)
 }
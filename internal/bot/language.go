@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type TelegramLanguageCallbackData struct {
+	CallbackData
+	Language string `json:"l"`
+}
+
+// LanguageCommandHandler показывает языки, на которых есть тексты бота
+func (h *TelegramBotHandler) LanguageCommandHandler(
+	ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState) {
+	if h.catalog == nil {
+		h.UnknownCommandHandler(ctx, message, chatState)
+		return
+	}
+	keyboard := tgbotapi.InlineKeyboardMarkup{}
+	for _, language := range h.catalog.Languages() {
+		data, _ := json.Marshal(TelegramLanguageCallbackData{CallbackData{"lang"}, language})
+		button := tgbotapi.NewInlineKeyboardButtonData(h.catalog.Texts(language).LanguageName, string(data))
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.LanguageChoose)
+	msg.ReplyMarkup = keyboard
+	_, _ = h.Send(msg, true)
+}
+
+// LanguageCallback сохраняет выбранный язык и отвечает уже на нем
+func (h *TelegramBotHandler) LanguageCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.language",
+		"func":   "LanguageCallback",
+	})
+	var callbackData TelegramLanguageCallbackData
+	err := json.Unmarshal([]byte(query.Data), &callbackData)
+	if h.checkAndLogError(err, log, query.Message, "TelegramLanguageCallbackData Unmarshal error") {
+		return
+	}
+	if h.catalog == nil || !h.catalog.Has(callbackData.Language) {
+		log.Errorf("unknown language %q", callbackData.Language)
+		return
+	}
+
+	if _, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log); err != nil {
+		return
+	}
+	repository := database.UserRepository{DB: h.db}
+	err = repository.SetLanguage(ctx, query.From.ID, callbackData.Language)
+	if h.checkAndLogError(err, log, query.Message, "SetLanguage %s", callbackData.Language) {
+		return
+	}
+
+	h = h.WithLanguage(callbackData.Language)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, h.userTexts.LanguageChanged)
+	_, _ = h.Edit(edit, true)
+}
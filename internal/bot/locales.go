@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultLanguage - язык встроенного каталога, из которого берутся тексты, отсутствующие в других языках
+const DefaultLanguage = "ru"
+
+//go:embed locales/*.yaml
+var localeFiles embed.FS
+
+// Catalog - тексты бота на всех языках. Язык - имя файла каталога без расширения.
+// Каталог можно перечитать через Reload, пока бот работает
+type Catalog struct {
+	dir             string
+	defaultLanguage string
+
+	mu    sync.RWMutex
+	texts map[string]*UserTexts
+	// version - размеры и время изменения файлов dir при последнем Reload, см. Changed
+	version string
+}
+
+// LoadCatalog читает встроенные каталоги и, если задан dir, файлы <язык>.yaml из него.
+// Файл из dir может содержать только часть ключей: остальные берутся из встроенного каталога
+// того же языка или из DefaultLanguage
+func LoadCatalog(dir string, defaultLanguage string) (*Catalog, error) {
+	catalog := &Catalog{dir: dir, defaultLanguage: defaultLanguage}
+	if err := catalog.Reload(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// Reload заново читает и проверяет каталоги. При ошибке остаются прежние тексты
+func (c *Catalog) Reload() error {
+	version, err := c.dirVersion()
+	if err != nil {
+		return err
+	}
+	texts, err := c.load()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Версию запоминаем и при ошибке, чтобы Changed не сообщал о той же ошибке на каждой проверке
+	c.version = version
+	if err != nil {
+		return err
+	}
+	c.texts = texts
+	return nil
+}
+
+func (c *Catalog) load() (map[string]*UserTexts, error) {
+	texts := map[string]*UserTexts{}
+	if err := loadTexts(texts, localeFiles, "locales"); err != nil {
+		return nil, err
+	}
+	if c.dir != "" {
+		if err := loadTexts(texts, os.DirFS(c.dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := texts[c.defaultLanguage]; !ok {
+		return nil, fmt.Errorf("language %q: no catalog file", c.defaultLanguage)
+	}
+	languages := make([]string, 0, len(texts))
+	for language := range texts {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		if err := texts[language].validate(); err != nil {
+			return nil, fmt.Errorf("language %q: %w", language, err)
+		}
+	}
+	return texts, nil
+}
+
+// Changed сообщает, менялись ли файлы dir после последнего Reload
+func (c *Catalog) Changed() (bool, error) {
+	version, err := c.dirVersion()
+	if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return version != c.version, nil
+}
+
+func (c *Catalog) dirVersion() (string, error) {
+	if c.dir == "" {
+		return "", nil
+	}
+	info, err := os.Stat(c.dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", c.dir)
+	}
+	names, err := filepath.Glob(filepath.Join(c.dir, "*.yaml"))
+	if err != nil {
+		return "", err
+	}
+	var version strings.Builder
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(&version, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return version.String(), nil
+}
+
+func loadTexts(texts map[string]*UserTexts, files fs.FS, dir string) error {
+	names, err := fs.Glob(files, path.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	// Сначала язык по умолчанию, чтобы остальные языки дополнялись из него
+	sort.SliceStable(names, func(i, j int) bool {
+		return languageOf(names[i]) == DefaultLanguage && languageOf(names[j]) != DefaultLanguage
+	})
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		language := languageOf(name)
+		languageTexts := &UserTexts{}
+		if base, ok := texts[language]; ok {
+			languageTexts = base.clone()
+		} else if base, ok := texts[DefaultLanguage]; ok {
+			languageTexts = base.clone()
+		}
+		if err := yaml.Unmarshal(data, languageTexts); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		languageTexts.Language = language
+		texts[language] = languageTexts
+	}
+	return nil
+}
+
+// Texts возвращает тексты на языке language. Код вида en-US сводится к en,
+// для неизвестного языка возвращается язык по умолчанию
+func (c *Catalog) Texts(language string) *UserTexts {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if texts, ok := c.texts[normalizeLanguage(language)]; ok {
+		return texts.clone()
+	}
+	return c.texts[c.defaultLanguage].clone()
+}
+
+func (c *Catalog) Has(language string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.texts[normalizeLanguage(language)]
+	return ok
+}
+
+func (c *Catalog) DefaultLanguage() string {
+	return c.defaultLanguage
+}
+
+// Languages возвращает коды языков: сначала язык по умолчанию, затем остальные по алфавиту
+func (c *Catalog) Languages() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	languages := make([]string, 0, len(c.texts))
+	for language := range c.texts {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if (languages[i] == c.defaultLanguage) != (languages[j] == c.defaultLanguage) {
+			return languages[i] == c.defaultLanguage
+		}
+		return languages[i] < languages[j]
+	})
+	return languages
+}
+
+func languageOf(name string) string {
+	return normalizeLanguage(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+}
+
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return language
+}
+
+// clone копирует тексты, чтобы загрузка другого языка не меняла общие срезы и карты
+func (t *UserTexts) clone() *UserTexts {
+	texts := *t
+	texts.PositiveAnswers = append([]string(nil), t.PositiveAnswers...)
+	texts.NegativeAnswers = append([]string(nil), t.NegativeAnswers...)
+	texts.Weekdays = append([]string(nil), t.Weekdays...)
+	texts.Months = append([]string(nil), t.Months...)
+	texts.BroadcastStatuses = make(map[string]string, len(t.BroadcastStatuses))
+	for status, text := range t.BroadcastStatuses {
+		texts.BroadcastStatuses[status] = text
+	}
+	return &texts
+}
+
+// templateChecker - поле UserTexts типа Template или Plural
+type templateChecker interface {
+	check() error
+}
+
+// validate проверяет, что все тексты заполнены, а шаблоны используют только поля своих данных:
+// иначе ошибка всплыла бы только при отправке сообщения
+func (t *UserTexts) validate() error {
+	if len(t.Weekdays) != 7 {
+		return fmt.Errorf("weekdays: expected 7 names, got %d", len(t.Weekdays))
+	}
+	if len(t.Months) != 12 {
+		return fmt.Errorf("months: expected 12 names, got %d", len(t.Months))
+	}
+	if len(t.PositiveAnswers) == 0 || len(t.NegativeAnswers) == 0 {
+		return fmt.Errorf("positive_answers and negative_answers must not be empty")
+	}
+	value := reflect.ValueOf(t).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if checker, ok := value.Field(i).Interface().(templateChecker); ok {
+			if err := checker.check(); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		} else if field.Type.Kind() == reflect.String && value.Field(i).String() == "" {
+			return fmt.Errorf("%s: text is empty", key)
+		}
+	}
+	return nil
+}
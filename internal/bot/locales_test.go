@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogEmbedded(t *testing.T) {
+	catalog, err := LoadCatalog("", DefaultLanguage)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ru", "en"}, catalog.Languages())
+
+	require.Equal(t, "en", catalog.Texts("en-US").Language)
+	require.Equal(t, "January", catalog.Texts("en").Months[0])
+	require.Equal(t, "ru", catalog.Texts("de").Language)
+	require.Equal(t, "ru", catalog.Texts("").Language)
+	require.True(t, catalog.Has("EN"))
+	require.False(t, catalog.Has("de"))
+}
+
+func TestCatalogCount(t *testing.T) {
+	catalog, err := LoadCatalog("", DefaultLanguage)
+	require.NoError(t, err)
+
+	ru := catalog.Texts("ru")
+	for n, expected := range map[int]string{
+		1: "1 день", 2: "2 дня", 5: "5 дней", 11: "11 дней", 14: "14 дней", 21: "21 день", 22: "22 дня",
+	} {
+		require.Equal(t, expected, ru.Count(ru.Days, n))
+	}
+	en := catalog.Texts("en")
+	require.Equal(t, "1 day", en.Count(en.Days, 1))
+	require.Equal(t, "14 days", en.Count(en.Days, 14))
+}
+
+func TestCatalogOverride(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600))
+	}
+
+	write("ru.yaml", "cancel: Начнем сначала\n")
+	write("de.yaml", "language_name: Deutsch\n")
+	catalog, err := LoadCatalog(dir, DefaultLanguage)
+	require.NoError(t, err)
+	require.Equal(t, "Начнем сначала", catalog.Texts("ru").Cancel)
+	// Отсутствующие в файле тексты берутся из встроенного каталога
+	require.Equal(t, "Назад", catalog.Texts("ru").Back)
+	require.Equal(t, "Deutsch", catalog.Texts("de").LanguageName)
+	require.Equal(t, "Назад", catalog.Texts("de").Back)
+	// Переопределение на диске не меняет встроенный каталог
+	embedded, err := LoadCatalog("", DefaultLanguage)
+	require.NoError(t, err)
+	require.Equal(t, "Мы успешно вернулись в начало", embedded.Texts("ru").Cancel)
+
+	write("en.yaml", "change_name_succeed: Your name is {{.Name}}\n")
+	_, err = LoadCatalog(dir, DefaultLanguage)
+	require.ErrorContains(t, err, "change_name_succeed")
+	require.ErrorContains(t, err, "can't evaluate field Name")
+
+	write("en.yaml", "change_name_succeed: Your name is {{.LastName\n")
+	_, err = LoadCatalog(dir, DefaultLanguage)
+	require.ErrorContains(t, err, "en.yaml")
+
+	write("en.yaml", "days:\n  few: \"{{.Days}} days\"\n")
+	_, err = LoadCatalog(dir, DefaultLanguage)
+	require.ErrorContains(t, err, "days: few")
+
+	write("en.yaml", "days:\n  other: \"%d days\"\n")
+	_, err = LoadCatalog(dir, DefaultLanguage)
+	require.ErrorContains(t, err, "{{.N}}")
+
+	_, err = LoadCatalog("", "fr")
+	require.ErrorContains(t, err, `"fr"`)
+}
+
+func TestCatalogReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ru.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("branch_item: \"{{.Name}}\"\n"), 0o600))
+	catalog, err := LoadCatalog(dir, DefaultLanguage)
+	require.NoError(t, err)
+	changed, err := catalog.Changed()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	require.NoError(t, os.WriteFile(file, []byte("branch_item: \"{{.Name}}, {{.Address}}\"\n"), 0o600))
+	changed, err = catalog.Changed()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoError(t, catalog.Reload())
+	texts := catalog.Texts("ru")
+	require.Equal(t, "Олимп, Софрино", texts.BranchItem.Execute(BranchItemData{Name: "Олимп", Address: "Софрино"}))
+
+	// Ошибочный файл не заменяет уже загруженные тексты
+	require.NoError(t, os.WriteFile(file, []byte("branch_item: \"{{.City}}\"\n"), 0o600))
+	require.ErrorContains(t, catalog.Reload(), "branch_item")
+	changed, err = catalog.Changed()
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Equal(t, "{{.Name}}, {{.Address}}", catalog.Texts("ru").BranchItem.String())
+}
@@ -0,0 +1,25 @@
+package bot
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dentalbot_commands_total",
+		Help: "Messages handled by the router by command: text for plain messages, unknown for unknown commands",
+	}, []string{"tenant", "command"})
+	callbacksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dentalbot_callbacks_total",
+		Help: "Callback queries handled by the router by command",
+	}, []string{"tenant", "command"})
+	bookingsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dentalbot_bookings_total",
+		Help: "Records created, cancelled and rescheduled via the bot",
+	}, []string{"tenant", "action", "source"})
+	sendFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dentalbot_telegram_send_failures_total",
+		Help: "Failed Telegram requests by method: send, edit or edit_markup",
+	}, []string{"tenant", "method"})
+)
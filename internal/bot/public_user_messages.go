@@ -1,153 +1,195 @@
 package bot
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UserTexts - тексты бота на одном языке. Поля типа Template - шаблоны text/template
+// с именованными подстановками, например {{.Doctor}}
 type UserTexts struct {
-	PositiveAnswers []string
-	NegativeAnswers []string
-
-	Welcome                  string
-	ExistWelcome             string
-	Cancel                   string
-	Calendar                 string
-	PhoneNumberRequest       string
-	Back                     string
-	Wait                     string
-	ChooseDoctor             string
-	DontHasAppointments      string
-	ChooseAppointments       string
-	DontHasIntervals         string
-	ChooseInterval           string
-	Approve                  string
-	ApproveRegister          string
-	ApproveRegisterTimeLimit string
-	HasSameRecord            string
-	ContactsAddedSuccess     string
-	ChangeName               string
-
-	ChangeLastNameRequest  string
-	ChangeFirstNameRequest string
-	ChangeNameSucceed      string
-
-	RegisterIntervalError string
-	RegisterSuccess       string
-
-	HasNoRecords string
-	RecordList   string
-	RecordItem   string
-
-	DeleteRecords              string
-	DeleteRecordItem           string
-	ApproveDeleteRecord        string
-	HasNoDeleteRecord          string
-	CancelDeleteRecord         string
-	UnknownApproveDeleteRecord string
-	SuccessDeleteRecord        string
-
-	InternalError string
+	// Language - код языка каталога, заполняется при загрузке
+	Language     string `yaml:"-"`
+	LanguageName string `yaml:"language_name"`
+
+	PositiveAnswers []string `yaml:"positive_answers"`
+	NegativeAnswers []string `yaml:"negative_answers"`
+	// Weekdays начинаются с понедельника, Months - с января
+	Weekdays []string `yaml:"weekdays"`
+	Months   []string `yaml:"months"`
+	Days     Plural   `yaml:"days"`
+
+	Welcome                  string                        `yaml:"welcome"`
+	ExistWelcome             string                        `yaml:"exist_welcome"`
+	Cancel                   string                        `yaml:"cancel"`
+	CalendarHeader           Template[CalendarData]        `yaml:"calendar_header"`
+	PhoneNumberRequest       string                        `yaml:"phone_number_request"`
+	Back                     string                        `yaml:"back"`
+	Wait                     string                        `yaml:"wait"`
+	ChooseBranch             string                        `yaml:"choose_branch"`
+	BranchItem               Template[BranchItemData]      `yaml:"branch_item"`
+	ChooseDoctor             string                        `yaml:"choose_doctor"`
+	DontHasAppointments      Template[DoctorData]          `yaml:"dont_has_appointments"`
+	ChooseAppointments       string                        `yaml:"choose_appointments"`
+	DontHasIntervals         Template[IntervalsData]       `yaml:"dont_has_intervals"`
+	ChooseInterval           Template[IntervalsData]       `yaml:"choose_interval"`
+	Approve                  string                        `yaml:"approve"`
+	ApproveRegister          Template[ApproveRegisterData] `yaml:"approve_register"`
+	ApproveRegisterTimeLimit string                        `yaml:"approve_register_time_limit"`
+	HasSameRecord            string                        `yaml:"has_same_record"`
+	ContactsAddedSuccess     string                        `yaml:"contacts_added_success"`
+	ChangeName               string                        `yaml:"change_name"`
+	CancelButton             string                        `yaml:"cancel_button"`
+	SendPhoneButton          string                        `yaml:"send_phone_button"`
+	SendPhoneRequest         string                        `yaml:"send_phone_request"`
+	AppointmentItem          Template[AppointmentData]     `yaml:"appointment_item"`
+
+	LanguageChoose  string `yaml:"language_choose"`
+	LanguageChanged string `yaml:"language_changed"`
+
+	ChangeLastNameRequest  string             `yaml:"change_last_name_request"`
+	ChangeFirstNameRequest string             `yaml:"change_first_name_request"`
+	ChangeNameSucceed      Template[NameData] `yaml:"change_name_succeed"`
+
+	RegisterIntervalError string                      `yaml:"register_interval_error"`
+	RegisterSuccess       Template[RecordCreatedData] `yaml:"register_success"`
+
+	HasNoRecords string                   `yaml:"has_no_records"`
+	RecordList   string                   `yaml:"record_list"`
+	RecordItem   Template[RecordItemData] `yaml:"record_item"`
+
+	DeleteRecords              string                     `yaml:"delete_records"`
+	DeleteRecordItem           Template[RecordButtonData] `yaml:"delete_record_item"`
+	ApproveDeleteRecord        Template[RecordData]       `yaml:"approve_delete_record"`
+	HasNoDeleteRecord          string                     `yaml:"has_no_delete_record"`
+	CancelDeleteRecord         Template[RecordData]       `yaml:"cancel_delete_record"`
+	UnknownApproveDeleteRecord string                     `yaml:"unknown_approve_delete_record"`
+	SuccessDeleteRecord        Template[RecordData]       `yaml:"success_delete_record"`
+
+	MoveRecords             string                          `yaml:"move_records"`
+	MoveRecordItem          Template[RecordButtonData]      `yaml:"move_record_item"`
+	HasNoMoveRecord         string                          `yaml:"has_no_move_record"`
+	MoveRecordNoAppointment Template[VisitData]             `yaml:"move_record_no_appointment"`
+	ApproveMoveRecord       Template[ApproveMoveRecordData] `yaml:"approve_move_record"`
+	MoveRecordSuccess       Template[RecordCreatedData]     `yaml:"move_record_success"`
+	MoveRecordError         string                          `yaml:"move_record_error"`
+
+	Reminder          Template[VisitData]  `yaml:"reminder"`
+	ReminderConfirm   string               `yaml:"reminder_confirm"`
+	ReminderCancel    string               `yaml:"reminder_cancel"`
+	ReminderMove      string               `yaml:"reminder_move"`
+	ReminderConfirmed Template[RecordData] `yaml:"reminder_confirmed"`
+
+	WaitlistDayButton          string                 `yaml:"waitlist_day_button"`
+	WaitlistWeeksButton        Template[DaysData]     `yaml:"waitlist_weeks_button"`
+	WaitlistDoctorButton       string                 `yaml:"waitlist_doctor_button"`
+	WaitlistJoined             Template[WaitlistData] `yaml:"waitlist_joined"`
+	WaitlistDoctorJoined       Template[WaitlistData] `yaml:"waitlist_doctor_joined"`
+	WaitlistCancelButton       string                 `yaml:"waitlist_cancel_button"`
+	WaitlistCancelled          string                 `yaml:"waitlist_cancelled"`
+	WaitlistNotFound           string                 `yaml:"waitlist_not_found"`
+	WaitlistSlotFound          Template[VisitData]    `yaml:"waitlist_slot_found"`
+	WaitlistSlotButton         Template[SlotData]     `yaml:"waitlist_slot_button"`
+	WaitlistAppointmentsFound  Template[DoctorData]   `yaml:"waitlist_appointments_found"`
+	WaitlistAppointmentsButton string                 `yaml:"waitlist_appointments_button"`
+
+	AdminStats             Template[AdminStatsData]     `yaml:"admin_stats"`
+	AdminToday             string                       `yaml:"admin_today"`
+	AdminTodayItem         Template[AdminTodayItemData] `yaml:"admin_today_item"`
+	AdminTodayEmpty        string                       `yaml:"admin_today_empty"`
+	AdminFindPatientUsage  string                       `yaml:"admin_find_patient_usage"`
+	AdminPatient           Template[AdminPatientData]   `yaml:"admin_patient"`
+	AdminPatientNotFound   Template[PhoneData]          `yaml:"admin_patient_not_found"`
+	AdminRefreshed         string                       `yaml:"admin_refreshed"`
+	AdminBroadcastUsage    string                       `yaml:"admin_broadcast_usage"`
+	AdminBroadcastStarted  Template[BroadcastData]      `yaml:"admin_broadcast_started"`
+	AdminBroadcastFinished Template[BroadcastData]      `yaml:"admin_broadcast_finished"`
+
+	AdminBroadcastPaused          Template[BroadcastData] `yaml:"admin_broadcast_paused"`
+	AdminBroadcastResumed         Template[BroadcastData] `yaml:"admin_broadcast_resumed"`
+	AdminBroadcastStatus          Template[BroadcastData] `yaml:"admin_broadcast_status"`
+	AdminBroadcastNotFound        string                  `yaml:"admin_broadcast_not_found"`
+	AdminBroadcastAlreadyFinished Template[BroadcastData] `yaml:"admin_broadcast_already_finished"`
+	BroadcastStatuses             map[string]string       `yaml:"broadcast_statuses"`
+
+	InternalError        string `yaml:"internal_error"`
+	CRMNotFoundError     string `yaml:"crm_not_found_error"`
+	CRMValidationError   string `yaml:"crm_validation_error"`
+	CRMRateLimitedError  string `yaml:"crm_rate_limited_error"`
+	CRMUnauthorizedError string `yaml:"crm_unauthorized_error"`
+	CRMUpstreamError     string `yaml:"crm_upstream_error"`
 }
 
+// NewUserTexts возвращает встроенные тексты на языке по умолчанию
 func NewUserTexts() *UserTexts {
-	return &UserTexts{
-		PositiveAnswers: []string{
-			"✅ Подтвердить",
-			"Подтвердить",
-			"Да",
-			"Yes",
-			"Y",
-			"Ok",
-			"Ок",
-		},
-
-		NegativeAnswers: []string{
-			"Отменить",
-			"Нет",
-			"Не",
-			"No",
-			"N",
-		},
-
-		Welcome: `Привет! 👋 Добро пожаловать в нашу стоматологическую клинику в "Олимп" Софрино 🦷✨ 
-
-Вот что я могу для вас сделать:
-- 🗓️ /record — Запись на приём к стоматологу
-- 🗑️ /delete_record — Удалить запись на приём
-- 📋 /myrecords — Получить информацию о предстоящих визитах
-- ✏️ /change_name — Изменить имя в системе
-- ❌ /cancel — Отменить последнее действие и вернуться к началу
-
-Для записи на приём просто отправьте команду /record или выберите нужный пункт в меню.`,
-
-		ExistWelcome: `Если вы уже наш пациент, пожалуйста, напишите своё ФИО или номер телефона, указанный при первом визите к нам ☎️.
-
-Чтобы изменить имя, воспользуйтесь командой /change_name`,
-
-		Cancel: "Мы успешно вернулись в начало",
-
-		Calendar: "Выберите нужный день",
-
-		PhoneNumberRequest: "Пожалуйста, укажите ваш номер телефона 📱. Он понадобится для подтверждения вашей регистрации и редактирования записи.\n\n" +
-			"Нажмите кнопку <b>📞 Отправить номер телефона</b>",
-
-		Back: "Назад",
-
-		ChooseDoctor: "Пожалуйста, выберите врача для записи. Вы можете выбрать из доступных специалистов ниже 👇",
-
-		DontHasAppointments: "К сожалению, у врача %s пока нет доступных приемов 😔.",
-
-		ChooseAppointments: "Пожалуйста, выберите желаемый прием 🌟.",
-
-		DontHasIntervals: "День %s\nВрач %s\n%s\n\nК сожалению, у врача %s пока нет свободных интервалов в этот день. 😔🗓️",
-
-		ChooseInterval: "День %s\nВрач %s\n%s\n\nПожалуйста, выберите свободное время. 🕒✨",
-
-		Approve: "✅ Подтвердить",
-
-		ApproveRegisterTimeLimit: "⚠️ Упс! Вы не можете записаться на уже прошедшую дату и время",
-
-		ApproveRegister: "Стоматологическая клиника \"Олимп\" в Софрино\n\n" +
-			"📅 Дата и время: <b><i>%s</i></b>\n👨‍⚕️ Врач: <b><i>%s</i></b>" +
-			"\n🦷 На прием: <b><i>%s (%d мин)</i></b>\n\nВы будете записаны как: <b><i>%s %s</i></b>" +
-			"\n\nПожалуйста, подтвердите, что все верно.",
-
-		HasSameRecord: "К сожалению, вы не можете записаться к этому врачу, так как уже состоите в списке записавшихся 🩺❗ к нему",
-
-		ContactsAddedSuccess: "📞 Ваш номер телефона успешно добавлен!\nВы можете продолжить регистрацию.",
-
-		ChangeName: "Изменить имя",
-
-		ChangeFirstNameRequest: "🗝 Пожалуйста, укажите ваше имя.",
-
-		ChangeLastNameRequest: "🗝 Пожалуйста, теперь укажите фамилию.",
-
-		ChangeNameSucceed: "🎉 Ваше имя успешно изменено на <b><i>%s %s</i></b>!",
-
-		RegisterIntervalError: "К сожалению, выбранный интервал недоступен для записи 😔. Пожалуйста, выберите другой 🗓️.",
-
-		RegisterSuccess: "Вы успешно записались на прием! 🎉\n\n" +
-			"Стоматологическая клиника \"Олимп\" в Софрино\n\n" +
-			"📅 Дата и время: <b><i>%s %s</i></b>\n👨‍⚕️ Врач: <b><i>%s</i></b>" +
-			"\n🦷 На прием: <b><i>%s (%d мин)</i></b>\n\nВы записаны как: <b><i>%s %s</i></b>\n\n" +
-			"Воспользуйтесь командой:\n" +
-			"\t/delete_record ❌ — если хотите удалить запись\n\n" +
-			"Ждем вас! 😊",
-
-		Wait: "Секунду...",
+	catalog, err := LoadCatalog("", DefaultLanguage)
+	if err != nil {
+		panic(err)
+	}
+	return catalog.Texts(DefaultLanguage)
+}
 
-		InternalError: "😔 Внутренняя ошибка сервера. Пожалуйста, попробуйте позже. " +
-			"Спасибо за понимание! 🙏",
+// Count подставляет число в форму слова по правилам языка текстов
+func (t *UserTexts) Count(forms Plural, n int) string {
+	form, ok := pluralForm(t.Language, forms, n)
+	if !ok {
+		return strconv.Itoa(n)
+	}
+	return form.Execute(CountData{N: n})
+}
 
-		HasNoRecords: "Похоже, у вас нет записей 📅",
-		RecordList:   "Список ваших записей в стоматологическую клинику \"Олимп\" в Софрино\n\n",
-		RecordItem: "Запись №%d\n📅 Дата и время: <b><i>%s</i></b>\n👨‍⚕️ Врач: <b><i>%s - %s</i></b>" +
-			"\n🦷 На прием: <b><i>%s (%d мин)</i></b>",
+func pluralForm(language string, forms Plural, n int) (Template[CountData], bool) {
+	var candidates []Template[CountData]
+	switch language {
+	case "ru", "uk", "be":
+		mod10, mod100 := n%10, n%100
+		switch {
+		case mod10 == 1 && mod100 != 11:
+			candidates = []Template[CountData]{forms.One}
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			candidates = []Template[CountData]{forms.Few, forms.Other}
+		default:
+			candidates = []Template[CountData]{forms.Many, forms.Other}
+		}
+	default:
+		if n == 1 {
+			candidates = []Template[CountData]{forms.One}
+		} else {
+			candidates = []Template[CountData]{forms.Other, forms.Many}
+		}
+	}
+	for _, form := range append(candidates, forms.Other, forms.Many, forms.One) {
+		if form.String() != "" {
+			return form, true
+		}
+	}
+	return Template[CountData]{}, false
+}
 
-		DeleteRecords:       "Выберите запись, которую хотите удалить ❌",
-		DeleteRecordItem:    "Запись №%d: %s %s",
-		ApproveDeleteRecord: "Вы хотите удалить запись — %s, %s 🗓️.\n\nПодтвердить удаление? ✅",
-		HasNoDeleteRecord:   "К сожалению, такой записи не найдено 😕",
-		CancelDeleteRecord:  "Удаление записи — %s, %s, отменено ❌",
-		UnknownApproveDeleteRecord: `Пожалуйста, напишите 'Да' или 'Нет', либо соответсвующую нажмите на кнопку 😊
+// Plural - формы слова для числа, например {{.N}} дней.
+// One, Few и Many используются в русском, One и Other - в английском
+type Plural struct {
+	One   Template[CountData] `yaml:"one"`
+	Few   Template[CountData] `yaml:"few,omitempty"`
+	Many  Template[CountData] `yaml:"many,omitempty"`
+	Other Template[CountData] `yaml:"other,omitempty"`
+}
 
-Если хотите вернуться и отменить действие, напишите /cancel`,
-		SuccessDeleteRecord: "Запись — %s, %s, успешно удалена ✅",
+// check проверяет заполненные формы. Обязательна только One
+func (p Plural) check() error {
+	forms := map[string]Template[CountData]{"one": p.One, "few": p.Few, "many": p.Many, "other": p.Other}
+	for _, name := range []string{"one", "few", "many", "other"} {
+		form := forms[name]
+		if form.String() == "" && name != "one" {
+			continue
+		}
+		if err := form.check(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if strings.Contains(form.String(), "%d") {
+			return fmt.Errorf("%s: use {{.N}} instead of %%d", name)
+		}
 	}
+	return nil
 }
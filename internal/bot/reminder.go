@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/internal/crm"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReminderScheduler периодически проходит по пользователям с dental_pro_id
+// и напоминает им о предстоящих визитах
+type ReminderScheduler struct {
+	tgBotHandler *TelegramBotHandler
+	offsets      []time.Duration
+	interval     time.Duration
+	stopChan     chan struct{}
+	wg           *sync.WaitGroup
+}
+
+func NewReminderScheduler(
+	tgBotHandler *TelegramBotHandler, offsets []time.Duration, interval time.Duration,
+) *ReminderScheduler {
+	sortedOffsets := make([]time.Duration, len(offsets))
+	copy(sortedOffsets, offsets)
+	sort.Slice(sortedOffsets, func(i, j int) bool {
+		return sortedOffsets[i] < sortedOffsets[j]
+	})
+	return &ReminderScheduler{
+		tgBotHandler: tgBotHandler,
+		offsets:      sortedOffsets,
+		interval:     interval,
+		stopChan:     make(chan struct{}),
+		wg:           new(sync.WaitGroup),
+	}
+}
+
+// Start запускает рассылку напоминаний в отдельной горутине. Stop дожидается ее завершения
+func (s *ReminderScheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *ReminderScheduler) run() {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stopChan
+		cancel()
+	}()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.SendReminders(ctx)
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			logrus.Println("Stop reminders")
+			return
+		}
+	}
+}
+
+func (s *ReminderScheduler) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+}
+
+func (s *ReminderScheduler) SendReminders(ctx context.Context) {
+	log := logrus.WithFields(logrus.Fields{
+		"module": "bot.reminder",
+		"func":   "SendReminders",
+	})
+
+	userRepo := database.UserRepository{DB: s.tgBotHandler.db}
+	users, err := userRepo.ListWithDentalProID(ctx)
+	if err != nil {
+		log.WithError(err).Error("ListWithDentalProID")
+		return
+	}
+
+	now := s.tgBotHandler.nowTime.Now()
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		records, err := s.tgBotHandler.dentalProClient.PatientRecords(ctx, *user.DentalProID)
+		if err != nil {
+			log.WithError(err).Errorf("PatientRecords %d", *user.DentalProID)
+			continue
+		}
+		for _, record := range records {
+			start := s.tgBotHandler.recordStart(record)
+			offset, ok := dueReminderOffset(now, start, s.offsets)
+			if !ok {
+				continue
+			}
+			s.sendReminder(ctx, user, record, start, offset, log)
+		}
+	}
+}
+
+func (s *ReminderScheduler) sendReminder(
+	ctx context.Context,
+	user database.User, record crm.ShortRecord, start time.Time, offset time.Duration, log *logrus.Entry,
+) {
+	reminderRepo := database.ReminderRepository{DB: s.tgBotHandler.db}
+	reminder := &database.Reminder{
+		UserID:        user.ID,
+		RecordID:      record.ID,
+		OffsetMinutes: int(offset / time.Minute),
+	}
+	created, err := reminderRepo.Create(ctx, reminder)
+	if err != nil {
+		log.WithError(err).Errorf("Create reminder for record %d", record.ID)
+		return
+	}
+	if !created {
+		return
+	}
+
+	h := s.tgBotHandler.ForUser(ctx, &tgbotapi.User{ID: user.TgUserID})
+	text := h.userTexts.Reminder.Execute(VisitData{
+		Datetime:    start.Format("2006-01-02 15:04"),
+		Doctor:      record.DoctorName,
+		Appointment: record.Name,
+	})
+	msg := tgbotapi.NewMessage(user.TgUserID, text)
+	msg.ParseMode = HTML
+	msg.ReplyMarkup = h.createReminderKeyboard(record.ID)
+	if _, err := h.Send(msg, false); err != nil {
+		// Даем шанс отправить напоминание на следующем проходе
+		if err := reminderRepo.Delete(ctx, reminder.ID); err != nil {
+			log.WithError(err).Errorf("Delete reminder %d", reminder.ID)
+		}
+	}
+}
+
+func (h *TelegramBotHandler) createReminderKeyboard(recordID int64) tgbotapi.InlineKeyboardMarkup {
+	button := func(text, command string) tgbotapi.InlineKeyboardButton {
+		data, _ := json.Marshal(TelegramRecordChangeCallback{CallbackData{command}, recordID})
+		return tgbotapi.NewInlineKeyboardButtonData(text, string(data))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button(h.userTexts.ReminderConfirm, "rem_ok")),
+		tgbotapi.NewInlineKeyboardRow(
+			button(h.userTexts.ReminderMove, "rem_mv"),
+			button(h.userTexts.ReminderCancel, "rem_del"),
+		),
+	)
+}
+
+// recordStart переводит время записи CRM во время клиники
+func (h *TelegramBotHandler) recordStart(record crm.ShortRecord) time.Time {
+	start := time.Time(record.DateStart)
+	return time.Date(
+		start.Year(), start.Month(), start.Day(),
+		start.Hour(), start.Minute(), start.Second(), 0, h.location,
+	)
+}
+
+// dueReminderOffset возвращает наименьший из отступов, время которого уже наступило.
+// Отступы должны быть отсортированы по возрастанию
+func dueReminderOffset(now, start time.Time, offsets []time.Duration) (time.Duration, bool) {
+	if !now.Before(start) {
+		return 0, false
+	}
+	for _, offset := range offsets {
+		if !now.Before(start.Add(-offset)) {
+			return offset, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDueReminderOffset(t *testing.T) {
+	start := time.Date(2024, 11, 10, 12, 0, 0, 0, LOCATION)
+	offsets := []time.Duration{2 * time.Hour, 24 * time.Hour}
+
+	cases := []struct {
+		now      time.Time
+		expected time.Duration
+		ok       bool
+	}{
+		{start.Add(-48 * time.Hour), 0, false},
+		{start.Add(-24 * time.Hour), 24 * time.Hour, true},
+		{start.Add(-3 * time.Hour), 24 * time.Hour, true},
+		{start.Add(-2 * time.Hour), 2 * time.Hour, true},
+		{start.Add(-time.Minute), 2 * time.Hour, true},
+		{start, 0, false},
+		{start.Add(time.Hour), 0, false},
+	}
+	for _, c := range cases {
+		offset, ok := dueReminderOffset(c.now, start, offsets)
+		require.Equal(t, c.ok, ok, c.now)
+		require.Equal(t, c.expected, offset, c.now)
+	}
+}
@@ -3,75 +3,124 @@ package bot
 import (
 	"context"
 	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Router struct {
 	bot          TelegramBotAPIWrapper
 	tgBotHandler *TelegramBotHandler
-	TgChatStates *map[int64]*TelegramChatState
-	ChatStatesMu *sync.Mutex
+	chatStates   ChatStateStore
+	dispatcher   *chatDispatcher
 	TestWG       *sync.WaitGroup
 	updateWG     *sync.WaitGroup
 	stopChan     chan struct{}
+	expireStop   chan struct{}
+	webhookMu    sync.Mutex
+	webhook      *http.Server
+	// listening - webhook сервер принимает соединения или long polling получил первый ответ, см. Listening
+	listening atomic.Bool
+	// lastPoll - время последнего успешного getUpdates в Unix наносекундах. В режиме webhook 0
+	lastPoll atomic.Int64
+	now      func() time.Time
+	// ctx отменяется, если обновления не успели обработаться до конца Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
+const (
+	// updateTimeout ограничивает время обработки одного обновления
+	updateTimeout = 30 * time.Second
+	// pollTimeout - сколько секунд Telegram держит запрос getUpdates, если обновлений нет
+	pollTimeout = 60
+	// pollRetryDelay - пауза после неудачного getUpdates
+	pollRetryDelay = 3 * time.Second
+	// pollStaleAfter - через сколько после последнего успешного getUpdates router считается неготовым
+	pollStaleAfter = 3 * time.Minute
+)
+
 type CallbackData struct {
 	Command string `json:"command"`
 }
 
-func NewRouter(bot TelegramBotAPIWrapper, tgBotHandler *TelegramBotHandler, test bool) *Router {
+func NewRouter(
+	bot TelegramBotAPIWrapper, tgBotHandler *TelegramBotHandler, chatStates ChatStateStore, workers int, test bool,
+) *Router {
 	router := &Router{
 		bot:          bot,
 		tgBotHandler: tgBotHandler,
-		TgChatStates: &map[int64]*TelegramChatState{},
-		ChatStatesMu: &sync.Mutex{},
+		chatStates:   chatStates,
 		updateWG:     new(sync.WaitGroup),
 		stopChan:     make(chan struct{}, 1),
+		expireStop:   make(chan struct{}),
+		now:          time.Now,
 	}
+	router.ctx, router.cancel = context.WithCancel(context.Background())
+	router.dispatcher = newChatDispatcher(workers, router.processUpdate)
 	if test {
 		router.TestWG = new(sync.WaitGroup)
 	}
 	return router
 }
 
-func (r *Router) GetOrCreateChatState(chatID int64) *TelegramChatState {
-	r.ChatStatesMu.Lock()
-	defer r.ChatStatesMu.Unlock()
-	chatState := (*r.TgChatStates)[chatID]
+func (r *Router) GetOrCreateChatState(ctx context.Context, chatID int64) *TelegramChatState {
+	chatState, err := r.chatStates.Get(ctx, chatID)
+	if err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Errorf("load chat state %d", chatID)
+	}
 	if chatState == nil {
 		chatState = &TelegramChatState{Timestamp: time.Now()}
-		(*r.TgChatStates)[chatID] = chatState
 	}
 	return chatState
 }
 
+func (r *Router) saveChatState(ctx context.Context, chatID int64, chatState *TelegramChatState) {
+	var err error
+	if chatState.Name == "" {
+		err = r.chatStates.Delete(ctx, chatID)
+	} else {
+		err = r.chatStates.Put(ctx, chatID, chatState)
+	}
+	if err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Errorf("save chat state %d", chatID)
+	}
+}
+
+// StartChatStateExpiry периодически удаляет состояния чатов с истекшим TTL
+func (r *Router) StartChatStateExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := r.chatStates.Expire(r.ctx, now); err != nil {
+				logrus.WithError(err).Error("expire chat states")
+			}
+		case <-r.expireStop:
+			return
+		}
+	}
+}
+
 func (r *Router) StartListening() {
 	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	u.Timeout = pollTimeout
 
-	updates := r.bot.GetUpdatesChan(u)
+	updates := make(chan tgbotapi.Update)
+	done := make(chan struct{})
+	defer close(done)
+	go r.pollUpdates(u, updates, done)
+	defer r.listening.Store(false)
 
 	for {
 		select {
 		case update := <-updates:
-			r.updateWG.Add(1)
-			go func(update tgbotapi.Update) {
-				if r.TestWG != nil {
-					defer r.TestWG.Done()
-				}
-
-				if update.Message != nil {
-					r.handleMessage(update.Message)
-				}
-				if update.CallbackQuery != nil {
-					r.callbackMessage(update.CallbackQuery)
-				}
-				r.updateWG.Done()
-			}(update)
+			r.HandleUpdate(update)
 
 		case <-r.stopChan:
 			logrus.Println("Stop Listening")
@@ -80,8 +129,105 @@ func (r *Router) StartListening() {
 	}
 }
 
+// pollUpdates получает обновления через getUpdates, как tgbotapi.BotAPI.GetUpdatesChan,
+// и запоминает время каждого успешного ответа для Listening
+func (r *Router) pollUpdates(config tgbotapi.UpdateConfig, updates chan<- tgbotapi.Update, done <-chan struct{}) {
+	log := logrus.WithFields(logrus.Fields{
+		"module": "bot.router",
+		"func":   "pollUpdates",
+	})
+	for {
+		received, err := r.bot.GetUpdates(config)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err != nil {
+			log.WithError(err).Errorf("get updates, retrying in %s", pollRetryDelay)
+			if !sleepOrStop(pollRetryDelay, done) {
+				return
+			}
+			continue
+		}
+		r.lastPoll.Store(r.now().UnixNano())
+		r.listening.Store(true)
+
+		for _, update := range received {
+			if update.UpdateID < config.Offset {
+				continue
+			}
+			config.Offset = update.UpdateID + 1
+			select {
+			case updates <- update:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// Listening сообщает, получает ли router обновления от Telegram. Используется проверкой готовности.
+// При long polling последний успешный getUpdates должен быть не раньше pollStaleAfter
+func (r *Router) Listening() bool {
+	if !r.listening.Load() {
+		return false
+	}
+	lastPoll := r.lastPoll.Load()
+	return lastPoll == 0 || r.now().Sub(time.Unix(0, lastPoll)) < pollStaleAfter
+}
+
+// HandleUpdate ставит обновление в очередь чата. Используется и long polling, и webhook
+func (r *Router) HandleUpdate(update tgbotapi.Update) {
+	r.updateWG.Add(1)
+	r.dispatcher.Submit(update)
+}
+
+func (r *Router) processUpdate(update tgbotapi.Update) {
+	if r.TestWG != nil {
+		defer r.TestWG.Done()
+	}
+	defer r.updateWG.Done()
+
+	ctx, cancel := context.WithTimeout(r.ctx, updateTimeout)
+	defer cancel()
+	ctx = pkg.ContextWithLogger(ctx, updateLogger(update))
+	if update.Message != nil {
+		r.handleMessage(ctx, update.Message)
+	}
+	if update.CallbackQuery != nil {
+		r.callbackMessage(ctx, update.CallbackQuery)
+	}
+}
+
+// updateLogger возвращает логгер с идентификаторами обновления, чата и пользователя,
+// чтобы по логам можно было собрать всю обработку одного обновления
+func updateLogger(update tgbotapi.Update) *logrus.Entry {
+	fields := logrus.Fields{"update_id": update.UpdateID}
+	if chat := update.FromChat(); chat != nil {
+		fields["chat_id"] = chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		fields["user_id"] = user.ID
+	}
+	return logrus.WithFields(fields)
+}
+
+// ChatStateCount возвращает число сохраненных состояний чатов
+func (r *Router) ChatStateCount(ctx context.Context) (int, error) {
+	return r.chatStates.Count(ctx)
+}
+
+func (r *Router) Stats() DispatcherStats {
+	return r.dispatcher.Stats()
+}
+
 func (r *Router) Shutdown(ctx context.Context) error {
 	r.stopChan <- struct{}{}
+	close(r.expireStop)
+	if err := r.shutdownWebhook(ctx); err != nil {
+		return err
+	}
 	done := make(chan struct{})
 	go func() {
 		r.updateWG.Wait()
@@ -90,69 +236,135 @@ func (r *Router) Shutdown(ctx context.Context) error {
 
 	select {
 	case <-ctx.Done():
+		r.cancel()
 		return ctx.Err()
 	case <-done:
 		return nil
 	}
 }
 
-func (r *Router) callbackMessage(callbackQuery *tgbotapi.CallbackQuery) {
+func (r *Router) callbackMessage(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery) {
 	var data CallbackData
-	chatState := r.GetOrCreateChatState(callbackQuery.Message.Chat.ID)
+	h := r.tgBotHandler.ForUser(ctx, callbackQuery.From)
+	chatState := r.GetOrCreateChatState(ctx, callbackQuery.Message.Chat.ID)
+	defer r.saveChatState(ctx, callbackQuery.Message.Chat.ID, chatState)
 	callbackData := []byte(callbackQuery.Data)
 	err := json.Unmarshal(callbackData, &data)
 	if err != nil {
-		logrus.Error(err)
+		pkg.LoggerFromContext(ctx).Error(err)
 	}
+	label := data.Command
 	switch data.Command {
 	case "switch_timesheet_month":
-		r.tgBotHandler.SwitchTimesheetMonthCallback(callbackQuery)
+		h.SwitchTimesheetMonthCallback(ctx, callbackQuery)
+	case "branch":
+		h.SelectBranchCallback(ctx, callbackQuery)
 	case "select_doctor":
-		r.tgBotHandler.ShowAppointments(callbackQuery)
+		h.ShowAppointments(ctx, callbackQuery)
 	case "day":
-		r.tgBotHandler.ChoiceDayCallback(callbackQuery)
+		h.ChoiceDayCallback(ctx, callbackQuery)
 	case "appointment":
-		r.tgBotHandler.ShowCalendarCallback(callbackQuery)
+		h.ShowCalendarCallback(ctx, callbackQuery)
 	case "interval":
-		r.tgBotHandler.RegisterApproveCallback(callbackQuery, chatState)
+		h.RegisterApproveCallback(ctx, callbackQuery, chatState)
 	case "change_name":
-		r.tgBotHandler.ChangeNameCallback(callbackQuery, chatState)
+		h.ChangeNameCallback(callbackQuery, chatState)
 	case "approve":
-		r.tgBotHandler.RegisterCallback(callbackQuery)
+		h.RegisterCallback(ctx, callbackQuery)
 	case "del_r":
-		r.tgBotHandler.ApproveDeleteRecord(callbackQuery, chatState)
+		h.ApproveDeleteRecord(ctx, callbackQuery, chatState)
+	case "move_r":
+		h.MoveRecordCallback(ctx, callbackQuery, chatState)
+	case "rem_ok":
+		h.ReminderConfirmCallback(ctx, callbackQuery)
+	case "rem_mv":
+		h.ReminderMoveCallback(ctx, callbackQuery, chatState)
+	case "rem_del":
+		h.ApproveDeleteRecord(ctx, callbackQuery, chatState)
+	case "wait", "wait_doc":
+		h.JoinWaitlistCallback(ctx, callbackQuery)
+	case "wait_x":
+		h.CancelWaitlistCallback(ctx, callbackQuery)
+	case "lang":
+		h.LanguageCallback(ctx, callbackQuery)
 	case "back":
-		r.tgBotHandler.BackCallback(callbackQuery)
+		h.BackCallback(ctx, callbackQuery)
 	default:
-		logrus.Errorf("unknown command \"%s\"", data.Command)
+		label = "unknown"
+		pkg.LoggerFromContext(ctx).Errorf("unknown command \"%s\"", data.Command)
 	}
+	callbacksTotal.WithLabelValues(r.tgBotHandler.tenant, label).Inc()
 }
 
-func (r *Router) handleMessage(msg *tgbotapi.Message) {
-	chatState := r.GetOrCreateChatState(msg.Chat.ID)
-	currentNextFunc := chatState.NextFunc
+func (r *Router) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
+	h := r.tgBotHandler.ForUser(ctx, msg.From)
+	chatState := r.GetOrCreateChatState(ctx, msg.Chat.ID)
+	revision := chatState.revision
 
+	label := msg.Command()
 	switch msg.Command() {
 	case "start":
-		r.tgBotHandler.StartCommandHandler(msg, chatState)
+		h.StartCommandHandler(ctx, msg, chatState)
 	case "record":
-		r.tgBotHandler.RegisterCommandHandler(msg, chatState)
+		h.RegisterCommandHandler(ctx, msg, chatState)
 	case "change_name":
-		r.tgBotHandler.ChangeNameHandler(msg, chatState, nil)
+		h.ChangeNameHandler(ctx, msg, chatState, nil)
 	case "myrecords":
-		r.tgBotHandler.ShowRecordsListHandler(msg, chatState)
+		h.ShowRecordsListHandler(ctx, msg, chatState)
 	case "delete_record":
-		r.tgBotHandler.DeleteRecordHandler(msg, chatState)
+		h.DeleteRecordHandler(ctx, msg, chatState)
+	case "move_record":
+		h.MoveRecordHandler(ctx, msg, chatState)
 	case "cancel":
-		r.tgBotHandler.CancelCommandHandler(msg, chatState)
+		h.CancelCommandHandler(ctx, msg, chatState)
+	case "language":
+		h.LanguageCommandHandler(ctx, msg, chatState)
+	case "stats", "today", "find_patient", "refresh",
+		"broadcast", "broadcast_pause", "broadcast_resume", "broadcast_status":
+		if !h.IsAdmin(msg.From.ID) {
+			h.UnknownCommandHandler(ctx, msg, chatState)
+			break
+		}
+		r.handleAdminCommand(ctx, h, msg, chatState)
 	default:
-		if chatState.NextFunc != nil {
-			(*chatState.NextFunc)(msg, chatState)
-		} else {
-			r.tgBotHandler.UnknownCommandHandler(msg, chatState)
+		label = "unknown"
+		if msg.Command() == "" {
+			label = "text"
 		}
+		if chatState.Name == "" || !h.RunStep(ctx, chatState.ChatStep, msg, chatState) {
+			h.UnknownCommandHandler(ctx, msg, chatState)
+		}
+	}
+	commandsTotal.WithLabelValues(r.tgBotHandler.tenant, label).Inc()
+	if revision == chatState.revision {
+		chatState.Clear()
 	}
-	if currentNextFunc == chatState.NextFunc {
-		chatState.UpdateChatState(nil)
+	r.saveChatState(ctx, msg.Chat.ID, chatState)
+}
+
+func (r *Router) handleAdminCommand(
+	ctx context.Context, h *TelegramBotHandler, msg *tgbotapi.Message, chatState *TelegramChatState) {
+	pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.router",
+		"func":   "handleAdminCommand",
+	}).Infof("/%s admin command", msg.Command())
+
+	switch msg.Command() {
+	case "stats":
+		h.StatsHandler(ctx, msg, chatState)
+	case "today":
+		h.TodayHandler(ctx, msg, chatState)
+	case "find_patient":
+		h.FindPatientHandler(ctx, msg, chatState)
+	case "refresh":
+		h.RefreshHandler(ctx, msg, chatState)
+	case "broadcast":
+		h.BroadcastHandler(ctx, msg, chatState)
+	case "broadcast_pause":
+		h.BroadcastPauseHandler(ctx, msg, chatState)
+	case "broadcast_resume":
+		h.BroadcastResumeHandler(ctx, msg, chatState)
+	case "broadcast_status":
+		h.BroadcastStatusHandler(ctx, msg, chatState)
 	}
 }
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pollingBot отвечает на getUpdates ответами из responses
+type pollingBot struct {
+	TelegramBotAPIWrapper
+	responses chan []tgbotapi.Update
+}
+
+func (b *pollingBot) GetUpdates(_ tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	return <-b.responses, nil
+}
+
+func TestPollingListening(t *testing.T) {
+	bot := &pollingBot{responses: make(chan []tgbotapi.Update)}
+	router := NewRouter(bot, nil, NewMemoryChatStateStore(time.Hour), 1, false)
+	var now atomic.Int64
+	now.Store(time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC).UnixNano())
+	router.now = func() time.Time { return time.Unix(0, now.Load()) }
+
+	done := make(chan struct{})
+	go func() {
+		router.StartListening()
+		close(done)
+	}()
+	require.False(t, router.Listening(), "not ready before the first getUpdates response")
+
+	bot.responses <- nil
+	require.Eventually(t, router.Listening, time.Second, 10*time.Millisecond)
+
+	now.Add(int64(pollStaleAfter))
+	require.False(t, router.Listening(), "not ready when getUpdates has not answered for too long")
+
+	bot.responses <- nil
+	require.Eventually(t, router.Listening, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, router.Shutdown(context.Background()))
+	<-done
+	require.False(t, router.Listening())
+}
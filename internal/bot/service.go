@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -10,7 +11,6 @@ import (
 	"github.com/AnVladic/DentalTelegramBot/pkg"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +23,7 @@ func (h *TelegramBotHandler) Send(
 	msgConfig tgbotapi.MessageConfig, errNotifyUser bool) (*tgbotapi.Message, error) {
 	msg, err := h.bot.Send(msgConfig)
 	if err != nil {
+		sendFailuresTotal.WithLabelValues(h.tenant, "send").Inc()
 		logrus.WithFields(logrus.Fields{
 			"chat_id": msgConfig.ChatID,
 			"text":    msgConfig.Text,
@@ -40,6 +41,7 @@ func (h *TelegramBotHandler) Edit(
 	msgConfig tgbotapi.EditMessageTextConfig, errNotifyUser bool) (tgbotapi.Message, error) {
 	msg, err := h.bot.Send(msgConfig)
 	if err != nil {
+		sendFailuresTotal.WithLabelValues(h.tenant, "edit").Inc()
 		logrus.WithFields(logrus.Fields{
 			"chat_id": msgConfig.ChatID,
 			"text":    msgConfig.ReplyMarkup,
@@ -57,6 +59,7 @@ func (h *TelegramBotHandler) EditReplyMarkup(
 	msgConfig tgbotapi.EditMessageReplyMarkupConfig, errNotifyUser bool) (tgbotapi.Message, error) {
 	msg, err := h.bot.Send(msgConfig)
 	if err != nil {
+		sendFailuresTotal.WithLabelValues(h.tenant, "edit_markup").Inc()
 		logrus.WithFields(logrus.Fields{
 			"chat_id": msgConfig.ChatID,
 			"text":    msgConfig.ReplyMarkup,
@@ -84,7 +87,7 @@ func findScheduleByDate(day, month, year int, schedule []crm.DayInterval) *crm.D
 
 func (h *TelegramBotHandler) RequestContactKeyboard() tgbotapi.ReplyKeyboardMarkup {
 	phoneButton := tgbotapi.KeyboardButton{
-		Text:           "📞 Отправить номер телефона",
+		Text:           h.userTexts.SendPhoneButton,
 		RequestContact: true,
 	}
 
@@ -120,7 +123,7 @@ func (h *TelegramBotHandler) AddBackButton(
 }
 
 func (h *TelegramBotHandler) GenerateTimesheetCalendar(
-	schedule []crm.DayInterval, currentDate time.Time, doctorID int64) tgbotapi.InlineKeyboardMarkup {
+	schedule []crm.DayInterval, currentDate time.Time, doctorID int64, back string) tgbotapi.InlineKeyboardMarkup {
 	textDayFunc := func(day, month, year int) (string, string) {
 		btnText := fmt.Sprintf("%v", day)
 		now := h.nowTime.Now()
@@ -149,26 +152,28 @@ func (h *TelegramBotHandler) GenerateTimesheetCalendar(
 	month := currentDate.Month()
 	showPrev := now.Year() < year || now.Month() < month
 	keyboard := tgbotapi.InlineKeyboardMarkup{}
-	keyboard = addMonthYearRow(year, month, keyboard)
-	keyboard = addDaysNamesRow(keyboard)
+	keyboard = addMonthYearRow(year, h.userTexts.Months[month-1], keyboard)
+	keyboard = addDaysNamesRow(h.userTexts.Weekdays, keyboard)
 	keyboard = h.generateMonth(year, int(month), keyboard, textDayFunc)
 	keyboard = addSpecialButtons(year, int(month), keyboard, specialButtonCallbackData, showPrev,
 		currentDate.Sub(now) < 365*24*time.Hour)
-	keyboard = h.AddBackButton(keyboard, "appointments")
+	keyboard = h.AddBackButton(keyboard, back)
 	return keyboard
 }
 
 func (h *TelegramBotHandler) ChangeTimesheet(
-	query *tgbotapi.CallbackQuery, start time.Time, text *string, doctorID int64, duration int,
+	ctx context.Context,
+	query *tgbotapi.CallbackQuery, start time.Time, text *string, doctorID, branchID int64, duration int,
+	back string,
 ) {
 	nextMonth := start.AddDate(0, 1, -start.Day()+1)
 	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
-	schedule, err := h.dentalProClient.FreeIntervals(
-		start, nextMonth, -1, doctorID, h.branchID, duration,
+	schedule, err := h.dentalProClient.FreeIntervals(ctx,
+		start, nextMonth, -1, doctorID, branchID, duration,
 	)
 	if err != nil {
-		_, _ = h.Send(tgbotapi.NewMessage(query.Message.Chat.ID, h.userTexts.InternalError), false)
-		logrus.Error(err)
+		_, _ = h.Send(tgbotapi.NewMessage(query.Message.Chat.ID, h.errorText(err)), false)
+		pkg.LoggerFromContext(ctx).Error(err)
 		return
 	}
 
@@ -176,14 +181,14 @@ func (h *TelegramBotHandler) ChangeTimesheet(
 		edit := tgbotapi.NewEditMessageReplyMarkup(
 			query.Message.Chat.ID,
 			query.Message.MessageID,
-			h.GenerateTimesheetCalendar(schedule, start, doctorID))
+			h.GenerateTimesheetCalendar(schedule, start, doctorID, back))
 		_, _ = h.EditReplyMarkup(edit, true)
 	} else {
 		edit := tgbotapi.NewEditMessageTextAndMarkup(
 			query.Message.Chat.ID,
 			query.Message.MessageID,
 			*text,
-			h.GenerateTimesheetCalendar(schedule, start, doctorID))
+			h.GenerateTimesheetCalendar(schedule, start, doctorID, back))
 		_, _ = h.Edit(edit, true)
 	}
 }
@@ -198,21 +203,32 @@ func (h *TelegramBotHandler) CheckDoctorBranch(doctor crm.Doctor, branchID int64
 	return false
 }
 
-func (h *TelegramBotHandler) ChangeToDoctorsMarkup(message *tgbotapi.Message) {
-	log := logrus.WithFields(logrus.Fields{
+// SyncDoctors сохраняет врачей DentalPro в базу. Вызывается, когда кэш заново запрашивает список врачей
+func (h *TelegramBotHandler) SyncDoctors(ctx context.Context, doctors []crm.Doctor) error {
+	doctorRepo := database.DoctorRepository{DB: h.db}
+	for _, doctor := range doctors {
+		if err := doctorRepo.Upsert(ctx, database.Doctor{ID: doctor.ID, FIO: doctor.FIO}); err != nil {
+			return fmt.Errorf("upsert doctor %d: %w", doctor.ID, err)
+		}
+	}
+	return nil
+}
+
+func (h *TelegramBotHandler) ChangeToDoctorsMarkup(ctx context.Context, message *tgbotapi.Message, branchID int64) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"module": "bot.service",
 		"func":   "ChangeToDoctorsMarkup",
 	})
 
-	doctors, err := h.dentalProClient.DoctorsList()
+	doctors, err := h.dentalProClient.DoctorsList(ctx)
 	if err != nil {
-		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.InternalError), false)
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.errorText(err)), false)
 		log.Error(err)
 		return
 	}
 	keyboard := tgbotapi.InlineKeyboardMarkup{}
 	for _, doctor := range doctors {
-		if !h.CheckDoctorBranch(doctor, h.branchID) {
+		if !h.CheckDoctorBranch(doctor, branchID) {
 			continue
 		}
 
@@ -222,21 +238,15 @@ func (h *TelegramBotHandler) ChangeToDoctorsMarkup(message *tgbotapi.Message) {
 		}
 		bytesData, _ := json.Marshal(data)
 
-		doctorRepo := database.DoctorRepository{DB: h.db}
-		err := doctorRepo.Upsert(database.Doctor{
-			ID:  doctor.ID,
-			FIO: doctor.FIO,
-		})
-		if h.checkAndLogError(err, log, message, "") {
-			return
-		}
-
 		title := fmt.Sprintf(
 			"%s - %s", doctor.FIO, strings.Join(pkg.GetMapValues(doctor.Departments), ", "))
 		btn := tgbotapi.NewInlineKeyboardButtonData(title, string(bytesData))
 		row := []tgbotapi.InlineKeyboardButton{btn}
 		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
 	}
+	if h.multiBranch() {
+		keyboard = h.AddBackButton(keyboard, "branches")
+	}
 	response := tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID,
 		h.userTexts.ChooseDoctor,
 		keyboard)
@@ -244,12 +254,11 @@ func (h *TelegramBotHandler) ChangeToDoctorsMarkup(message *tgbotapi.Message) {
 }
 
 // getOrCreatePatient return: Patient, created, error
-func (h *TelegramBotHandler) getOrCreatePatient(name, surname, phone string,
+func (h *TelegramBotHandler) getOrCreatePatient(ctx context.Context, name, surname, phone string,
 	message *tgbotapi.Message, log *logrus.Entry) (crm.Patient, bool, error) {
-	patient, err := h.dentalProClient.PatientByPhone(phone)
-	var reqErr *crm.RequestError
-	if errors.As(err, &reqErr) && reqErr.Code == http.StatusNotFound {
-		patient, err = h.dentalProClient.CreatePatient(name, surname, phone)
+	patient, err := h.dentalProClient.PatientByPhone(ctx, phone)
+	if errors.Is(err, crm.ErrNotFound) {
+		patient, err = h.dentalProClient.CreatePatient(ctx, name, surname, phone)
 		if h.checkAndLogError(err, log, message, "") {
 			return crm.Patient{}, false, err
 		}
@@ -265,12 +274,30 @@ func (h *TelegramBotHandler) checkAndLogError(
 	err error, log *logrus.Entry, message *tgbotapi.Message, msg string, args ...interface{}) bool {
 	if err != nil {
 		log.WithError(err).Errorf(msg, args...)
-		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.userTexts.InternalError), false)
+		_, _ = h.Send(tgbotapi.NewMessage(message.Chat.ID, h.errorText(err)), false)
 		return true
 	}
 	return false
 }
 
+// errorText подбирает текст для пользователя по категории ошибки DentalPro
+func (h *TelegramBotHandler) errorText(err error) string {
+	switch {
+	case errors.Is(err, crm.ErrNotFound):
+		return h.userTexts.CRMNotFoundError
+	case errors.Is(err, crm.ErrValidation):
+		return h.userTexts.CRMValidationError
+	case errors.Is(err, crm.ErrRateLimited):
+		return h.userTexts.CRMRateLimitedError
+	case errors.Is(err, crm.ErrUnauthorized):
+		return h.userTexts.CRMUnauthorizedError
+	case errors.Is(err, crm.ErrUpstream):
+		return h.userTexts.CRMUpstreamError
+	default:
+		return h.userTexts.InternalError
+	}
+}
+
 func (h *TelegramBotHandler) parseDoctorCallbackData(
 	query *tgbotapi.CallbackQuery) (TelegramBotDoctorCallbackData, error) {
 	var data TelegramBotDoctorCallbackData
@@ -279,9 +306,9 @@ func (h *TelegramBotHandler) parseDoctorCallbackData(
 }
 
 func (h *TelegramBotHandler) getOrCreateUser(
-	tgUserID int64, message *tgbotapi.Message, log *logrus.Entry) (*database.User, error) {
+	ctx context.Context, tgUserID int64, message *tgbotapi.Message, log *logrus.Entry) (*database.User, error) {
 	repository := database.UserRepository{DB: h.db}
-	user, _, err := repository.GetOrCreateByTelegramID(database.User{TgUserID: tgUserID})
+	user, _, err := repository.GetOrCreateByTelegramID(ctx, database.User{TgUserID: tgUserID})
 	if h.checkAndLogError(err, log, message, "GetOrCreateByTelegramID Unknown error") {
 		return nil, err
 	}
@@ -289,10 +316,11 @@ func (h *TelegramBotHandler) getOrCreateUser(
 }
 
 func (h *TelegramBotHandler) upsertRegisterDoctorID(
+	ctx context.Context,
 	userID int64, chatID int64, messageID int, doctorID int64, message *tgbotapi.Message, log *logrus.Entry,
 ) bool {
 	registerRepo := database.RegisterRepository{DB: h.db}
-	_, err := registerRepo.UpsertDoctorID(database.Register{
+	_, err := registerRepo.UpsertDoctorID(ctx, database.Register{
 		UserID:    userID,
 		ChatID:    chatID,
 		MessageID: messageID,
@@ -302,13 +330,13 @@ func (h *TelegramBotHandler) upsertRegisterDoctorID(
 }
 
 func (h *TelegramBotHandler) getAvailableAppointments(
-	user *database.User, doctorID int64, data string, log *logrus.Entry, message *tgbotapi.Message,
+	ctx context.Context, user *database.User, doctorID int64, data string, log *logrus.Entry, message *tgbotapi.Message,
 ) (map[int64]map[int64]crm.Appointment, error) {
 	var clientID int64 = 1
 	if user.DentalProID != nil && *user.DentalProID > 0 {
 		clientID = *user.DentalProID
 	}
-	appointments, err := h.dentalProClient.AvailableAppointments(clientID, []int64{doctorID}, false)
+	appointments, err := h.dentalProClient.AvailableAppointments(ctx, clientID, []int64{doctorID}, false)
 	if h.checkAndLogError(err, log, message, "Get Appointments error, %s", data) {
 		return nil, err
 	}
@@ -316,7 +344,7 @@ func (h *TelegramBotHandler) getAvailableAppointments(
 }
 
 func (h *TelegramBotHandler) getAppointment(
-	user *database.User, doctorID, appointmentID *int64, data string,
+	ctx context.Context, user *database.User, doctorID, appointmentID *int64, data string,
 	log *logrus.Entry, message *tgbotapi.Message,
 ) (*crm.Appointment, error) {
 	if doctorID == nil || appointmentID == nil {
@@ -325,7 +353,7 @@ func (h *TelegramBotHandler) getAppointment(
 		return nil, err
 	}
 
-	appointments, err := h.getAvailableAppointments(user, *doctorID, data, log, message)
+	appointments, err := h.getAvailableAppointments(ctx, user, *doctorID, data, log, message)
 	if err != nil {
 		return nil, err
 	}
@@ -367,7 +395,8 @@ func (h *TelegramBotHandler) createAppointmentButtons(
 			continue
 		}
 
-		text := fmt.Sprintf("(%d мин.) %s", appointment.Time, appointment.Name)
+		text := h.userTexts.AppointmentItem.Execute(
+			AppointmentData{Appointment: appointment.Name, Duration: appointment.Time})
 		button := tgbotapi.NewInlineKeyboardButtonData(text, string(data))
 		buttons = append(buttons, []tgbotapi.InlineKeyboardButton{button})
 	}
@@ -376,13 +405,14 @@ func (h *TelegramBotHandler) createAppointmentButtons(
 	return h.AddBackButton(keyboard, "doctors")
 }
 
-func (h *TelegramBotHandler) noAppointmentsText(doctorID int64, query *tgbotapi.CallbackQuery, log *logrus.Entry) string {
+func (h *TelegramBotHandler) noAppointmentsText(
+	ctx context.Context, doctorID int64, query *tgbotapi.CallbackQuery, log *logrus.Entry) string {
 	doctorRepo := database.DoctorRepository{DB: h.db}
-	doctor, err := doctorRepo.Get(doctorID)
+	doctor, err := doctorRepo.Get(ctx, doctorID)
 	if h.checkAndLogError(err, log, query.Message, "Get Doctor ByID error, %s", query.Data) {
 		return ""
 	}
-	return fmt.Sprintf(h.userTexts.DontHasAppointments, doctor.FIO)
+	return h.userTexts.DontHasAppointments.Execute(DoctorData{Doctor: doctor.FIO})
 }
 
 func (h *TelegramBotHandler) parseChoiceAppointmentCallbackData(
@@ -441,10 +471,10 @@ func (h *TelegramBotHandler) parseTelegramChoiceDayCallbackData(
 }
 
 func (h *TelegramBotHandler) updateAppointmentRegister(
-	user database.User, message *tgbotapi.Message, appointmentID int64, log *logrus.Entry,
+	ctx context.Context, user database.User, message *tgbotapi.Message, appointmentID int64, log *logrus.Entry,
 ) error {
 	registerRepo := database.RegisterRepository{DB: h.db}
-	err := registerRepo.UpdateAppointmentID(database.Register{
+	err := registerRepo.UpdateAppointmentID(ctx, database.Register{
 		UserID:        user.ID,
 		ChatID:        message.Chat.ID,
 		MessageID:     message.MessageID,
@@ -457,10 +487,10 @@ func (h *TelegramBotHandler) updateAppointmentRegister(
 }
 
 func (h *TelegramBotHandler) getRegister(
-	user database.User, message *tgbotapi.Message, log *logrus.Entry,
+	ctx context.Context, user database.User, message *tgbotapi.Message, log *logrus.Entry,
 ) (*database.Register, error) {
 	registerRepo := database.RegisterRepository{DB: h.db}
-	register, err := registerRepo.Get(user.ID, message.Chat.ID, message.MessageID)
+	register, err := registerRepo.Get(ctx, user.ID, message.Chat.ID, message.MessageID)
 	if h.checkAndLogError(
 		err, log, message, "Get Register by %d, %d, %d", user.ID, message.Chat.ID, message.MessageID) {
 		return nil, err
@@ -469,8 +499,8 @@ func (h *TelegramBotHandler) getRegister(
 }
 
 func (h *TelegramBotHandler) getCRMDoctor(
-	doctorID *int64, message *tgbotapi.Message, log *logrus.Entry) (*crm.Doctor, error) {
-	doctors, err := h.dentalProClient.DoctorsList()
+	ctx context.Context, doctorID *int64, message *tgbotapi.Message, log *logrus.Entry) (*crm.Doctor, error) {
+	doctors, err := h.dentalProClient.DoctorsList(ctx)
 	if err != nil {
 		log.Error(err)
 		response := tgbotapi.NewMessage(message.Chat.ID, h.userTexts.InternalError)
@@ -496,13 +526,10 @@ func (h *TelegramBotHandler) getCRMDoctor(
 }
 
 func (h *TelegramBotHandler) getCRMPatient(
-	phoneNumber string, message *tgbotapi.Message, log *logrus.Entry) (*crm.Patient, error) {
-	patient, err := h.dentalProClient.PatientByPhone(phoneNumber)
-	var reqErr *crm.RequestError
-	if errors.As(err, &reqErr) {
-		if reqErr.Code == http.StatusNotFound {
-			return nil, err
-		}
+	ctx context.Context, phoneNumber string, message *tgbotapi.Message, log *logrus.Entry) (*crm.Patient, error) {
+	patient, err := h.dentalProClient.PatientByPhone(ctx, phoneNumber)
+	if errors.Is(err, crm.ErrNotFound) {
+		return nil, err
 	}
 
 	if h.checkAndLogError(err, log, message, "PatientByPhone %s", phoneNumber) {
@@ -512,38 +539,30 @@ func (h *TelegramBotHandler) getCRMPatient(
 }
 
 func (h *TelegramBotHandler) upsertCRMPatient(
-	patient crm.Patient, message *tgbotapi.Message, log *logrus.Entry) (*crm.Patient, error) {
-	dentalProUser, err := h.getCRMPatient(patient.Phone, message, log)
-	var reqErr *crm.RequestError
-	if errors.As(err, &reqErr) {
-		if reqErr.Code == http.StatusNotFound {
-			newPatient, err := h.dentalProClient.CreatePatient(
-				patient.Name, patient.Surname, patient.Phone,
-			)
-			if h.checkAndLogError(err, log, message, "CreatePatient %s", patient.Phone) {
-				return nil, err
-			}
-			return &newPatient, nil
+	ctx context.Context, patient crm.Patient, message *tgbotapi.Message, log *logrus.Entry) (*crm.Patient, error) {
+	dentalProUser, err := h.getCRMPatient(ctx, patient.Phone, message, log)
+	if errors.Is(err, crm.ErrNotFound) {
+		newPatient, err := h.dentalProClient.CreatePatient(ctx,
+			patient.Name, patient.Surname, patient.Phone,
+		)
+		if h.checkAndLogError(err, log, message, "CreatePatient %s", patient.Phone) {
+			return nil, err
 		}
-		return nil, err
+		return &newPatient, nil
 	}
 	if err != nil {
 		return nil, err
 	}
 	patient.ExternalID = dentalProUser.ExternalID
-	status, err := h.dentalProClient.EditPatient(patient)
-	if err != nil || !status.Status {
-		if err == nil {
-			err = fmt.Errorf("EditPatient error %s", status.Message)
-		}
-		h.checkAndLogError(err, log, message, "EditPatient %s", patient.Phone)
+	_, err = h.dentalProClient.EditPatient(ctx, patient)
+	if h.checkAndLogError(err, log, message, "EditPatient %s", patient.Phone) {
 		return nil, err
 	}
-	return &patient, err
+	return &patient, nil
 }
 
 func (h *TelegramBotHandler) getDoctor(
-	doctorID *int64, message *tgbotapi.Message, log *logrus.Entry) (*database.Doctor, error) {
+	ctx context.Context, doctorID *int64, message *tgbotapi.Message, log *logrus.Entry) (*database.Doctor, error) {
 	if doctorID == nil {
 		err := fmt.Errorf("doctor ID is nil")
 		h.checkAndLogError(err, log, message, "doctor ID is nil")
@@ -551,7 +570,7 @@ func (h *TelegramBotHandler) getDoctor(
 	}
 
 	doctorRepo := database.DoctorRepository{DB: h.db}
-	doctor, err := doctorRepo.Get(*doctorID)
+	doctor, err := doctorRepo.Get(ctx, *doctorID)
 	if h.checkAndLogError(err, log, message, "Get Doctor By %d", doctorID) {
 		return nil, err
 	}
@@ -567,8 +586,10 @@ func (h *TelegramBotHandler) parseDate(
 	return date, nil
 }
 
+// getCRMFreeIntervals возвращает свободное время врача за день через client:
+// h.dentalProClient для показа, crm.Uncached для проверки перед записью
 func (h *TelegramBotHandler) getCRMFreeIntervals(
-	doctorID *int64, date time.Time, duration int,
+	ctx context.Context, client crm.IDentalProClient, doctorID *int64, branchID int64, date time.Time, duration int,
 	message *tgbotapi.Message, log *logrus.Entry) ([]crm.TimeRange, error) {
 	if doctorID == nil {
 		err := fmt.Errorf("doctor ID is nil")
@@ -579,12 +600,13 @@ func (h *TelegramBotHandler) getCRMFreeIntervals(
 	}
 
 	date = ToDate(date)
-	freeIntervals, err := h.dentalProClient.FreeIntervals(
-		date, date, -1, *doctorID, h.branchID, duration)
+	freeIntervals, err := client.FreeIntervals(ctx,
+		date, date, -1, *doctorID, branchID, duration)
 	if len(freeIntervals) == 0 {
 		return []crm.TimeRange{}, err
 	}
-	times := freeIntervals[0].Slots[0].Time
+	// ответ CRM может быть общим для нескольких запросов (кэш), поэтому сортируем копию
+	times := append([]crm.TimeRange(nil), freeIntervals[0].Slots[0].Time...)
 	sort.Slice(times, func(i, j int) bool {
 		return time.Time(times[i].Begin).Before(time.Time(times[j].Begin))
 	})
@@ -732,12 +754,12 @@ func (h *TelegramBotHandler) createFreeIntervalsButtons(
 }
 
 func (h *TelegramBotHandler) updateRegisterDatetime(
-	register database.Register,
+	ctx context.Context, register database.Register,
 	message *tgbotapi.Message,
 	log *logrus.Entry,
 ) error {
 	registerRepo := database.RegisterRepository{DB: h.db}
-	err := registerRepo.UpdateDatetime(register)
+	err := registerRepo.UpdateDatetime(ctx, register)
 	if h.checkAndLogError(err, log, message, "Update Register err") {
 		return err
 	}
@@ -769,26 +791,25 @@ func (h *TelegramBotHandler) createApproveRegisterKeyboard() tgbotapi.InlineKeyb
 }
 
 func (h *TelegramBotHandler) createApproveMessage(
-	register *database.Register,
+	ctx context.Context, register *database.Register,
 	user *database.User,
 	message *tgbotapi.Message,
 	log *logrus.Entry,
 ) {
-	dentalProUser, err := h.getCRMPatient(*user.Phone, message, log)
-	var reqErr *crm.RequestError
-	if errors.As(err, &reqErr) && reqErr.Code != http.StatusNotFound {
+	dentalProUser, err := h.getCRMPatient(ctx, *user.Phone, message, log)
+	if err != nil && !errors.Is(err, crm.ErrNotFound) {
 		return
 	}
 
 	selfUser := SelfUser{user, dentalProUser}
 
-	appointment, err := h.getAppointment(
+	appointment, err := h.getAppointment(ctx,
 		user, register.DoctorID, register.AppointmentID, "", log, message)
 	if err != nil {
 		return
 	}
 
-	doctor, err := h.getDoctor(register.DoctorID, message, log)
+	doctor, err := h.getDoctor(ctx, register.DoctorID, message, log)
 	if err != nil {
 		return
 	}
@@ -801,16 +822,44 @@ func (h *TelegramBotHandler) createApproveMessage(
 			message.Chat.ID, message.MessageID, h.userTexts.ApproveRegisterTimeLimit, backKeyboard,
 		)
 		_, _ = h.Edit(edit, true)
+	} else if register.MoveRecordID != nil {
+		if user.DentalProID == nil {
+			h.checkAndLogError(fmt.Errorf("user %d has no dental pro id", user.ID), log, message, "")
+			return
+		}
+		oldRecord, err := h.findCRMRecord(ctx, *user.DentalProID, *register.MoveRecordID, message, log)
+		if err != nil {
+			return
+		}
+		if oldRecord == nil {
+			edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, h.userTexts.HasNoMoveRecord)
+			_, _ = h.Edit(edit, true)
+			return
+		}
+		text := h.userTexts.ApproveMoveRecord.Execute(ApproveMoveRecordData{
+			ApproveRegisterData: ApproveRegisterData{
+				Datetime:    register.Datetime.Format("2006-01-02 15:04"),
+				Doctor:      doctor.FIO,
+				Appointment: appointment.Name,
+				Duration:    appointment.Time,
+				LastName:    selfUser.GetSelfLastName(),
+				FirstName:   selfUser.GetSelfFirstName(),
+			},
+			OldDatetime: time.Time(oldRecord.DateStart).Format("2006-01-02 15:04"),
+		})
+		edit := tgbotapi.NewEditMessageTextAndMarkup(
+			message.Chat.ID, message.MessageID, text, h.createApproveRegisterKeyboard())
+		edit.ParseMode = HTML
+		_, _ = h.Edit(edit, true)
 	} else {
-		text := fmt.Sprintf(
-			h.userTexts.ApproveRegister,
-			register.Datetime.Format("2006-01-02 15:04"),
-			doctor.FIO,
-			appointment.Name,
-			appointment.Time,
-			selfUser.GetSelfLastName(),
-			selfUser.GetSelfFirstName(),
-		)
+		text := h.userTexts.ApproveRegister.Execute(ApproveRegisterData{
+			Datetime:    register.Datetime.Format("2006-01-02 15:04"),
+			Doctor:      doctor.FIO,
+			Appointment: appointment.Name,
+			Duration:    appointment.Time,
+			LastName:    selfUser.GetSelfLastName(),
+			FirstName:   selfUser.GetSelfFirstName(),
+		})
 		edit := tgbotapi.NewEditMessageTextAndMarkup(
 			message.Chat.ID, message.MessageID, text, h.createApproveRegisterKeyboard())
 		edit.ParseMode = HTML
@@ -818,19 +867,80 @@ func (h *TelegramBotHandler) createApproveMessage(
 	}
 }
 
-func (h *TelegramBotHandler) getCRMRecordsList(crmUserID int64,
+func (h *TelegramBotHandler) getCRMRecordsList(ctx context.Context, crmUserID int64,
 	message *tgbotapi.Message,
 	log *logrus.Entry) ([]crm.ShortRecord, error) {
-	records, err := h.dentalProClient.PatientRecords(crmUserID)
+	records, err := h.dentalProClient.PatientRecords(ctx, crmUserID)
 	if h.checkAndLogError(err, log, message, "Get CRM Record List err") {
 		return nil, err
 	}
 	return records, nil
 }
 
+func (h *TelegramBotHandler) findCRMRecord(
+	ctx context.Context,
+	patientID, recordID int64, message *tgbotapi.Message, log *logrus.Entry) (*crm.ShortRecord, error) {
+	records, err := h.getCRMRecordsList(ctx, patientID, message, log)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ID == recordID {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// findRecordAppointment ищет среди доступных приемов врача тот, на который оформлена запись.
+// В записи CRM нет ID приема, поэтому сравниваем по названию, а затем по длительности
+func (h *TelegramBotHandler) findRecordAppointment(
+	ctx context.Context, user *database.User, record crm.ShortRecord, message *tgbotapi.Message, log *logrus.Entry,
+) (*crm.Appointment, error) {
+	appointments, err := h.getAvailableAppointments(ctx, user, record.DoctorID, "", log, message)
+	if err != nil {
+		return nil, err
+	}
+	var byDuration *crm.Appointment
+	for _, doctorAppointments := range appointments {
+		for _, appointment := range doctorAppointments {
+			if appointment.Name == record.Name {
+				return &appointment, nil
+			}
+			if byDuration == nil && appointment.Time == record.Duration {
+				byDuration = &appointment
+			}
+		}
+	}
+	return byDuration, nil
+}
+
+func (h *TelegramBotHandler) createRecordsKeyboard(
+	records []crm.ShortRecord, command string, itemText Template[RecordButtonData]) tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+	keyboard.InlineKeyboard = make([][]tgbotapi.InlineKeyboardButton, len(records))
+	for i, record := range records {
+		datetime := time.Time(record.DateStart)
+		data, _ := json.Marshal(TelegramRecordChangeCallback{
+			CallbackData{command},
+			record.ID,
+		})
+		keyboard.InlineKeyboard[i] = []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(
+			itemText.Execute(RecordButtonData{
+				Number:   i + 1,
+				Datetime: datetime.Format("2006-01-02 15:04"),
+				Doctor:   record.DoctorName,
+			}),
+			string(data)),
+		}
+	}
+	return keyboard
+}
+
 func (h *TelegramBotHandler) findRecordByPatientAndDoctor(
+	ctx context.Context,
 	doctorID, patientID int64, message *tgbotapi.Message, log *logrus.Entry) (*crm.ShortRecord, error) {
-	records, err := h.dentalProClient.PatientRecords(patientID)
+	records, err := h.dentalProClient.PatientRecords(ctx, patientID)
 	if h.checkAndLogError(err, log, message, "PatientRecords %s", err) {
 		return nil, err
 	}
@@ -843,9 +953,9 @@ func (h *TelegramBotHandler) findRecordByPatientAndDoctor(
 }
 
 func (h *TelegramBotHandler) updateDentalProID(
-	telegramID, dentalProID int64, message *tgbotapi.Message, log *logrus.Entry) error {
+	ctx context.Context, telegramID, dentalProID int64, message *tgbotapi.Message, log *logrus.Entry) error {
 	userRepo := database.UserRepository{DB: h.db}
-	err := userRepo.UpdateDentalProIDByTelegramID(telegramID, dentalProID)
+	err := userRepo.UpdateDentalProIDByTelegramID(ctx, telegramID, dentalProID)
 	if h.checkAndLogError(
 		err, log, message, "updateDentalProID tg=%d dentalPro=%d", telegramID, dentalProID) {
 		return err
@@ -855,39 +965,34 @@ func (h *TelegramBotHandler) updateDentalProID(
 
 // Запрашивает у юзера номер телефона
 func (h *TelegramBotHandler) noAuthRequest(
-	successFunc func(message *tgbotapi.Message, chatState *TelegramChatState), chatState *TelegramChatState,
-	message *tgbotapi.Message) error {
+	ctx context.Context, successStep ChatStep, chatState *TelegramChatState, message *tgbotapi.Message) error {
 
-	ok, err := h.GetPhoneNumber(message, chatState)
+	ok, err := h.GetPhoneNumber(ctx, message, chatState)
 	if err != nil {
 		_ = fmt.Errorf("GetPhoneNumber error %w", err)
 		return err
 	}
 	if !ok {
-		chatState.UpdateChatState(func(message *tgbotapi.Message, lChatState *TelegramChatState) {
-			_ = h.noAuthRequest(successFunc, lChatState, message)
-		})
+		chatState.UpdateChatState(StepNoAuthRequest, onSuccessPayload{&successStep})
 		return nil
 	}
-	successFunc(message, chatState)
+	h.RunStep(ctx, successStep, message, chatState)
 	return nil
 }
 
 func (h *TelegramBotHandler) findUserAndCheckPhoneNumber(
-	successFunc func(message *tgbotapi.Message, chatState *TelegramChatState), chatState *TelegramChatState,
+	ctx context.Context, successStep ChatStep, chatState *TelegramChatState,
 	fromID int64,
 	message *tgbotapi.Message, log *logrus.Entry,
 ) (*database.User, error) {
 	repository := database.UserRepository{DB: h.db}
-	user, err := repository.GetUserByTelegramID(fromID)
+	user, err := repository.GetUserByTelegramID(ctx, fromID)
 	if errors.Is(err, sql.ErrNoRows) || user.Phone == nil || *user.Phone == "" {
 		if err == nil {
 			err = fmt.Errorf("user.Phone is empty")
 		}
 		h.RequestPhoneNumber(message)
-		chatState.UpdateChatState(func(message *tgbotapi.Message, chatState *TelegramChatState) {
-			_ = h.noAuthRequest(successFunc, chatState, message)
-		})
+		chatState.UpdateChatState(StepNoAuthRequest, onSuccessPayload{&successStep})
 		return nil, err
 	} else if h.checkAndLogError(err, log, message, "") {
 		return nil, err
@@ -896,15 +1001,15 @@ func (h *TelegramBotHandler) findUserAndCheckPhoneNumber(
 }
 
 func (h *TelegramBotHandler) getDentalProIDByUser(
-	user *database.User, message *tgbotapi.Message, log *logrus.Entry,
+	ctx context.Context, user *database.User, message *tgbotapi.Message, log *logrus.Entry,
 ) (int64, error) {
 	if user.DentalProID == nil {
-		patient, _, err := h.getOrCreatePatient(*user.Name, *user.Lastname, *user.Phone, message, log)
+		patient, _, err := h.getOrCreatePatient(ctx, *user.Name, *user.Lastname, *user.Phone, message, log)
 		if h.checkAndLogError(err, log, message, "") {
 			return 0, err
 		}
 		user.DentalProID = &patient.ExternalID
-		err = h.updateDentalProID(message.From.ID, patient.ExternalID, message, log)
+		err = h.updateDentalProID(ctx, message.From.ID, patient.ExternalID, message, log)
 		if err != nil {
 			return 0, err
 		}
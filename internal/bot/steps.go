@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// newChatSteps - реестр шагов диалога, по которому восстанавливается сохраненное состояние чата.
+// Шаги получают обработчик параметром, поэтому реестр строится один раз в NewTelegramBotHandler
+func newChatSteps() map[string]StepMethod {
+	return map[string]StepMethod{
+		StepNoAuthChangeName: onSuccessStep((*TelegramBotHandler).NoAuthChangeNameHandler),
+		StepChangeFirstName:  onSuccessStep((*TelegramBotHandler).ChangeFirstNameHandler),
+		StepChangeLastName:   onSuccessStep((*TelegramBotHandler).ChangeLastNameHandler),
+		StepApproveRecord: func(h *TelegramBotHandler, ctx context.Context,
+			message *tgbotapi.Message, chatState *TelegramChatState, payload json.RawMessage) {
+			var data approveRecordPayload
+			if h.decodeStepPayload(ctx, StepApproveRecord, payload, &data, message) {
+				h.ApproveRecordHandler(ctx, data, message, chatState)
+			}
+		},
+		StepNoAuthApproveRegister: callbackQueryStep(
+			StepNoAuthApproveRegister, (*TelegramBotHandler).NoAuthApproveRegister),
+		StepRegisterAfterChangeName: callbackQueryStep(
+			StepRegisterAfterChangeName, (*TelegramBotHandler).RegisterAfterChangeName),
+		StepNoAuthRequest: func(h *TelegramBotHandler, ctx context.Context,
+			message *tgbotapi.Message, chatState *TelegramChatState, payload json.RawMessage) {
+			var data onSuccessPayload
+			if h.decodeStepPayload(ctx, StepNoAuthRequest, payload, &data, message) && data.OnSuccess != nil {
+				_ = h.noAuthRequest(ctx, *data.OnSuccess, chatState, message)
+			}
+		},
+		StepShowRecordsList: messageStep((*TelegramBotHandler).ShowRecordsListHandler),
+		StepDeleteRecord:    messageStep((*TelegramBotHandler).DeleteRecordHandler),
+		StepMoveRecord:      messageStep((*TelegramBotHandler).MoveRecordHandler),
+		StepApproveDeleteRecord: callbackQueryStep(StepApproveDeleteRecord,
+			func(h *TelegramBotHandler, ctx context.Context,
+				query *tgbotapi.CallbackQuery, _ *tgbotapi.Message, chatState *TelegramChatState) {
+				h.ApproveDeleteRecord(ctx, query, chatState)
+			}),
+		StepMoveRecordCallback: callbackQueryStep(StepMoveRecordCallback,
+			func(h *TelegramBotHandler, ctx context.Context,
+				query *tgbotapi.CallbackQuery, _ *tgbotapi.Message, chatState *TelegramChatState) {
+				h.MoveRecordCallback(ctx, query, chatState)
+			}),
+	}
+}
+
+// RunStep выполняет шаг диалога. Возвращает false, если шаг не зарегистрирован
+func (h *TelegramBotHandler) RunStep(
+	ctx context.Context, step ChatStep, message *tgbotapi.Message, chatState *TelegramChatState) bool {
+	method, ok := h.steps[step.Name]
+	if !ok {
+		pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+			"module": "bot.steps",
+			"func":   "RunStep",
+		}).Errorf("unknown chat step \"%s\"", step.Name)
+		return false
+	}
+	method(h, ctx, message, chatState, step.Payload)
+	return true
+}
+
+func messageStep(
+	handler func(h *TelegramBotHandler, ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState),
+) StepMethod {
+	return func(h *TelegramBotHandler, ctx context.Context,
+		message *tgbotapi.Message, chatState *TelegramChatState, _ json.RawMessage) {
+		handler(h, ctx, message, chatState)
+	}
+}
+
+func onSuccessStep(
+	handler func(h *TelegramBotHandler, ctx context.Context,
+		message *tgbotapi.Message, chatState *TelegramChatState, onSuccess *ChatStep),
+) StepMethod {
+	return func(h *TelegramBotHandler, ctx context.Context,
+		message *tgbotapi.Message, chatState *TelegramChatState, payload json.RawMessage) {
+		var data onSuccessPayload
+		if len(payload) > 0 && !h.decodeStepPayload(ctx, "on_success", payload, &data, message) {
+			return
+		}
+		handler(h, ctx, message, chatState, data.OnSuccess)
+	}
+}
+
+func callbackQueryStep(
+	name string,
+	handler func(h *TelegramBotHandler, ctx context.Context,
+		query *tgbotapi.CallbackQuery, message *tgbotapi.Message, chatState *TelegramChatState),
+) StepMethod {
+	return func(h *TelegramBotHandler, ctx context.Context,
+		message *tgbotapi.Message, chatState *TelegramChatState, payload json.RawMessage) {
+		var data callbackQueryPayload
+		if h.decodeStepPayload(ctx, name, payload, &data, message) {
+			handler(h, ctx, data.Query(), message, chatState)
+		}
+	}
+}
+
+func (h *TelegramBotHandler) decodeStepPayload(
+	ctx context.Context, name string, payload json.RawMessage, target any, message *tgbotapi.Message) bool {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.steps",
+		"func":   "decodeStepPayload",
+	})
+	err := fmt.Errorf("chat step %s has empty payload", name)
+	if len(payload) > 0 {
+		err = json.Unmarshal(payload, target)
+	}
+	return !h.checkAndLogError(err, log, message, "decode chat step %s", name)
+}
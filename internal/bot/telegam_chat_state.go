@@ -1,31 +1,122 @@
 package bot
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"sync"
+	"github.com/sirupsen/logrus"
 	"time"
 )
 
+// Названия шагов диалога. Они сохраняются в базе, поэтому их нельзя переименовывать
+const (
+	StepNoAuthChangeName        = "no_auth_change_name"
+	StepChangeFirstName         = "change_first_name"
+	StepChangeLastName          = "change_last_name"
+	StepApproveRecord           = "approve_record"
+	StepNoAuthApproveRegister   = "no_auth_approve_register"
+	StepRegisterAfterChangeName = "register_after_change_name"
+	StepNoAuthRequest           = "no_auth_request"
+	StepShowRecordsList         = "show_records_list"
+	StepDeleteRecord            = "delete_record"
+	StepMoveRecord              = "move_record"
+	StepApproveDeleteRecord     = "approve_delete_record"
+	StepMoveRecordCallback      = "move_record_callback"
+)
+
+// ChatStep - следующий шаг диалога: имя обработчика из реестра и его данные в JSON
+type ChatStep struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
 type TelegramChatState struct {
-	NextFunc  *func(*tgbotapi.Message, *TelegramChatState)
+	ChatStep
 	Timestamp time.Time
+	revision  int
+}
+
+type StepMethod func(
+	h *TelegramBotHandler, ctx context.Context, message *tgbotapi.Message, chatState *TelegramChatState,
+	payload json.RawMessage,
+)
+
+// onSuccessPayload хранит шаг, который нужно выполнить после завершения текущего
+type onSuccessPayload struct {
+	OnSuccess *ChatStep `json:"on_success,omitempty"`
+}
+
+// callbackQueryPayload хранит нужную обработчикам часть CallbackQuery
+type callbackQueryPayload struct {
+	FromID    int64  `json:"from_id"`
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Data      string `json:"data"`
+}
+
+func NewChatStep(name string, payload any) ChatStep {
+	step := ChatStep{Name: name}
+	if payload == nil {
+		return step
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logrus.WithError(err).Errorf("marshal chat step %s payload", name)
+		return step
+	}
+	step.Payload = data
+	return step
 }
 
-func (s *TelegramChatState) UpdateChatState(nextFunc func(*tgbotapi.Message, *TelegramChatState)) {
-	s.NextFunc = &nextFunc
+func newCallbackQueryPayload(query *tgbotapi.CallbackQuery) callbackQueryPayload {
+	return callbackQueryPayload{
+		FromID:    query.From.ID,
+		ChatID:    query.Message.Chat.ID,
+		MessageID: query.Message.MessageID,
+		Data:      query.Data,
+	}
+}
+
+func (p callbackQueryPayload) Query() *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		From: &tgbotapi.User{ID: p.FromID},
+		Message: &tgbotapi.Message{
+			MessageID: p.MessageID,
+			Chat:      &tgbotapi.Chat{ID: p.ChatID},
+		},
+		Data: p.Data,
+	}
+}
+
+func (s *TelegramChatState) UpdateChatState(name string, payload any) {
+	s.SetStep(NewChatStep(name, payload))
+}
+
+func (s *TelegramChatState) SetStep(step ChatStep) {
+	s.ChatStep = step
 	s.Timestamp = time.Now()
+	s.revision++
+}
+
+func (s *TelegramChatState) Clear() {
+	s.SetStep(ChatStep{})
+}
+
+func chatStateFromDB(state *database.ChatState) *TelegramChatState {
+	chatState := &TelegramChatState{Timestamp: state.UpdatedAt}
+	chatState.Name = state.Step
+	if state.Payload != nil {
+		chatState.Payload = json.RawMessage(*state.Payload)
+	}
+	return chatState
 }
 
-func CleanupUserStates(mu *sync.Mutex, states *map[int64]*TelegramChatState) {
-	for {
-		time.Sleep(1 * time.Hour)
-		now := time.Now()
-		for chatID, state := range *states {
-			if now.Sub(state.Timestamp) > 24*time.Hour {
-				mu.Lock()
-				delete(*states, chatID) // Удаляем состояния старше 24 часов
-				mu.Unlock()
-			}
-		}
+func chatStateToDB(chatID int64, chatState *TelegramChatState) database.ChatState {
+	state := database.ChatState{ChatID: chatID, Step: chatState.Name, UpdatedAt: chatState.Timestamp.UTC()}
+	if len(chatState.Payload) > 0 {
+		payload := string(chatState.Payload)
+		state.Payload = &payload
 	}
+	return state
 }
@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Template - текст с подстановками text/template, например {{.Doctor}}.
+// T - данные, которые передаются в шаблон, их поля и есть доступные подстановки
+type Template[T any] struct {
+	text     string
+	template *template.Template
+}
+
+func NewTemplate[T any](text string) (Template[T], error) {
+	parsed, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return Template[T]{}, err
+	}
+	return Template[T]{text: text, template: parsed}, nil
+}
+
+func (t *Template[T]) UnmarshalYAML(node *yaml.Node) error {
+	var text string
+	if err := node.Decode(&text); err != nil {
+		return err
+	}
+	parsed, err := NewTemplate[T](text)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*t = parsed
+	return nil
+}
+
+func (t Template[T]) MarshalYAML() (any, error) {
+	return t.text, nil
+}
+
+func (t Template[T]) String() string {
+	return t.text
+}
+
+// Execute подставляет data в шаблон. Шаблоны проверяются при загрузке каталога,
+// поэтому ошибка здесь - повод для лога, а пользователь получает текст без подстановок
+func (t Template[T]) Execute(data T) string {
+	if t.template == nil {
+		return t.text
+	}
+	var text strings.Builder
+	if err := t.template.Execute(&text, data); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"module": "bot.templates",
+			"func":   "Execute",
+		}).WithError(err).Error("execute template")
+		return t.text
+	}
+	return text.String()
+}
+
+// check выполняет шаблон на пустых данных: так находятся подстановки, которых нет в T
+func (t Template[T]) check() error {
+	if t.text == "" {
+		return fmt.Errorf("text is empty")
+	}
+	var data T
+	return t.template.Execute(io.Discard, data)
+}
+
+type BranchItemData struct {
+	Name    string
+	Address string
+}
+
+type DoctorData struct {
+	Doctor string
+}
+
+type IntervalsData struct {
+	Date        string
+	Doctor      string
+	Appointment string
+}
+
+type AppointmentData struct {
+	Appointment string
+	Duration    int
+}
+
+type ApproveRegisterData struct {
+	Datetime    string
+	Doctor      string
+	Appointment string
+	Duration    int
+	LastName    string
+	FirstName   string
+}
+
+type ApproveMoveRecordData struct {
+	ApproveRegisterData
+	OldDatetime string
+}
+
+// RecordCreatedData - созданная в DentalPro запись, дата и время в ней отдельно
+type RecordCreatedData struct {
+	Date        string
+	Time        string
+	Doctor      string
+	Appointment string
+	Duration    int
+	LastName    string
+	FirstName   string
+}
+
+type NameData struct {
+	LastName  string
+	FirstName string
+}
+
+type RecordItemData struct {
+	Number      int
+	Datetime    string
+	Doctor      string
+	DoctorGroup string
+	Appointment string
+	Duration    int
+}
+
+type RecordButtonData struct {
+	Number   int
+	Datetime string
+	Doctor   string
+}
+
+type RecordData struct {
+	Datetime string
+	Doctor   string
+}
+
+type VisitData struct {
+	Datetime    string
+	Doctor      string
+	Appointment string
+}
+
+type SlotData struct {
+	Slot string
+}
+
+// CountData - число для формы слова из Plural
+type CountData struct {
+	N int
+}
+
+type CalendarData struct {
+	Doctor      string
+	Appointment string
+}
+
+type DaysData struct {
+	Days string
+}
+
+type WaitlistData struct {
+	Doctor   string
+	DateFrom string
+	DateTo   string
+}
+
+type AdminStatsData struct {
+	Users       int
+	Patients    int
+	Booked      int
+	Confirmed   int
+	Cancelled   int
+	Rescheduled int
+}
+
+type AdminTodayItemData struct {
+	Number    int
+	BookedAt  string
+	LastName  string
+	FirstName string
+	Phone     string
+	Datetime  string
+	Doctor    string
+	RecordID  int64
+}
+
+type PhoneData struct {
+	Phone string
+}
+
+type AdminPatientData struct {
+	LastName    string
+	FirstName   string
+	Phone       string
+	DentalProID int64
+	TelegramID  string
+}
+
+// BroadcastData - общие данные всех сообщений о рассылке
+type BroadcastData struct {
+	ID         int64
+	Status     string
+	Recipients int
+	Sent       int
+	Pending    int
+	Blocked    int
+	Failed     int
+}
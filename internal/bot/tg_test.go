@@ -42,8 +42,8 @@ func (m *MockTelegramAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
 	return args.Get(0).(tgbotapi.Message), args.Error(1)
 }
 
-func (m *MockTelegramAPI) GetUpdatesChan(_ tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
-	return m.Updates
+func (m *MockTelegramAPI) GetUpdates(_ tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	return []tgbotapi.Update{<-m.Updates}, nil
 }
 
 func (t *TestNow) Now() time.Time {
@@ -68,11 +68,14 @@ func createBot() (*Router, *MockTelegramAPI, *sql.DB) {
 	testTGBot := &MockTelegramAPI{Updates: make(chan tgbotapi.Update, 1)}
 
 	userTexts := NewUserTexts()
-	dentalProClientTest := crm.NewDentalProClient("", "", true, "../crm")
+	// кэш без TTL только сохраняет врачей в базу, как в боте
+	dentalProClientTest := crm.NewCachedDentalProClient(crm.NewDentalProClient("", "", true, "../crm"), crm.CacheTTL{})
 	telegramBotHandler := NewTelegramBotHandler(
-		testTGBot, *userTexts, dentalProClientTest, testDB, BranchId, LOCATION, &TestNow{},
+		testTGBot, *userTexts, dentalProClientTest, testDB, []Branch{{ID: BranchId}}, LOCATION, &TestNow{},
 	)
-	return NewRouter(testTGBot, telegramBotHandler, true), testTGBot, testDB
+	dentalProClientTest.SetDoctorsHook(telegramBotHandler.SyncDoctors)
+	chatStates := NewMemoryChatStateStore(24 * time.Hour)
+	return NewRouter(testTGBot, telegramBotHandler, chatStates, 4, true), testTGBot, testDB
 }
 
 func createTestMessage(chatID int64, messageID int, text string) *tgbotapi.Message {
@@ -237,13 +240,15 @@ func TestRegisterHandle(t *testing.T) {
 				return tgbotapi.Update{Message: message}
 			},
 			expected: func() []tgbotapi.Chattable {
-				return []tgbotapi.Chattable{tgbotapi.NewMessage(chatID, `Привет! 👋 Добро пожаловать в нашу стоматологическую клинику в "Олимп" Софрино 🦷✨ 
+				return []tgbotapi.Chattable{tgbotapi.NewMessage(chatID, `Привет! 👋 Добро пожаловать в нашу стоматологическую клинику в "Олимп" Софрино 🦷✨
 
 Вот что я могу для вас сделать:
 - 🗓️ /record — Запись на приём к стоматологу
+- 🔄 /move_record — Перенести запись на другое время
 - 🗑️ /delete_record — Удалить запись на приём
 - 📋 /myrecords — Получить информацию о предстоящих визитах
 - ✏️ /change_name — Изменить имя в системе
+- 🌐 /language — Сменить язык
 - ❌ /cancel — Отменить последнее действие и вернуться к началу
 
 Для записи на приём просто отправьте команду /record или выберите нужный пункт в меню.`),
@@ -321,7 +326,7 @@ func TestRegisterHandle(t *testing.T) {
 				text := "Выберите нужный день - Подаева С.Е.\nПовторная консультация + лечение терапевта.\n🟢 Доступные дни"
 				keyboard := tgbotapi.InlineKeyboardMarkup{}
 				keyboard.InlineKeyboard = [][]tgbotapi.InlineKeyboardButton{
-					{tgbotapi.NewInlineKeyboardButtonData("November 2024", "1")},
+					{tgbotapi.NewInlineKeyboardButtonData("Ноябрь 2024", "1")},
 					{
 						tgbotapi.NewInlineKeyboardButtonData("Пн", `Пн`),
 						tgbotapi.NewInlineKeyboardButtonData("Вт", `Вт`),
@@ -543,7 +548,7 @@ func TestRegisterHandle(t *testing.T) {
 				text := "Выберите нужный день - Новикова Н.В.\nПовторная консультация терапевта.\n🟢 Доступные дни"
 				keyboard := tgbotapi.InlineKeyboardMarkup{}
 				keyboard.InlineKeyboard = [][]tgbotapi.InlineKeyboardButton{
-					{tgbotapi.NewInlineKeyboardButtonData("December 2024", "1")},
+					{tgbotapi.NewInlineKeyboardButtonData("Декабрь 2024", "1")},
 					{
 						tgbotapi.NewInlineKeyboardButtonData("Пн", `Пн`),
 						tgbotapi.NewInlineKeyboardButtonData("Вт", `Вт`),
@@ -0,0 +1,548 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/AnVladic/DentalTelegramBot/internal/crm"
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// waitlistWeeksDays - на сколько дней ставится ожидание кнопкой «ближайшие 2 недели»
+	waitlistWeeksDays = 14
+	// waitlistDoctorDays - сколько ждать появления приемов у врача
+	waitlistDoctorDays = 30
+)
+
+type TelegramWaitlistCallback struct {
+	CallbackData
+	Date string `json:"dt,omitempty"`
+	Days int    `json:"n"`
+}
+
+type TelegramWaitlistCancelCallback struct {
+	CallbackData
+	WaitlistID int64 `json:"w"`
+}
+
+// WaitlistScheduler периодически ищет свободное время для листа ожидания
+// и предлагает его ожидающим в порядке очереди
+type WaitlistScheduler struct {
+	tgBotHandler *TelegramBotHandler
+	interval     time.Duration
+	// hold - сколько предложенное время не предлагается следующим в очереди
+	hold time.Duration
+	// cooldown - через сколько снова уведомлять ожидающего, который не записался
+	cooldown time.Duration
+	stopChan chan struct{}
+	wg       *sync.WaitGroup
+}
+
+func NewWaitlistScheduler(
+	tgBotHandler *TelegramBotHandler, interval, hold, cooldown time.Duration,
+) *WaitlistScheduler {
+	return &WaitlistScheduler{
+		tgBotHandler: tgBotHandler,
+		interval:     interval,
+		hold:         hold,
+		cooldown:     cooldown,
+		stopChan:     make(chan struct{}),
+		wg:           new(sync.WaitGroup),
+	}
+}
+
+func (s *WaitlistScheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *WaitlistScheduler) run() {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stopChan
+		cancel()
+	}()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.NotifyWaitlist(ctx)
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			logrus.Println("Stop waitlist")
+			return
+		}
+	}
+}
+
+func (s *WaitlistScheduler) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+}
+
+// waitlistKey - ожидающие одного приема у врача в филиале опрашиваются одним запросом.
+// appointmentID 0 - ожидающие любого приема у врача
+type waitlistKey struct {
+	doctorID      int64
+	appointmentID int64
+	branchID      int64
+}
+
+func (s *WaitlistScheduler) NotifyWaitlist(ctx context.Context) {
+	log := logrus.WithFields(logrus.Fields{
+		"module": "bot.waitlist",
+		"func":   "NotifyWaitlist",
+	})
+	h := s.tgBotHandler
+
+	repository := database.WaitlistRepository{DB: h.db}
+	today := ToDate(h.nowTime.Now().In(h.location))
+	if err := repository.ExpireBefore(ctx, today); err != nil {
+		log.WithError(err).Error("ExpireBefore")
+		return
+	}
+	entries, err := repository.ListWaiting(ctx, h.nowTime.Now().Add(-s.cooldown).UTC())
+	if err != nil {
+		log.WithError(err).Error("ListWaiting")
+		return
+	}
+
+	keys, groups := groupWaitlist(entries, log)
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		if key.appointmentID == 0 {
+			s.notifyDoctorAppointments(ctx, h, key, groups[key], log)
+			continue
+		}
+		s.notifyFreeSlots(ctx, h, key, groups[key], today, log)
+	}
+}
+
+// groupWaitlist объединяет ожидающих одного приема в порядке очереди. Пользователи без пациента
+// в DentalPro пропускаются: без него нельзя запросить доступные приемы и записаться
+func groupWaitlist(
+	entries []database.WaitlistEntry, log *logrus.Entry,
+) ([]waitlistKey, map[waitlistKey][]database.WaitlistEntry) {
+	groups := map[waitlistKey][]database.WaitlistEntry{}
+	var keys []waitlistKey
+	for _, entry := range entries {
+		if entry.DentalProID == nil || *entry.DentalProID <= 0 {
+			log.WithField("waitlist_id", entry.ID).Debug("user has no DentalPro patient, waitlist entry skipped")
+			continue
+		}
+		key := waitlistKey{doctorID: entry.DoctorID, branchID: entry.BranchID}
+		if entry.AppointmentID != nil {
+			key.appointmentID = *entry.AppointmentID
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	return keys, groups
+}
+
+// notifyFreeSlots предлагает каждому ожидающему свое свободное время: первым в очереди - самое раннее
+func (s *WaitlistScheduler) notifyFreeSlots(
+	ctx context.Context,
+	h *TelegramBotHandler, key waitlistKey, entries []database.WaitlistEntry, today time.Time, log *logrus.Entry,
+) {
+	log = log.WithFields(logrus.Fields{
+		"doctor_id":      key.doctorID,
+		"appointment_id": key.appointmentID,
+		"branch_id":      key.branchID,
+	})
+	appointment, err := h.findWaitlistAppointment(ctx, entries[0], key.appointmentID)
+	if err != nil {
+		log.WithError(err).Error("AvailableAppointments")
+		return
+	}
+	if appointment == nil {
+		log.Warn("appointment is not available anymore")
+		return
+	}
+
+	from, to := entries[0].DateFrom, entries[0].DateTo
+	for _, entry := range entries {
+		if entry.DateFrom.Before(from) {
+			from = entry.DateFrom
+		}
+		if entry.DateTo.After(to) {
+			to = entry.DateTo
+		}
+	}
+	if from.Before(today) {
+		from = today
+	}
+	schedule, err := h.dentalProClient.FreeIntervals(ctx,
+		from, to, -1, key.doctorID, key.branchID, appointment.Time)
+	if err != nil {
+		log.WithError(err).Error("FreeIntervals")
+		return
+	}
+	slots := h.waitlistSlots(schedule)
+	if len(slots) == 0 {
+		return
+	}
+
+	repository := database.WaitlistRepository{DB: h.db}
+	held, err := repository.ListHeldSlots(ctx,
+		key.doctorID, key.appointmentID, key.branchID, h.nowTime.Now().Add(-s.hold).UTC())
+	if err != nil {
+		log.WithError(err).Error("ListHeldSlots")
+		return
+	}
+
+	assigned := assignWaitlistSlots(entries, slots, held)
+	for _, entry := range entries {
+		slot, ok := assigned[entry.ID]
+		if !ok {
+			continue
+		}
+		s.sendFreeSlot(ctx, h, entry, appointment, slot, log)
+	}
+}
+
+func (s *WaitlistScheduler) sendFreeSlot(
+	ctx context.Context,
+	h *TelegramBotHandler, entry database.WaitlistEntry, appointment *crm.Appointment, slot time.Time,
+	log *logrus.Entry,
+) {
+	doctorRepo := database.DoctorRepository{DB: h.db}
+	doctor, err := doctorRepo.Get(ctx, entry.DoctorID)
+	if err != nil {
+		log.WithError(err).Errorf("Get doctor %d", entry.DoctorID)
+		return
+	}
+
+	h = h.ForUser(ctx, &tgbotapi.User{ID: entry.TgUserID})
+	data, _ := json.Marshal(TelegramChoiceIntervalCallback{CallbackData{"interval"}, slot.Format("15:04")})
+	msg := tgbotapi.NewMessage(entry.ChatID, h.userTexts.WaitlistSlotFound.Execute(VisitData{
+		Datetime: slot.Format("2006-01-02 15:04"), Doctor: doctor.FIO, Appointment: appointment.Name}))
+	msg.ParseMode = HTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(
+			h.userTexts.WaitlistSlotButton.Execute(SlotData{Slot: slot.Format("02.01 15:04")}), string(data)),
+	))
+	message, err := h.Send(msg, false)
+	if err != nil {
+		return
+	}
+
+	// Кнопка ведет в обычное подтверждение записи, поэтому выбор сохраняется за этим сообщением
+	appointmentID := appointment.ID
+	registerRepo := database.RegisterRepository{DB: h.db}
+	err = registerRepo.Create(ctx, &database.Register{
+		UserID:        entry.UserID,
+		MessageID:     message.MessageID,
+		ChatID:        entry.ChatID,
+		DoctorID:      &entry.DoctorID,
+		AppointmentID: &appointmentID,
+		Datetime:      &slot,
+		BranchID:      &entry.BranchID,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Create register for waitlist %d", entry.ID)
+	}
+	s.markNotified(ctx, h, entry, &slot, log)
+}
+
+// notifyDoctorAppointments сообщает ожидающим, что у врача появились доступные приемы
+func (s *WaitlistScheduler) notifyDoctorAppointments(
+	ctx context.Context,
+	h *TelegramBotHandler, key waitlistKey, entries []database.WaitlistEntry, log *logrus.Entry,
+) {
+	log = log.WithFields(logrus.Fields{
+		"doctor_id": key.doctorID,
+		"branch_id": key.branchID,
+	})
+	appointments, err := h.dentalProClient.AvailableAppointments(
+		ctx, *entries[0].DentalProID, []int64{key.doctorID}, false)
+	if err != nil {
+		log.WithError(err).Error("AvailableAppointments")
+		return
+	}
+	if !hasAppointments(appointments) {
+		return
+	}
+	doctorRepo := database.DoctorRepository{DB: h.db}
+	doctor, err := doctorRepo.Get(ctx, key.doctorID)
+	if err != nil {
+		log.WithError(err).Errorf("Get doctor %d", key.doctorID)
+		return
+	}
+	for _, entry := range entries {
+		s.sendDoctorAppointments(ctx, h, entry, doctor, log)
+	}
+}
+
+func (s *WaitlistScheduler) sendDoctorAppointments(
+	ctx context.Context,
+	h *TelegramBotHandler, entry database.WaitlistEntry, doctor *database.Doctor, log *logrus.Entry,
+) {
+	h = h.ForUser(ctx, &tgbotapi.User{ID: entry.TgUserID})
+	data, _ := json.Marshal(TelegramBotDoctorCallbackData{CallbackData{"select_doctor"}, entry.DoctorID})
+	msg := tgbotapi.NewMessage(entry.ChatID, h.userTexts.WaitlistAppointmentsFound.Execute(DoctorData{Doctor: doctor.FIO}))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.userTexts.WaitlistAppointmentsButton, string(data)),
+	))
+	message, err := h.Send(msg, false)
+	if err != nil {
+		return
+	}
+
+	registerRepo := database.RegisterRepository{DB: h.db}
+	err = registerRepo.Create(ctx, &database.Register{
+		UserID:    entry.UserID,
+		MessageID: message.MessageID,
+		ChatID:    entry.ChatID,
+		DoctorID:  &entry.DoctorID,
+		BranchID:  &entry.BranchID,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Create register for waitlist %d", entry.ID)
+	}
+	s.markNotified(ctx, h, entry, nil, log)
+}
+
+func (s *WaitlistScheduler) markNotified(
+	ctx context.Context, h *TelegramBotHandler, entry database.WaitlistEntry, slot *time.Time, log *logrus.Entry,
+) {
+	repository := database.WaitlistRepository{DB: h.db}
+	if err := repository.MarkNotified(ctx, entry.ID, slot, h.nowTime.Now().UTC()); err != nil {
+		log.WithError(err).Errorf("MarkNotified %d", entry.ID)
+	}
+}
+
+// closeWaitlist завершает ожидания, которые закрыла запись пользователя. Ошибка только логируется:
+// запись в CRM уже создана
+func (h *TelegramBotHandler) closeWaitlist(
+	ctx context.Context, register *database.Register, appointmentID int64, log *logrus.Entry,
+) {
+	if register.DoctorID == nil {
+		return
+	}
+	repository := database.WaitlistRepository{DB: h.db}
+	err := repository.MarkBooked(ctx, register.UserID, *register.DoctorID, appointmentID, h.registerBranchID(register))
+	if err != nil {
+		log.WithError(err).Errorf("close waitlist of user %d", register.UserID)
+	}
+}
+
+func (h *TelegramBotHandler) findWaitlistAppointment(
+	ctx context.Context, entry database.WaitlistEntry, appointmentID int64,
+) (*crm.Appointment, error) {
+	appointments, err := h.dentalProClient.AvailableAppointments(
+		ctx, *entry.DentalProID, []int64{entry.DoctorID}, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, appointmentsList := range appointments {
+		for _, appointment := range appointmentsList {
+			if appointment.ID == appointmentID {
+				return &appointment, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// waitlistSlots возвращает начала свободных интервалов во времени клиники, на которые еще можно записаться
+func (h *TelegramBotHandler) waitlistSlots(schedule []crm.DayInterval) []time.Time {
+	cutoff := h.localTimeCutoff()
+	var slots []time.Time
+	for _, day := range schedule {
+		date := time.Time(day.Date)
+		for _, daySlot := range day.Slots {
+			for _, interval := range daySlot.Time {
+				begin := time.Time(interval.Begin)
+				slot := time.Date(date.Year(), date.Month(), date.Day(),
+					begin.Hour(), begin.Minute(), 0, 0, h.location)
+				if slot.After(cutoff) {
+					slots = append(slots, slot)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// assignWaitlistSlots раздает свободное время ожидающим в порядке очереди. Каждый получает
+// самое раннее время из своего диапазона дат, которое не занято предыдущими и не удерживается
+func assignWaitlistSlots(
+	entries []database.WaitlistEntry, slots []time.Time, held []time.Time,
+) map[int64]time.Time {
+	sorted := append([]time.Time(nil), slots...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+	taken := map[string]bool{}
+	for _, slot := range held {
+		taken[slotKey(slot)] = true
+	}
+
+	assigned := map[int64]time.Time{}
+	for _, entry := range entries {
+		for _, slot := range sorted {
+			if taken[slotKey(slot)] || !dateInRange(slot, entry.DateFrom, entry.DateTo) {
+				continue
+			}
+			assigned[entry.ID] = slot
+			taken[slotKey(slot)] = true
+			break
+		}
+	}
+	return assigned
+}
+
+// slotKey сравнивает время без учета часового пояса: в базе оно хранится без него
+func slotKey(slot time.Time) string {
+	return slot.Format("2006-01-02 15:04")
+}
+
+func dateInRange(slot, from, to time.Time) bool {
+	date := slot.Format("2006-01-02")
+	return date >= from.Format("2006-01-02") && date <= to.Format("2006-01-02")
+}
+
+func hasAppointments(appointments map[int64]map[int64]crm.Appointment) bool {
+	for _, appointmentsList := range appointments {
+		if len(appointmentsList) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// createWaitlistButtons предлагает подождать свободное время в выбранный день или в ближайшие 2 недели
+func (h *TelegramBotHandler) createWaitlistButtons(date string) [][]tgbotapi.InlineKeyboardButton {
+	button := func(text string, days int) tgbotapi.InlineKeyboardButton {
+		data, _ := json.Marshal(TelegramWaitlistCallback{CallbackData{"wait"}, date, days})
+		return tgbotapi.NewInlineKeyboardButtonData(text, string(data))
+	}
+	return [][]tgbotapi.InlineKeyboardButton{
+		{button(h.userTexts.WaitlistDayButton, 1)},
+		{button(h.userTexts.WaitlistWeeksButton.Execute(
+			DaysData{Days: h.userTexts.Count(h.userTexts.Days, waitlistWeeksDays)}), waitlistWeeksDays)},
+	}
+}
+
+func (h *TelegramBotHandler) createWaitlistDoctorButton() tgbotapi.InlineKeyboardButton {
+	data, _ := json.Marshal(TelegramWaitlistCallback{CallbackData: CallbackData{"wait_doc"}, Days: waitlistDoctorDays})
+	return tgbotapi.NewInlineKeyboardButtonData(h.userTexts.WaitlistDoctorButton, string(data))
+}
+
+// JoinWaitlistCallback ставит пользователя в очередь на выбранные в сообщении врача и прием
+func (h *TelegramBotHandler) JoinWaitlistCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.waitlist",
+		"func":   "JoinWaitlistCallback",
+	})
+	var callbackData TelegramWaitlistCallback
+	err := json.Unmarshal([]byte(query.Data), &callbackData)
+	if h.checkAndLogError(err, log, query.Message, "TelegramWaitlistCallback Unmarshal error") {
+		return
+	}
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+	register, err := h.getRegister(ctx, *user, query.Message, log)
+	if err != nil {
+		return
+	}
+	doctor, err := h.getDoctor(ctx, register.DoctorID, query.Message, log)
+	if err != nil {
+		return
+	}
+
+	today := ToDate(h.nowTime.Now().In(h.location))
+	from := today
+	if callbackData.Date != "" {
+		from, err = h.parseDate(callbackData.Date, query.Message, log)
+		if err != nil {
+			return
+		}
+		if from.Before(today) {
+			from = today
+		}
+	}
+	days := max(callbackData.Days, 1)
+	entry := database.WaitlistEntry{
+		UserID:   user.ID,
+		ChatID:   query.Message.Chat.ID,
+		DoctorID: doctor.ID,
+		BranchID: h.registerBranchID(register),
+		DateFrom: from,
+		DateTo:   from.AddDate(0, 0, days-1),
+	}
+	if callbackData.Command == "wait" {
+		entry.AppointmentID = register.AppointmentID
+	}
+
+	repository := database.WaitlistRepository{DB: h.db}
+	err = repository.Join(ctx, &entry)
+	if h.checkAndLogError(err, log, query.Message, "Join waitlist") {
+		return
+	}
+
+	var text string
+	if entry.AppointmentID == nil {
+		text = h.userTexts.WaitlistDoctorJoined.Execute(WaitlistData{
+			Doctor: doctor.FIO, DateTo: entry.DateTo.Format("02.01.2006")})
+	} else {
+		text = h.userTexts.WaitlistJoined.Execute(WaitlistData{
+			Doctor:   doctor.FIO,
+			DateFrom: entry.DateFrom.Format("02.01.2006"),
+			DateTo:   entry.DateTo.Format("02.01.2006"),
+		})
+	}
+	data, _ := json.Marshal(TelegramWaitlistCancelCallback{CallbackData{"wait_x"}, entry.ID})
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.userTexts.WaitlistCancelButton, string(data)),
+	))
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, keyboard)
+	_, _ = h.Edit(edit, true)
+}
+
+func (h *TelegramBotHandler) CancelWaitlistCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module": "bot.waitlist",
+		"func":   "CancelWaitlistCallback",
+	})
+	var callbackData TelegramWaitlistCancelCallback
+	err := json.Unmarshal([]byte(query.Data), &callbackData)
+	if h.checkAndLogError(err, log, query.Message, "TelegramWaitlistCancelCallback Unmarshal error") {
+		return
+	}
+
+	user, err := h.getOrCreateUser(ctx, query.From.ID, query.Message, log)
+	if err != nil {
+		return
+	}
+	repository := database.WaitlistRepository{DB: h.db}
+	cancelled, err := repository.Cancel(ctx, callbackData.WaitlistID, user.ID)
+	if h.checkAndLogError(err, log, query.Message, "Cancel waitlist %d", callbackData.WaitlistID) {
+		return
+	}
+
+	text := h.userTexts.WaitlistCancelled
+	if !cancelled {
+		text = h.userTexts.WaitlistNotFound
+	}
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	_, _ = h.Edit(edit, true)
+}
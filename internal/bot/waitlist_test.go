@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"github.com/AnVladic/DentalTelegramBot/internal/database"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAssignWaitlistSlots(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, 11, d, 0, 0, 0, 0, time.UTC)
+	}
+	slot := func(d, hour int) time.Time {
+		return time.Date(2024, 11, d, hour, 0, 0, 0, LOCATION)
+	}
+	entries := []database.WaitlistEntry{
+		{ID: 1, DateFrom: day(10), DateTo: day(10)},
+		{ID: 2, DateFrom: day(10), DateTo: day(20)},
+		{ID: 3, DateFrom: day(11), DateTo: day(11)},
+		{ID: 4, DateFrom: day(10), DateTo: day(10)},
+	}
+	slots := []time.Time{slot(12, 9), slot(10, 15), slot(10, 10), slot(11, 9)}
+	// 10:00 10 ноября уже предложено раньше
+	held := []time.Time{time.Date(2024, 11, 10, 10, 0, 0, 0, time.UTC)}
+
+	assigned := assignWaitlistSlots(entries, slots, held)
+	require.Equal(t, slot(10, 15), assigned[1])
+	require.Equal(t, slot(11, 9), assigned[2], "first in queue gets the earliest free slot")
+	_, ok := assigned[3]
+	require.False(t, ok, "slot of 11 November is taken by the second entry")
+	_, ok = assigned[4]
+	require.False(t, ok, "all slots of 10 November are taken or held")
+	require.Len(t, assigned, 2)
+}
+
+func TestGroupWaitlist(t *testing.T) {
+	patient, noPatient := int64(7), int64(0)
+	appointmentID := int64(3)
+	entries := []database.WaitlistEntry{
+		{ID: 1, DoctorID: 10, BranchID: 1},
+		{ID: 2, DoctorID: 10, BranchID: 1, DentalProID: &patient},
+		{ID: 3, DoctorID: 10, BranchID: 1, DentalProID: &patient, AppointmentID: &appointmentID},
+		{ID: 4, DoctorID: 10, BranchID: 1, DentalProID: &noPatient},
+		{ID: 5, DoctorID: 10, BranchID: 1, DentalProID: &patient},
+	}
+
+	keys, groups := groupWaitlist(entries, logrus.NewEntry(logrus.New()))
+	doctorKey := waitlistKey{doctorID: 10, branchID: 1}
+	appointmentKey := waitlistKey{doctorID: 10, appointmentID: 3, branchID: 1}
+	require.Equal(t, []waitlistKey{doctorKey, appointmentKey}, keys)
+	require.Equal(t, []database.WaitlistEntry{entries[1], entries[4]}, groups[doctorKey],
+		"entries without DentalPro patient are skipped, queue order is kept")
+	require.Equal(t, []database.WaitlistEntry{entries[2]}, groups[appointmentKey])
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+)
+
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// WebhookHandler принимает обновления от Telegram и передает их в HandleUpdate.
+// Запросы без правильного секретного токена отклоняются
+func (r *Router) WebhookHandler(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		log := logrus.WithFields(logrus.Fields{
+			"module": "bot.webhook",
+			"func":   "WebhookHandler",
+		})
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		token := req.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			log.Warnf("webhook request with invalid secret token from %s", req.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+			log.WithError(err).Error("decode webhook update")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.HandleUpdate(update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// StartWebhook запускает HTTP сервер для webhook. Сервер останавливается в Shutdown
+func (r *Router) StartWebhook(server *http.Server) {
+	r.webhookMu.Lock()
+	r.webhook = server
+	r.webhookMu.Unlock()
+
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	// Готовность выставляется только после того, как порт занят: иначе /readyz врет при ошибке Listen
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logrus.WithError(err).Error("webhook listen")
+		return
+	}
+	logrus.Printf("Listening webhook on %s", listener.Addr())
+	r.listening.Store(true)
+	err = server.Serve(listener)
+	r.listening.Store(false)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.WithError(err).Error("webhook server")
+	}
+}
+
+func (r *Router) shutdownWebhook(ctx context.Context) error {
+	r.webhookMu.Lock()
+	server := r.webhook
+	r.webhookMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerRejectsInvalidRequests(t *testing.T) {
+	router := NewRouter(nil, nil, NewMemoryChatStateStore(time.Hour), 1, false)
+	handler := router.WebhookHandler("secret")
+
+	tests := []struct {
+		name   string
+		method string
+		secret string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "secret", "{}", http.StatusMethodNotAllowed},
+		{"no secret", http.MethodPost, "", "{}", http.StatusUnauthorized},
+		{"wrong secret", http.MethodPost, "other", "{}", http.StatusUnauthorized},
+		{"bad body", http.MethodPost, "secret", "{", http.StatusBadRequest},
+		{"empty update", http.MethodPost, "secret", "{}", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/telegram/webhook", strings.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set(webhookSecretHeader, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestWebhookListening(t *testing.T) {
+	router := NewRouter(nil, nil, NewMemoryChatStateStore(time.Hour), 1, false)
+	require.False(t, router.Listening())
+
+	done := make(chan struct{})
+	go func() {
+		router.StartWebhook(&http.Server{Addr: "127.0.0.1:0", ReadHeaderTimeout: time.Second})
+		close(done)
+	}()
+	require.Eventually(t, router.Listening, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, router.Shutdown(context.Background()))
+	<-done
+	require.False(t, router.Listening())
+}
+
+func TestWebhookNotListeningWhenAddressIsBusy(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	router := NewRouter(nil, nil, NewMemoryChatStateStore(time.Hour), 1, false)
+	router.StartWebhook(&http.Server{Addr: busy.Addr().String(), ReadHeaderTimeout: time.Second})
+	require.False(t, router.Listening())
+}
@@ -0,0 +1,391 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config - настройки приложения. Источники по возрастанию приоритета:
+// значения по умолчанию, YAML файл, .env файл и переменные окружения
+type Config struct {
+	Debug bool `yaml:"debug"`
+	// Test - работать с тестовым клиентом DentalPro вместо настоящего
+	Test        bool   `yaml:"test"`
+	DatabaseURL string `yaml:"database_url"`
+	// AutoMigrate - применять миграции при старте вместо отказа запускаться на старой схеме
+	AutoMigrate    bool   `yaml:"auto_migrate"`
+	Location       string `yaml:"location"`
+	WorkerPoolSize int    `yaml:"worker_pool_size"`
+	BroadcastRate  int    `yaml:"broadcast_rate"`
+	// Language - язык текстов для пользователей, чей язык не найден в каталогах
+	Language string `yaml:"language"`
+	// LocalesDir - каталог с файлами <язык>.yaml, которые переопределяют встроенные тексты
+	LocalesDir string `yaml:"locales_dir,omitempty"`
+	// LocalesReload - как часто проверять изменения файлов LocalesDir
+	LocalesReload time.Duration `yaml:"locales_reload"`
+	Reminders     Reminders     `yaml:"reminders"`
+	Waitlist      Waitlist      `yaml:"waitlist"`
+	ChatState     ChatState     `yaml:"chat_state"`
+	Updates       Updates       `yaml:"updates"`
+	Metrics       Metrics       `yaml:"metrics"`
+	Log           Log           `yaml:"log"`
+	CRM           CRM           `yaml:"crm"`
+	// TenantsConfig - отдельный YAML файл с клиниками, см. LoadTenants
+	TenantsConfig string   `yaml:"tenants_config,omitempty"`
+	Tenants       []Tenant `yaml:"tenants"`
+}
+
+type Reminders struct {
+	Offsets  []time.Duration `yaml:"offsets"`
+	Interval time.Duration   `yaml:"interval"`
+}
+
+type Waitlist struct {
+	Interval time.Duration `yaml:"interval"`
+	// Hold - сколько предложенное время не предлагается следующему в очереди
+	Hold time.Duration `yaml:"hold"`
+	// Cooldown - через сколько снова уведомлять пользователя, который так и не записался
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type ChatState struct {
+	// Store - postgres или memory
+	Store string        `yaml:"store"`
+	TTL   time.Duration `yaml:"ttl"`
+}
+
+type Updates struct {
+	// Mode - polling или webhook
+	Mode          string `yaml:"mode"`
+	WebhookURL    string `yaml:"webhook_url"`
+	WebhookSecret string `yaml:"webhook_secret"`
+	WebhookListen string `yaml:"webhook_listen"`
+	WebhookPath   string `yaml:"webhook_path"`
+}
+
+type Metrics struct {
+	// Listen - адрес HTTP сервера с /metrics, /healthz и /readyz, пустой адрес отключает сервер
+	Listen string `yaml:"listen"`
+}
+
+type Log struct {
+	// Format - json или console
+	Format string `yaml:"format"`
+	// Level - debug, info, warn или error
+	Level string `yaml:"level"`
+	// File - файл лога с ротацией, пустой путь - писать в stderr
+	File string `yaml:"file"`
+}
+
+type CRM struct {
+	RateLimit             float64       `yaml:"rate_limit"`
+	RateBurst             int           `yaml:"rate_burst"`
+	MaxConcurrent         int           `yaml:"max_concurrent"`
+	MaxAttempts           int           `yaml:"max_attempts"`
+	CacheDoctorsTTL       time.Duration `yaml:"cache_doctors_ttl"`
+	CacheAppointmentsTTL  time.Duration `yaml:"cache_appointments_ttl"`
+	CacheFreeIntervalsTTL time.Duration `yaml:"cache_free_intervals_ttl"`
+}
+
+func Default() Config {
+	return Config{
+		Test:           true,
+		Location:       "Europe/Moscow",
+		WorkerPoolSize: 16,
+		BroadcastRate:  25,
+		Language:       "ru",
+		LocalesReload:  10 * time.Second,
+		Reminders: Reminders{
+			Offsets:  []time.Duration{24 * time.Hour, 2 * time.Hour},
+			Interval: 5 * time.Minute,
+		},
+		Waitlist:  Waitlist{Interval: 5 * time.Minute, Hold: 30 * time.Minute, Cooldown: 24 * time.Hour},
+		ChatState: ChatState{Store: "postgres", TTL: 24 * time.Hour},
+		Updates: Updates{
+			Mode:          "polling",
+			WebhookListen: ":8080",
+			WebhookPath:   "/telegram/webhook",
+		},
+		Metrics: Metrics{Listen: ":9090"},
+		Log:     Log{Format: "console", Level: "info", File: "app.log"},
+		CRM: CRM{
+			RateLimit:             5,
+			RateBurst:             5,
+			MaxConcurrent:         4,
+			MaxAttempts:           5,
+			CacheDoctorsTTL:       10 * time.Minute,
+			CacheAppointmentsTTL:  10 * time.Minute,
+			CacheFreeIntervalsTTL: 30 * time.Second,
+		},
+	}
+}
+
+// Load собирает настройки. yamlPath и envPath необязательны; отсутствующий .env файл не ошибка
+func Load(yamlPath, envPath string) (*Config, error) {
+	cfg := Default()
+	if yamlPath != "" {
+		data, err := os.ReadFile(yamlPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", yamlPath, err)
+		}
+	}
+	env := &envReader{}
+	if envPath != "" {
+		dotenv, err := godotenv.Read(envPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", envPath, err)
+		}
+		env.dotenv = dotenv
+	}
+	cfg.readEnv(env)
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveTenants(env); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) readEnv(env *envReader) {
+	env.Bool("DEBUG", &c.Debug)
+	env.Bool("TEST", &c.Test)
+	env.String("DATABASE_URL", &c.DatabaseURL)
+	env.Bool("AUTO_MIGRATE", &c.AutoMigrate)
+	env.String("LOCATION", &c.Location)
+	env.Int("WORKER_POOL_SIZE", &c.WorkerPoolSize)
+	env.Int("BROADCAST_RATE", &c.BroadcastRate)
+	env.String("DEFAULT_LANGUAGE", &c.Language)
+	env.String("LOCALES_DIR", &c.LocalesDir)
+	env.Duration("LOCALES_RELOAD_INTERVAL", &c.LocalesReload)
+	env.Durations("REMINDER_OFFSETS", &c.Reminders.Offsets)
+	env.Duration("REMINDER_INTERVAL", &c.Reminders.Interval)
+	env.Duration("WAITLIST_INTERVAL", &c.Waitlist.Interval)
+	env.Duration("WAITLIST_HOLD", &c.Waitlist.Hold)
+	env.Duration("WAITLIST_COOLDOWN", &c.Waitlist.Cooldown)
+	env.String("CHAT_STATE_STORE", &c.ChatState.Store)
+	env.Duration("CHAT_STATE_TTL", &c.ChatState.TTL)
+	env.String("UPDATES_MODE", &c.Updates.Mode)
+	env.String("WEBHOOK_URL", &c.Updates.WebhookURL)
+	env.String("WEBHOOK_SECRET", &c.Updates.WebhookSecret)
+	env.String("WEBHOOK_LISTEN", &c.Updates.WebhookListen)
+	env.String("WEBHOOK_PATH", &c.Updates.WebhookPath)
+	env.String("METRICS_LISTEN", &c.Metrics.Listen)
+	env.String("LOG_FORMAT", &c.Log.Format)
+	env.String("LOG_LEVEL", &c.Log.Level)
+	env.String("LOG_FILE", &c.Log.File)
+	env.Float("CRM_RATE_LIMIT", &c.CRM.RateLimit)
+	env.Int("CRM_RATE_BURST", &c.CRM.RateBurst)
+	env.Int("CRM_MAX_CONCURRENT", &c.CRM.MaxConcurrent)
+	env.Int("CRM_MAX_ATTEMPTS", &c.CRM.MaxAttempts)
+	env.Duration("CRM_CACHE_DOCTORS_TTL", &c.CRM.CacheDoctorsTTL)
+	env.Duration("CRM_CACHE_APPOINTMENTS_TTL", &c.CRM.CacheAppointmentsTTL)
+	env.Duration("CRM_CACHE_FREE_INTERVALS_TTL", &c.CRM.CacheFreeIntervalsTTL)
+	env.String("TENANTS_CONFIG", &c.TenantsConfig)
+}
+
+// resolveTenants заполняет клиники: из TENANTS_CONFIG, из YAML или одну клинику из переменных окружения
+func (c *Config) resolveTenants(env *envReader) error {
+	if c.TenantsConfig != "" {
+		tenants, err := LoadTenants(c.TenantsConfig, c.DatabaseURL, c.Location)
+		if err != nil {
+			return err
+		}
+		c.Tenants = tenants
+		return nil
+	}
+	if len(c.Tenants) > 0 {
+		for i := range c.Tenants {
+			if c.Tenants[i].DatabaseURL == "" {
+				c.Tenants[i].DatabaseURL = c.DatabaseURL
+			}
+			if c.Tenants[i].Location == "" {
+				c.Tenants[i].Location = c.Location
+			}
+		}
+		return nil
+	}
+
+	tenant := Tenant{Name: "default", DatabaseURL: c.DatabaseURL, Location: c.Location}
+	env.String("TELEGRAM_BOT_TOKEN", &tenant.TelegramBotToken)
+	env.String("DENTAL_PRO_URL", &tenant.DentalPro.URL)
+	env.String("DENTAL_PRO_TOKEN", &tenant.DentalPro.Token)
+	env.String("DENTAL_PRO_SECRET", &tenant.DentalPro.Secret)
+	env.IDs("ADMIN_IDS", &tenant.AdminIDs)
+	if value, ok := env.lookup("BRANCHES"); ok {
+		branches, err := ParseBranches(value)
+		if err != nil {
+			env.errs = append(env.errs, fmt.Errorf("BRANCHES: %w", err))
+		}
+		tenant.Branches = branches
+	} else if _, ok := env.lookup("BRANCH_ID"); ok {
+		var branchID int64
+		env.Int64("BRANCH_ID", &branchID)
+		tenant.Branches = []Branch{{ID: branchID}}
+	}
+	if err := errors.Join(env.errs...); err != nil {
+		return err
+	}
+	if len(tenant.Branches) == 0 {
+		return errors.New("BRANCH_ID or BRANCHES is required")
+	}
+	if tenant.TelegramBotToken == "" {
+		return errors.New("TELEGRAM_BOT_TOKEN is required")
+	}
+	c.Tenants = []Tenant{tenant}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.WorkerPoolSize <= 0 {
+		errs = append(errs, fmt.Errorf("WORKER_POOL_SIZE must be positive, got %d", c.WorkerPoolSize))
+	}
+	if c.BroadcastRate <= 0 {
+		errs = append(errs, fmt.Errorf("BROADCAST_RATE must be positive, got %d", c.BroadcastRate))
+	}
+	if c.Language == "" {
+		errs = append(errs, errors.New("DEFAULT_LANGUAGE must not be empty"))
+	}
+	if len(c.Reminders.Offsets) == 0 {
+		errs = append(errs, errors.New("REMINDER_OFFSETS must not be empty"))
+	}
+	for _, offset := range c.Reminders.Offsets {
+		if offset <= 0 {
+			errs = append(errs, fmt.Errorf("REMINDER_OFFSETS must be positive, got %s", offset))
+		}
+	}
+	positive := map[string]time.Duration{
+		"LOCALES_RELOAD_INTERVAL":      c.LocalesReload,
+		"REMINDER_INTERVAL":            c.Reminders.Interval,
+		"WAITLIST_INTERVAL":            c.Waitlist.Interval,
+		"WAITLIST_HOLD":                c.Waitlist.Hold,
+		"WAITLIST_COOLDOWN":            c.Waitlist.Cooldown,
+		"CHAT_STATE_TTL":               c.ChatState.TTL,
+		"CRM_CACHE_DOCTORS_TTL":        c.CRM.CacheDoctorsTTL,
+		"CRM_CACHE_APPOINTMENTS_TTL":   c.CRM.CacheAppointmentsTTL,
+		"CRM_CACHE_FREE_INTERVALS_TTL": c.CRM.CacheFreeIntervalsTTL,
+	}
+	for _, key := range sortedKeys(positive) {
+		if positive[key] <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", key, positive[key]))
+		}
+	}
+	if c.CRM.RateLimit <= 0 || c.CRM.RateBurst <= 0 || c.CRM.MaxConcurrent <= 0 || c.CRM.MaxAttempts <= 0 {
+		errs = append(errs, errors.New(
+			"CRM_RATE_LIMIT, CRM_RATE_BURST, CRM_MAX_CONCURRENT and CRM_MAX_ATTEMPTS must be positive"))
+	}
+	switch c.ChatState.Store {
+	case "postgres", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("CHAT_STATE_STORE must be postgres or memory, got %q", c.ChatState.Store))
+	}
+	switch c.Log.Format {
+	case "json", "console":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json or console, got %q", c.Log.Format))
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+	switch c.Updates.Mode {
+	case "polling":
+	case "webhook":
+		if c.Updates.WebhookURL == "" {
+			errs = append(errs, errors.New("WEBHOOK_URL is required in webhook mode"))
+		}
+		if c.Updates.WebhookSecret == "" {
+			errs = append(errs, errors.New("WEBHOOK_SECRET is required in webhook mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("UPDATES_MODE must be polling or webhook, got %q", c.Updates.Mode))
+	}
+	if err := ValidateTenants(c.Tenants); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted возвращает копию настроек без токенов, секретов и паролей, например для --check-config
+func (c Config) Redacted() Config {
+	c.DatabaseURL = redactURL(c.DatabaseURL)
+	c.Updates.WebhookSecret = redact(c.Updates.WebhookSecret)
+	tenants := make([]Tenant, len(c.Tenants))
+	for i, tenant := range c.Tenants {
+		tenant.TelegramBotToken = redact(tenant.TelegramBotToken)
+		tenant.DatabaseURL = redactURL(tenant.DatabaseURL)
+		tenant.DentalPro.Token = redact(tenant.DentalPro.Token)
+		tenant.DentalPro.Secret = redact(tenant.DentalPro.Secret)
+		tenants[i] = tenant
+	}
+	c.Tenants = tenants
+	return c
+}
+
+// String выводит настройки в YAML без секретов
+func (c Config) String() string {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "***"
+}
+
+func redactURL(value string) string {
+	// DSN вида "host=... password=..." не URL, его скрываем целиком
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" {
+		return redact(value)
+	}
+	return parsed.Redacted()
+}
+
+// ParseBranches разбирает список филиалов вида "3|Олимп Софрино|ул. Тютчева, 1;5|Олимп Пушкино|..."
+func ParseBranches(value string) ([]Branch, error) {
+	var branches []Branch
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "|", 3)
+		id, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch id in %q: %w", item, err)
+		}
+		branch := Branch{ID: id}
+		if len(parts) > 1 {
+			branch.Name = strings.TrimSpace(parts[1])
+		}
+		if len(parts) > 2 {
+			branch.Address = strings.TrimSpace(parts[2])
+		}
+		branches = append(branches, branch)
+	}
+	if len(branches) == 0 {
+		return nil, fmt.Errorf("branches list is empty")
+	}
+	return branches, nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader читает переменные окружения и .env файла в поля Config. Окружение важнее .env.
+// Незаданные переменные не меняют поле, ошибки разбора накапливаются в errs, чтобы показать их все сразу
+type envReader struct {
+	dotenv map[string]string
+	errs   []error
+}
+
+func (r *envReader) lookup(key string) (string, bool) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		value, ok = r.dotenv[key]
+	}
+	value = strings.TrimSpace(value)
+	return value, ok && value != ""
+}
+
+func (r *envReader) fail(key, kind, value string) {
+	r.errs = append(r.errs, fmt.Errorf("%s: invalid %s %q", key, kind, value))
+}
+
+func (r *envReader) String(key string, dst *string) {
+	if value, ok := r.lookup(key); ok {
+		*dst = value
+	}
+}
+
+func (r *envReader) Bool(key string, dst *bool) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.fail(key, "boolean", value)
+		return
+	}
+	*dst = parsed
+}
+
+func (r *envReader) Int(key string, dst *int) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail(key, "integer", value)
+		return
+	}
+	*dst = parsed
+}
+
+func (r *envReader) Int64(key string, dst *int64) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		r.fail(key, "integer", value)
+		return
+	}
+	*dst = parsed
+}
+
+func (r *envReader) Float(key string, dst *float64) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail(key, "number", value)
+		return
+	}
+	*dst = parsed
+}
+
+func (r *envReader) Duration(key string, dst *time.Duration) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		r.fail(key, "duration", value)
+		return
+	}
+	*dst = parsed
+}
+
+// Durations читает список длительностей через запятую, например "24h,2h"
+func (r *envReader) Durations(key string, dst *[]time.Duration) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	var durations []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			r.fail(key, "duration", part)
+			return
+		}
+		durations = append(durations, duration)
+	}
+	*dst = durations
+}
+
+// IDs читает список Telegram ID через запятую
+func (r *envReader) IDs(key string, dst *[]int64) {
+	value, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			r.fail(key, "id", part)
+			return
+		}
+		ids = append(ids, id)
+	}
+	*dst = ids
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// Tenant - настройки одной клиники: свой бот, своя база и свой DentalPro
+type Tenant struct {
+	Name             string    `yaml:"name"`
+	TelegramBotToken string    `yaml:"telegram_bot_token"`
+	DatabaseURL      string    `yaml:"database_url"`
+	DentalPro        DentalPro `yaml:"dental_pro"`
+	Branches         []Branch  `yaml:"branches"`
+	Location         string    `yaml:"location"`
+	AdminIDs         []int64   `yaml:"admin_ids"`
+}
+
+type DentalPro struct {
+	URL    string `yaml:"url"`
+	Token  string `yaml:"token"`
+	Secret string `yaml:"secret"`
+}
+
+type Branch struct {
+	ID      int64  `yaml:"id"`
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+}
+
+type tenantsFile struct {
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// LoadTenants читает клиники из YAML файла. Переменные окружения вида ${NAME}
+// в файле подставляются, поэтому токены можно не хранить в нем открытым текстом.
+// Пустые database_url и location заменяются на defaultDatabaseURL и defaultLocation
+func LoadTenants(path, defaultDatabaseURL, defaultLocation string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file tenantsFile
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range file.Tenants {
+		if file.Tenants[i].DatabaseURL == "" {
+			file.Tenants[i].DatabaseURL = defaultDatabaseURL
+		}
+		if file.Tenants[i].Location == "" {
+			file.Tenants[i].Location = defaultLocation
+		}
+	}
+	if err := ValidateTenants(file.Tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Tenants, nil
+}
+
+// ValidateTenants проверяет настройки клиник. У разных клиник должны быть разные боты и базы:
+// пользователи и записи в базе не разделены по клиникам
+func ValidateTenants(tenants []Tenant) error {
+	if len(tenants) == 0 {
+		return errors.New("no tenants configured")
+	}
+	names := map[string]bool{}
+	tokens := map[string]string{}
+	databases := map[string]string{}
+	for i, tenant := range tenants {
+		if tenant.Name == "" {
+			return fmt.Errorf("tenant #%d: name is required", i+1)
+		}
+		if names[tenant.Name] {
+			return fmt.Errorf("tenant %q: duplicate name", tenant.Name)
+		}
+		names[tenant.Name] = true
+		if err := tenant.Validate(); err != nil {
+			return err
+		}
+		if other, ok := tokens[tenant.TelegramBotToken]; ok {
+			return fmt.Errorf("tenant %q: telegram_bot_token is already used by %q", tenant.Name, other)
+		}
+		tokens[tenant.TelegramBotToken] = tenant.Name
+		if other, ok := databases[tenant.DatabaseURL]; ok {
+			return fmt.Errorf("tenant %q: database_url is already used by %q", tenant.Name, other)
+		}
+		databases[tenant.DatabaseURL] = tenant.Name
+	}
+	return nil
+}
+
+func (t Tenant) Validate() error {
+	if t.TelegramBotToken == "" {
+		return fmt.Errorf("tenant %q: telegram_bot_token is required", t.Name)
+	}
+	if t.DatabaseURL == "" {
+		return fmt.Errorf("tenant %q: database_url is required", t.Name)
+	}
+	if len(t.Branches) == 0 {
+		return fmt.Errorf("tenant %q: at least one branch is required", t.Name)
+	}
+	for _, branch := range t.Branches {
+		if branch.ID <= 0 {
+			return fmt.Errorf("tenant %q: branch id must be positive, got %d", t.Name, branch.ID)
+		}
+	}
+	if _, err := time.LoadLocation(t.Location); err != nil {
+		return fmt.Errorf("tenant %q: location: %w", t.Name, err)
+	}
+	return nil
+}
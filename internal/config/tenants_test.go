@@ -0,0 +1,87 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTenants(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tenants.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadTenants(t *testing.T) {
+	t.Setenv("OLIMP_BOT_TOKEN", "olimp-token")
+	path := writeTenants(t, `
+tenants:
+  - name: olimp
+    telegram_bot_token: ${OLIMP_BOT_TOKEN}
+    dental_pro:
+      url: https://olimp.crm3.dental-pro.online/
+      token: dp-token
+      secret: dp-secret
+    branches:
+      - id: 3
+        name: Софрино
+        address: ул. Тестовая, 1
+    admin_ids: [100, 200]
+  - name: smile
+    telegram_bot_token: smile-token
+    database_url: postgres://localhost/smile
+    location: Asia/Yekaterinburg
+    branches:
+      - id: 1
+`)
+	tenants, err := LoadTenants(path, "postgres://localhost/olimp", "Europe/Moscow")
+	require.NoError(t, err)
+	require.Len(t, tenants, 2)
+
+	require.Equal(t, "olimp-token", tenants[0].TelegramBotToken)
+	require.Equal(t, "postgres://localhost/olimp", tenants[0].DatabaseURL)
+	require.Equal(t, "Europe/Moscow", tenants[0].Location)
+	require.Equal(t, []Branch{{ID: 3, Name: "Софрино", Address: "ул. Тестовая, 1"}}, tenants[0].Branches)
+	require.Equal(t, []int64{100, 200}, tenants[0].AdminIDs)
+	require.Equal(t, "dp-secret", tenants[0].DentalPro.Secret)
+
+	require.Equal(t, "postgres://localhost/smile", tenants[1].DatabaseURL)
+	require.Equal(t, "Asia/Yekaterinburg", tenants[1].Location)
+}
+
+func TestLoadTenantsValidation(t *testing.T) {
+	cases := map[string]string{
+		"no tenants configured": `tenants: []`,
+		"name is required": `
+tenants:
+  - telegram_bot_token: a
+    branches: [{id: 1}]`,
+		"telegram_bot_token is required": `
+tenants:
+  - name: a
+    branches: [{id: 1}]`,
+		"at least one branch": `
+tenants:
+  - name: a
+    telegram_bot_token: a`,
+		"database_url is already used": `
+tenants:
+  - name: a
+    telegram_bot_token: a
+    branches: [{id: 1}]
+  - name: b
+    telegram_bot_token: b
+    branches: [{id: 1}]`,
+		"unknown time zone": `
+tenants:
+  - name: a
+    telegram_bot_token: a
+    location: Mars/Olympus
+    branches: [{id: 1}]`,
+	}
+	for message, content := range cases {
+		_, err := LoadTenants(writeTenants(t, content), "postgres://localhost/db", "Europe/Moscow")
+		require.ErrorContains(t, err, message)
+	}
+}
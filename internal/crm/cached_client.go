@@ -0,0 +1,232 @@
+package crm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheTTL - время жизни закэшированных ответов DentalPro. Нулевое значение отключает кэш метода
+type CacheTTL struct {
+	Doctors       time.Duration
+	Appointments  time.Duration
+	FreeIntervals time.Duration
+}
+
+const (
+	cacheDoctors       = "doctors"
+	cacheAppointments  = "appointments"
+	cacheFreeIntervals = "free_intervals"
+)
+
+type cacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+// cacheCall - запрос к CRM, который уже выполняется. Остальные вызовы с тем же ключом ждут его результат
+type cacheCall struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+const (
+	// cacheFetchTimeout ограничивает общий запрос: он не отменяется вместе с контекстом одного из ожидающих
+	cacheFetchTimeout = time.Minute
+	// cacheSweepInterval - как часто из кэша удаляются просроченные ответы, которые больше не запрашивали
+	cacheSweepInterval = 5 * time.Minute
+)
+
+// CachedDentalProClient кэширует справочники DentalPro и объединяет одновременные одинаковые запросы.
+// Возвращаемые значения общие для всех вызывающих, их нельзя изменять
+type CachedDentalProClient struct {
+	IDentalProClient
+	ttl CacheTTL
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	calls   map[string]*cacheCall
+	// generations - номер сброса для каждого вида кэша: ответы запросов, начатых до сброса, не кэшируются
+	generations map[string]uint64
+	nextSweep   time.Time
+	// doctorsHook получает список врачей после каждого запроса к DentalPro, см. SetDoctorsHook
+	doctorsHook func(ctx context.Context, doctors []Doctor) error
+}
+
+func NewCachedDentalProClient(client IDentalProClient, ttl CacheTTL) *CachedDentalProClient {
+	return &CachedDentalProClient{
+		IDentalProClient: client,
+		ttl:              ttl,
+		now:              time.Now,
+		entries:          map[string]cacheEntry{},
+		calls:            map[string]*cacheCall{},
+		generations:      map[string]uint64{},
+	}
+}
+
+// SetDoctorsHook задает функцию, которая получает список врачей только при его запросе к DentalPro,
+// а не при каждом чтении из кэша. Если она вернула ошибку, список не кэшируется
+func (c *CachedDentalProClient) SetDoctorsHook(hook func(ctx context.Context, doctors []Doctor) error) {
+	c.doctorsHook = hook
+}
+
+// Uncached возвращает client без кэша, например для проверки времени прямо перед записью
+func Uncached(client IDentalProClient) IDentalProClient {
+	if cached, ok := client.(*CachedDentalProClient); ok {
+		return cached.IDentalProClient
+	}
+	return client
+}
+
+func (c *CachedDentalProClient) DoctorsList(ctx context.Context) ([]Doctor, error) {
+	value, err := c.do(ctx, cacheDoctors, cacheDoctors, c.ttl.Doctors, func(ctx context.Context) (any, error) {
+		doctors, err := c.IDentalProClient.DoctorsList(ctx)
+		if err != nil || c.doctorsHook == nil {
+			return doctors, err
+		}
+		if err := c.doctorsHook(ctx, doctors); err != nil {
+			return nil, err
+		}
+		return doctors, nil
+	})
+	doctors, _ := value.([]Doctor)
+	return doctors, err
+}
+
+func (c *CachedDentalProClient) AvailableAppointments(ctx context.Context,
+	userID int64, doctorIDs []int64, isPlanned bool) (map[int64]map[int64]Appointment, error) {
+	key := fmt.Sprintf("%s:%d:%v:%t", cacheAppointments, userID, doctorIDs, isPlanned)
+	value, err := c.do(ctx, cacheAppointments, key, c.ttl.Appointments, func(ctx context.Context) (any, error) {
+		return c.IDentalProClient.AvailableAppointments(ctx, userID, doctorIDs, isPlanned)
+	})
+	appointments, _ := value.(map[int64]map[int64]Appointment)
+	return appointments, err
+}
+
+func (c *CachedDentalProClient) FreeIntervals(ctx context.Context,
+	startDate, endDate time.Time,
+	departmentID, doctorID, branchID int64, duration int,
+) ([]DayInterval, error) {
+	key := fmt.Sprintf("%s:%s:%s:%d:%d:%d:%d", cacheFreeIntervals,
+		startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), departmentID, doctorID, branchID, duration)
+	value, err := c.do(ctx, cacheFreeIntervals, key, c.ttl.FreeIntervals, func(ctx context.Context) (any, error) {
+		return c.IDentalProClient.FreeIntervals(ctx, startDate, endDate, departmentID, doctorID, branchID, duration)
+	})
+	intervals, _ := value.([]DayInterval)
+	return intervals, err
+}
+
+// RecordCreate и DeleteRecord меняют свободные интервалы врача, поэтому сбрасывают их кэш
+func (c *CachedDentalProClient) RecordCreate(ctx context.Context,
+	date, timeStart, timeEnd time.Time, doctorID, clientID, appointmentID int64, isPlanned bool,
+) (*Record, error) {
+	defer c.InvalidateFreeIntervals()
+	return c.IDentalProClient.RecordCreate(ctx,
+		date, timeStart, timeEnd, doctorID, clientID, appointmentID, isPlanned)
+}
+
+func (c *CachedDentalProClient) DeleteRecord(ctx context.Context, recordID int64) (ChangeRecord, error) {
+	defer c.InvalidateFreeIntervals()
+	return c.IDentalProClient.DeleteRecord(ctx, recordID)
+}
+
+func (c *CachedDentalProClient) InvalidateFreeIntervals() {
+	c.invalidate(cacheFreeIntervals)
+}
+
+// InvalidateAll сбрасывает весь кэш. Вызывается админской командой /refresh
+func (c *CachedDentalProClient) InvalidateAll() {
+	c.invalidate(cacheDoctors)
+	c.invalidate(cacheAppointments)
+	c.invalidate(cacheFreeIntervals)
+}
+
+func (c *CachedDentalProClient) invalidate(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	// Запросы, начатые до сброса, дорабатывают для своих ожидающих, а новые вызовы начинают свой запрос
+	for key := range c.calls {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.calls, key)
+		}
+	}
+	c.generations[prefix]++
+}
+
+// sweep удаляет просроченные ответы: ключи зависят от врача, филиала и дат, и многие больше не запрашиваются.
+// Вызывается под c.mu
+func (c *CachedDentalProClient) sweep(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(cacheSweepInterval)
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// do возвращает ответ из кэша или выполняет fetch. Одновременные вызовы с одним ключом ждут один запрос,
+// который выполняется в своем контексте: отмена ctx прерывает ожидание только этого вызова
+func (c *CachedDentalProClient) do(
+	ctx context.Context, prefix, key string, ttl time.Duration, fetch func(ctx context.Context) (any, error),
+) (any, error) {
+	c.mu.Lock()
+	now := c.now()
+	c.sweep(now)
+	if entry, ok := c.entries[key]; ok {
+		if now.Before(entry.expiresAt) {
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+		delete(c.entries, key)
+	}
+	call, ok := c.calls[key]
+	if !ok {
+		call = &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		go c.fetch(ctx, prefix, key, ttl, c.generations[prefix], call, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *CachedDentalProClient) fetch(
+	ctx context.Context, prefix, key string, ttl time.Duration, generation uint64,
+	call *cacheCall, fetch func(ctx context.Context) (any, error),
+) {
+	// Поля логгера и другие значения ctx сохраняются, а отмена первого вызывающего - нет
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheFetchTimeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			call.value, call.err = nil, fmt.Errorf("%s: panic: %v", key, r)
+		}
+		c.mu.Lock()
+		if call.err == nil && ttl > 0 && generation == c.generations[prefix] {
+			c.entries[key] = cacheEntry{value: call.value, expiresAt: c.now().Add(ttl)}
+		}
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fetch(ctx)
+}
@@ -0,0 +1,255 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingClient struct {
+	IDentalProClient
+	doctorsCalls  atomic.Int32
+	intervalCalls atomic.Int32
+	release       chan struct{}
+	err           error
+	panics        bool
+}
+
+func (c *countingClient) DoctorsList(ctx context.Context) ([]Doctor, error) {
+	c.doctorsCalls.Add(1)
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if c.panics {
+		panic("unexpected response")
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []Doctor{{ID: 1, FIO: "Иванов Иван Иванович"}}, nil
+}
+
+func (c *countingClient) FreeIntervals(ctx context.Context,
+	startDate, endDate time.Time, departmentID, doctorID, branchID int64, duration int,
+) ([]DayInterval, error) {
+	c.intervalCalls.Add(1)
+	return []DayInterval{}, nil
+}
+
+func (c *countingClient) DeleteRecord(ctx context.Context, recordID int64) (ChangeRecord, error) {
+	return ChangeRecord{ID: recordID, Status: true}, nil
+}
+
+func TestCachedClientTTL(t *testing.T) {
+	now := time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC)
+	inner := &countingClient{}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+	client.now = func() time.Time { return now }
+
+	for range 3 {
+		doctors, err := client.DoctorsList(context.Background())
+		require.NoError(t, err)
+		require.Len(t, doctors, 1)
+	}
+	require.Equal(t, int32(1), inner.doctorsCalls.Load())
+
+	now = now.Add(2 * time.Minute)
+	_, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), inner.doctorsCalls.Load())
+
+	client.InvalidateAll()
+	_, err = client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(3), inner.doctorsCalls.Load())
+}
+
+func TestCachedClientDoesNotCacheErrors(t *testing.T) {
+	inner := &countingClient{err: errors.New("crm is down")}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	_, err := client.DoctorsList(context.Background())
+	require.Error(t, err)
+	_, err = client.DoctorsList(context.Background())
+	require.Error(t, err)
+	require.Equal(t, int32(2), inner.doctorsCalls.Load())
+}
+
+func TestCachedClientDeduplicatesConcurrentCalls(t *testing.T) {
+	inner := &countingClient{release: make(chan struct{})}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doctors, err := client.DoctorsList(context.Background())
+			require.NoError(t, err)
+			require.Len(t, doctors, 1)
+		}()
+	}
+	require.Eventually(t, func() bool { return inner.doctorsCalls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	require.Equal(t, int32(1), inner.doctorsCalls.Load())
+}
+
+func TestCachedClientInvalidatesFreeIntervalsOnChanges(t *testing.T) {
+	inner := &countingClient{}
+	client := NewCachedDentalProClient(inner, CacheTTL{FreeIntervals: time.Minute})
+	day := time.Date(2024, 11, 9, 0, 0, 0, 0, time.UTC)
+
+	_, _ = client.FreeIntervals(context.Background(), day, day, -1, 2, 3, 30)
+	_, _ = client.FreeIntervals(context.Background(), day, day, -1, 2, 3, 30)
+	require.Equal(t, int32(1), inner.intervalCalls.Load())
+
+	_, err := client.DeleteRecord(context.Background(), 10)
+	require.NoError(t, err)
+	_, _ = client.FreeIntervals(context.Background(), day, day, -1, 2, 3, 30)
+	require.Equal(t, int32(2), inner.intervalCalls.Load())
+}
+
+func TestCachedClientCanceledCallerDoesNotCancelWaiters(t *testing.T) {
+	inner := &countingClient{release: make(chan struct{})}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, err := client.DoctorsList(ctx)
+		firstErr <- err
+	}()
+	require.Eventually(t, func() bool { return inner.doctorsCalls.Load() == 1 }, time.Second, time.Millisecond)
+	secondErr := make(chan error)
+	go func() {
+		_, err := client.DoctorsList(context.Background())
+		secondErr <- err
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-firstErr, context.Canceled)
+	close(inner.release)
+	require.NoError(t, <-secondErr)
+	require.Equal(t, int32(1), inner.doctorsCalls.Load())
+}
+
+func TestCachedClientInvalidationKeepsOtherFetches(t *testing.T) {
+	inner := &countingClient{release: make(chan struct{})}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := client.DoctorsList(context.Background())
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return inner.doctorsCalls.Load() == 1 }, time.Second, time.Millisecond)
+	client.InvalidateFreeIntervals()
+	close(inner.release)
+	<-done
+
+	_, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), inner.doctorsCalls.Load(), "doctors are cached despite free intervals invalidation")
+}
+
+func TestCachedClientRecoversPanic(t *testing.T) {
+	inner := &countingClient{panics: true}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	_, err := client.DoctorsList(context.Background())
+	require.ErrorContains(t, err, "panic: unexpected response")
+	inner.panics = false
+	_, err = client.DoctorsList(context.Background())
+	require.NoError(t, err)
+}
+
+func TestCachedClientInvalidationStartsNewFetch(t *testing.T) {
+	inner := &countingClient{release: make(chan struct{})}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := client.DoctorsList(context.Background())
+		errs <- err
+	}()
+	require.Eventually(t, func() bool { return inner.doctorsCalls.Load() == 1 }, time.Second, time.Millisecond)
+	client.InvalidateAll()
+	go func() {
+		_, err := client.DoctorsList(context.Background())
+		errs <- err
+	}()
+	require.Eventually(t, func() bool { return inner.doctorsCalls.Load() == 2 }, time.Second, time.Millisecond,
+		"caller after invalidation does not join the fetch started before it")
+
+	close(inner.release)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	_, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), inner.doctorsCalls.Load(), "fetch started after invalidation is cached")
+}
+
+func TestCachedClientSweepsExpiredEntries(t *testing.T) {
+	now := time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC)
+	inner := &countingClient{}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Hour, FreeIntervals: time.Minute})
+	client.now = func() time.Time { return now }
+	day := time.Date(2024, 11, 9, 0, 0, 0, 0, time.UTC)
+
+	for doctorID := range int64(10) {
+		_, err := client.FreeIntervals(context.Background(), day, day, -1, doctorID, 3, 30)
+		require.NoError(t, err)
+	}
+	_, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+
+	now = now.Add(cacheSweepInterval)
+	_, err = client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	require.Len(t, client.entries, 1, "only the doctors list is still fresh")
+}
+
+func TestCachedClientDoctorsHook(t *testing.T) {
+	inner := &countingClient{}
+	client := NewCachedDentalProClient(inner, CacheTTL{Doctors: time.Minute})
+	var synced atomic.Int32
+	hookErr := errors.New("db is down")
+	client.SetDoctorsHook(func(ctx context.Context, doctors []Doctor) error {
+		synced.Add(1)
+		require.Len(t, doctors, 1)
+		return hookErr
+	})
+
+	_, err := client.DoctorsList(context.Background())
+	require.ErrorIs(t, err, hookErr)
+	hookErr = nil
+	for range 3 {
+		_, err = client.DoctorsList(context.Background())
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(2), synced.Load(), "hook runs only when the list is fetched from DentalPro")
+}
+
+func TestUncached(t *testing.T) {
+	inner := &countingClient{}
+	client := NewCachedDentalProClient(inner, CacheTTL{FreeIntervals: time.Minute})
+	day := time.Date(2024, 11, 9, 0, 0, 0, 0, time.UTC)
+
+	_, _ = client.FreeIntervals(context.Background(), day, day, -1, 2, 3, 30)
+	_, _ = Uncached(client).FreeIntervals(context.Background(), day, day, -1, 2, 3, 30)
+	require.Equal(t, int32(2), inner.intervalCalls.Load())
+	require.Same(t, inner, Uncached(inner))
+}
@@ -1,52 +1,67 @@
 package crm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
+	"io/fs"
 	"os"
 	"testing"
 	"time"
 )
 
+// client ходит в настоящий DentalPro. Без DENTAL_PRO_TOKEN он nil и тесты с ним пропускаются
 var client IDentalProClient
 
 func LoadEnv() {
 	err := godotenv.Load("../../configs/.env")
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(fmt.Errorf("error loading .env file: %w", err))
 	}
 }
 
 func TestMain(m *testing.M) {
 	LoadEnv()
-	client = NewDentalProClient(
-		os.Getenv("DENTAL_PRO_TOKEN"), os.Getenv("DENTAL_PRO_SECRET"), false, "")
+	if token := os.Getenv("DENTAL_PRO_TOKEN"); token != "" {
+		client = NewDentalProClient(token, os.Getenv("DENTAL_PRO_SECRET"), false, "")
+	}
 
 	exitCode := m.Run()
 	os.Exit(exitCode)
 }
 
+func skipWithoutDentalPro(t *testing.T) {
+	if client == nil {
+		t.Skip("DENTAL_PRO_TOKEN is not set")
+	}
+}
+
 func TestDoctorsList(t *testing.T) {
-	doctors, err := client.DoctorsList()
+	skipWithoutDentalPro(t)
+	doctors, err := client.DoctorsList(context.Background())
 	require.NoError(t, err, "should not return an error")
 	require.Greater(t, len(doctors), 0, "should return at least one doctor")
 }
 
 func TestAvailableAppointments(t *testing.T) {
-	appointments, err := client.AvailableAppointments(-1, []int64{2}, false)
+	skipWithoutDentalPro(t)
+	appointments, err := client.AvailableAppointments(context.Background(), -1, []int64{2}, false)
 	require.NoError(t, err, "should not return an error")
 	require.Greater(t, len(appointments), 0, "should return at least one appointment")
 }
 
 func TestClientRecord(t *testing.T) {
-	records, err := client.PatientRecords(24)
+	skipWithoutDentalPro(t)
+	records, err := client.PatientRecords(context.Background(), 24)
 	require.NoError(t, err, "should not return an error")
 	require.Greater(t, len(records), 0, "should return at least one record")
 }
 
 func TestFreeIntervals(t *testing.T) {
-	intervals, err := client.FreeIntervals(
+	skipWithoutDentalPro(t)
+	intervals, err := client.FreeIntervals(context.Background(),
 		time.Date(2024, 10, 28, 0, 0, 0, 0, time.UTC),
 		time.Date(2024, 10, 30, 0, 0, 0, 0, time.UTC),
 		2,
@@ -2,50 +2,56 @@ package crm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
-	"sync"
 	"time"
 )
 
 type IDentalProClient interface {
-	DoctorsList() ([]Doctor, error)
-	AvailableAppointments(
+	DoctorsList(ctx context.Context) ([]Doctor, error)
+	AvailableAppointments(ctx context.Context,
 		userID int64, doctorIDs []int64, isPlanned bool) (map[int64]map[int64]Appointment, error)
 
-	CreatePatient(name, surname string, phone string) (Patient, error)
-	EditPatient(patient Patient) (EditPatientResponse, error)
+	CreatePatient(ctx context.Context, name, surname string, phone string) (Patient, error)
+	EditPatient(ctx context.Context, patient Patient) (EditPatientResponse, error)
 
-	PatientByPhone(phone string) (Patient, error)
-	FreeIntervals(
+	PatientByPhone(ctx context.Context, phone string) (Patient, error)
+	FreeIntervals(ctx context.Context,
 		startDate, endDate time.Time,
 		departmentID, doctorID, branchID int64, duration int,
 	) ([]DayInterval, error)
-	RecordCreate(
+	RecordCreate(ctx context.Context,
 		date, timeStart, timeEnd time.Time, doctorID, clientID, appointmentID int64, isPlanned bool,
 	) (*Record, error)
-	PatientRecords(clientID int64) ([]ShortRecord, error)
-	DeleteRecord(recordID int64) (ChangeRecord, error)
+	PatientRecords(ctx context.Context, clientID int64) ([]ShortRecord, error)
+	DeleteRecord(ctx context.Context, recordID int64) (ChangeRecord, error)
 }
 
 type DentalProClient struct {
-	Token          string
-	SecretKey      string
-	baseURL        string
-	client         *http.Client
-	last429Request time.Time
-	requestMu      sync.Mutex
+	Token     string
+	SecretKey string
+	baseURL   string
+	client    *http.Client
+	limits    RateLimitConfig
+	limiter   *tokenBucket
+	requests  chan struct{}
+	// tenant - клиника, к DentalPro которой ходит клиент. Метка tenant метрик
+	tenant string
 }
 
 type RequestError struct {
 	Code int
 	Err  error
+	// RetryAfter - значение заголовка Retry-After, если сервер его вернул
+	RetryAfter time.Duration
 }
 
 func (e RequestError) Error() string {
@@ -56,28 +62,59 @@ func (e RequestError) Unwrap() error {
 	return e.Err
 }
 
+// DefaultBaseURL - адрес DentalPro клиники "Олимп"
+const DefaultBaseURL = "https://olimp.crm3.dental-pro.online/"
+
 func NewDentalProClient(token string, secretKey string, test bool, testPath string) IDentalProClient {
 	if test {
 		return NewDentalProClientTest(token, testPath, secretKey)
 	}
+	return NewDentalProClientWithLimits(DefaultBaseURL, token, secretKey, DefaultRateLimitConfig())
+}
+
+// NewDentalProClientWithLimits создает клиент DentalPro по адресу baseURL. Пустой baseURL - DefaultBaseURL
+func NewDentalProClientWithLimits(
+	baseURL string, token string, secretKey string, limits RateLimitConfig,
+) *DentalProClient {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if limits.MaxConcurrent <= 0 {
+		limits.MaxConcurrent = 1
+	}
+	if limits.MaxAttempts <= 0 {
+		limits.MaxAttempts = 1
+	}
 	return &DentalProClient{
-		Token: token, SecretKey: secretKey, baseURL: "https://olimp.crm3.dental-pro.online/",
-		client:         &http.Client{Timeout: 10 * time.Second},
-		last429Request: time.Now(),
-		requestMu:      sync.Mutex{},
+		Token: token, SecretKey: secretKey, baseURL: baseURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		limits:   limits,
+		limiter:  newTokenBucket(limits.RequestsPerSecond, limits.Burst),
+		requests: make(chan struct{}, limits.MaxConcurrent),
 	}
 }
 
-func (c *DentalProClient) postRequest(path string, query url.Values, body []byte, data any) error {
-	c.requestMu.Lock()
-	defer c.requestMu.Unlock()
-	for range 5 {
-		duration := time.Until(c.last429Request.Add(3 * time.Second))
-		if duration > 0 {
-			time.Sleep(duration)
-		}
+// SetTenant задает имя клиники для метрик
+func (c *DentalProClient) SetTenant(tenant string) {
+	c.tenant = tenant
+}
 
-		err := c.tryPostRequest(path, query, body, data)
+func (c *DentalProClient) postRequest(ctx context.Context, path string, query url.Values, body []byte, data any) error {
+	var err error
+	for attempt := range c.limits.MaxAttempts {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		select {
+		case c.requests <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		start := time.Now()
+		err = c.tryPostRequest(ctx, path, cloneValues(query), body, data)
+		c.observeRequest(path, start, err)
+		logRequest(ctx, path, query, attempt, start, err)
+		<-c.requests
 		if err == nil {
 			return nil
 		}
@@ -85,20 +122,40 @@ func (c *DentalProClient) postRequest(path string, query url.Values, body []byte
 		var requestError *RequestError
 		if !errors.As(err, &requestError) || requestError.Code != http.StatusTooManyRequests {
 			return err
-		} else {
-			c.last429Request = time.Now()
+		}
+		delay := backoff(attempt, c.limits.BaseBackoff, c.limits.MaxBackoff)
+		if requestError.RetryAfter > 0 {
+			delay = requestError.RetryAfter
+			c.limiter.BlockFor(delay)
+		}
+		if attempt == c.limits.MaxAttempts-1 {
+			break
+		}
+		retriesTotal.WithLabelValues(c.tenant, path).Inc()
+		pkg.LoggerFromContext(ctx).Warnf("DentalPro %s: too many requests, retry in %s", path, delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
 		}
 	}
-	return RequestError{Code: http.StatusTooManyRequests, Err: errors.New("too many requests")}
+	return &RequestError{Code: http.StatusTooManyRequests, Err: fmt.Errorf("too many requests: %w", err)}
 }
 
-func (c *DentalProClient) tryPostRequest(path string, query url.Values, body []byte, data any) error {
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+	return clone
+}
+
+func (c *DentalProClient) tryPostRequest(
+	ctx context.Context, path string, query url.Values, body []byte, data any) error {
 	s, err := url.JoinPath(c.baseURL, path)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	req, err := http.NewRequest("POST", s, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", s, bytes.NewBuffer(body))
 	if err != nil {
 		return &RequestError{
 			Code: http.StatusInternalServerError,
@@ -112,10 +169,9 @@ func (c *DentalProClient) tryPostRequest(path string, query url.Values, body []b
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
 		return &RequestError{
 			Code: http.StatusInternalServerError,
-			Err:  err,
+			Err:  redactURLError(err),
 		}
 	}
 	defer func(Body io.ReadCloser) {
@@ -123,20 +179,30 @@ func (c *DentalProClient) tryPostRequest(path string, query url.Values, body []b
 	}(resp.Body)
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		logrus.Printf("error: server return status %d", resp.StatusCode)
 		return &RequestError{
-			Code: resp.StatusCode,
-			Err:  fmt.Errorf("error: server return status %d", resp.StatusCode),
+			Code:       resp.StatusCode,
+			Err:        fmt.Errorf("server return status %d", resp.StatusCode),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 		}
 	}
-	//b, _ := io.ReadAll(resp.Body)
-	//
-	//// Print the response body
-	//fmt.Println("Response Body:", string(b))
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
+		return &RequestError{Code: http.StatusBadGateway, Err: err}
+	}
+	var envelope responseEnvelope
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return &RequestError{Code: http.StatusBadGateway, Err: fmt.Errorf("decode %s response: %w", path, err)}
+	}
+	if err := envelopeError(path, envelope); err != nil {
 		return err
 	}
+	if err := json.Unmarshal(respBody, data); err != nil {
+		var unmarshalError *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalError) && isEmptyData(envelope.Data) {
+			return nil
+		}
+		return &RequestError{Code: http.StatusBadGateway, Err: fmt.Errorf("decode %s response: %w", path, err)}
+	}
 	return nil
 }
 
@@ -170,21 +236,21 @@ func (c *User) ToPatient() (*Patient, error) {
 	return patient, nil
 }
 
-func (c *DentalProClient) DoctorsList() ([]Doctor, error) {
+func (c *DentalProClient) DoctorsList(ctx context.Context) ([]Doctor, error) {
 	// Список врачей
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=mobile/doctor/list&target=modal
 	response := struct {
 		BaseResponse
 		Data []Doctor `json:"data"`
 	}{}
-	err := c.postRequest("/api/mobile/doctor/list", url.Values{}, nil, &response)
+	err := c.postRequest(ctx, "/api/mobile/doctor/list", url.Values{}, nil, &response)
 	if err != nil {
 		return nil, err
 	}
 	return response.Data, nil
 }
 
-func (c *DentalProClient) AvailableAppointments(
+func (c *DentalProClient) AvailableAppointments(ctx context.Context,
 	userID int64, doctorIDS []int64, isPlanned bool) (map[int64]map[int64]Appointment, error) {
 	// Приемы доступные к записи
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=mobile/records/appointmentsList&target=modal
@@ -203,12 +269,8 @@ func (c *DentalProClient) AvailableAppointments(
 		BaseResponse
 		Data map[string]map[string]Appointment `json:"data"`
 	}{}
-	err := c.postRequest("/api/mobile/records/appointmentsList", params, nil, &response)
+	err := c.postRequest(ctx, "/api/mobile/records/appointmentsList", params, nil, &response)
 	if err != nil {
-		var unmarshalError *json.UnmarshalTypeError
-		if errors.As(err, &unmarshalError) {
-			return map[int64]map[int64]Appointment{}, nil
-		}
 		return nil, err
 	}
 	data := make(map[int64]map[int64]Appointment, len(response.Data))
@@ -218,7 +280,7 @@ func (c *DentalProClient) AvailableAppointments(
 			logrus.Fatal(err)
 			return nil, &RequestError{
 				Code: http.StatusUnprocessableEntity,
-				Err:  fmt.Errorf("error: cannot convert doctor ID %s to int64", doctorIDStr),
+				Err:  fmt.Errorf("cannot convert doctor ID %s to int64", doctorIDStr),
 			}
 		}
 		data[doctorID] = make(map[int64]Appointment, len(appointments))
@@ -229,7 +291,7 @@ func (c *DentalProClient) AvailableAppointments(
 	return data, nil
 }
 
-func (c *DentalProClient) CreatePatient(name, surname string, phone string) (Patient, error) {
+func (c *DentalProClient) CreatePatient(ctx context.Context, name, surname string, phone string) (Patient, error) {
 	// Добавление пациента
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=records/createClient&target=modal
 	params := url.Values{
@@ -241,14 +303,14 @@ func (c *DentalProClient) CreatePatient(name, surname string, phone string) (Pat
 		BaseResponse
 		Data Patient `json:"data"`
 	}{}
-	err := c.postRequest("/api/records/createClient", params, nil, &response)
+	err := c.postRequest(ctx, "/api/records/createClient", params, nil, &response)
 	if err != nil {
 		return Patient{}, err
 	}
 	return response.Data, nil
 }
 
-func (c *DentalProClient) PatientByPhone(phone string) (Patient, error) {
+func (c *DentalProClient) PatientByPhone(ctx context.Context, phone string) (Patient, error) {
 	// Отдает пациента по его номеру телефона
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=client_by_phone&target=modal
 	phone = normalizePhoneNumber(phone)
@@ -257,15 +319,8 @@ func (c *DentalProClient) PatientByPhone(phone string) (Patient, error) {
 		BaseResponse
 		Data map[string]User `json:"data"`
 	}{}
-	err := c.postRequest("/api/client_by_phone", params, nil, &response)
+	err := c.postRequest(ctx, "/api/client_by_phone", params, nil, &response)
 	if err != nil {
-		var unmarshalError *json.UnmarshalTypeError
-		if errors.As(err, &unmarshalError) {
-			return Patient{}, &RequestError{
-				Code: http.StatusNotFound,
-				Err:  fmt.Errorf("user with phone %s not found", phone),
-			}
-		}
 		return Patient{}, err
 	}
 	for _, user := range response.Data {
@@ -276,10 +331,10 @@ func (c *DentalProClient) PatientByPhone(phone string) (Patient, error) {
 		return *patient, nil
 	}
 	return Patient{}, &RequestError{
-		Code: http.StatusNotFound, Err: fmt.Errorf("user by %s not fount", phone)}
+		Code: http.StatusNotFound, Err: fmt.Errorf("user with phone %s not found", phone)}
 }
 
-func (c *DentalProClient) FreeIntervals(
+func (c *DentalProClient) FreeIntervals(ctx context.Context,
 	startDate, endDate time.Time,
 	departmentID, doctorID, branchID int64, duration int,
 ) ([]DayInterval, error) {
@@ -297,7 +352,7 @@ func (c *DentalProClient) FreeIntervals(
 		BaseResponse
 		Data []DayInterval `json:"data"`
 	}{}
-	err := c.postRequest("/api/twin/freetimeintervals", params, nil, &response)
+	err := c.postRequest(ctx, "/api/twin/freetimeintervals", params, nil, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -305,7 +360,7 @@ func (c *DentalProClient) FreeIntervals(
 	return response.Data, nil
 }
 
-func (c *DentalProClient) EditPatient(patient Patient) (EditPatientResponse, error) {
+func (c *DentalProClient) EditPatient(ctx context.Context, patient Patient) (EditPatientResponse, error) {
 	// Редактирование базовой информации о пациенте
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=records/editClient&target=modal
 	params := url.Values{
@@ -330,13 +385,13 @@ func (c *DentalProClient) EditPatient(patient Patient) (EditPatientResponse, err
 		BaseResponse
 		Data EditPatientResponse `json:"data"`
 	}{}
-	if err := c.postRequest("/api/records/editClient", params, nil, &response); err != nil {
+	if err := c.postRequest(ctx, "/api/records/editClient", params, nil, &response); err != nil {
 		return EditPatientResponse{}, err
 	}
 	return response.Data, nil
 }
 
-func (c *DentalProClient) RecordCreate(
+func (c *DentalProClient) RecordCreate(ctx context.Context,
 	data, timeStart, timeEnd time.Time, doctorID, clientID, appointmentID int64, isPlanned bool,
 ) (*Record, error) {
 	// Запись пациента в расписание по автоприему/по ID medical_receptions
@@ -359,13 +414,13 @@ func (c *DentalProClient) RecordCreate(
 		Data *Record `json:"data"`
 	}{}
 
-	if err := c.postRequest("/api/records/create", params, nil, &response); err != nil {
+	if err := c.postRequest(ctx, "/api/records/create", params, nil, &response); err != nil {
 		return nil, err
 	}
 	return response.Data, nil
 }
 
-func (c *DentalProClient) PatientRecords(clientID int64) ([]ShortRecord, error) {
+func (c *DentalProClient) PatientRecords(ctx context.Context, clientID int64) ([]ShortRecord, error) {
 	// Записи пациента по ID пациента
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=i/client/records&target=modal
 	// Duration возвращается в секундах, нужно конвертировать в минуты
@@ -374,7 +429,7 @@ func (c *DentalProClient) PatientRecords(clientID int64) ([]ShortRecord, error)
 		BaseResponse
 		Data []ShortRecord `json:"data"`
 	}{}
-	err := c.postRequest("/api/i/client/records", params, nil, &response)
+	err := c.postRequest(ctx, "/api/i/client/records", params, nil, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -384,7 +439,7 @@ func (c *DentalProClient) PatientRecords(clientID int64) ([]ShortRecord, error)
 	return response.Data, nil
 }
 
-func (c *DentalProClient) DeleteRecord(recordID int64) (ChangeRecord, error) {
+func (c *DentalProClient) DeleteRecord(ctx context.Context, recordID int64) (ChangeRecord, error) {
 	// Удаление записи из расписания
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=records/deleteMedilineRecord&target=modal
 	params := url.Values{"mediline_record_id": []string{strconv.FormatInt(recordID, 10)}}
@@ -392,7 +447,7 @@ func (c *DentalProClient) DeleteRecord(recordID int64) (ChangeRecord, error) {
 		BaseResponse
 		Data ChangeRecord `json:"data"`
 	}{}
-	err := c.postRequest("/api/records/deleteMedilineRecord", params, nil, &response)
+	err := c.postRequest(ctx, "/api/records/deleteMedilineRecord", params, nil, &response)
 	if err != nil {
 		return ChangeRecord{}, err
 	}
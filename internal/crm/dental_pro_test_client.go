@@ -1,6 +1,7 @@
 package crm
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -108,7 +109,7 @@ func NewDentalProClientTest(token, path, secretKey string) *DentalProClientTest
 	}
 }
 
-func (c *DentalProClientTest) DoctorsList() ([]Doctor, error) {
+func (c *DentalProClientTest) DoctorsList(ctx context.Context) ([]Doctor, error) {
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/test?method=mobile/doctor/list&target=modal
 	doctors := make([]Doctor, 0, len(c.Doctors))
 	for _, doctor := range c.Doctors {
@@ -120,7 +121,7 @@ func (c *DentalProClientTest) DoctorsList() ([]Doctor, error) {
 	return doctors, nil
 }
 
-func (c *DentalProClientTest) AvailableAppointments(
+func (c *DentalProClientTest) AvailableAppointments(ctx context.Context,
 	userID int64, doctorIDS []int64, isPlanned bool) (map[int64]map[int64]Appointment, error) {
 	result := make(map[int64]map[int64]Appointment)
 
@@ -143,7 +144,7 @@ func (c *DentalProClientTest) AvailableAppointments(
 	return result, nil
 }
 
-func (c *DentalProClientTest) CreatePatient(name, surname string, phone string) (Patient, error) {
+func (c *DentalProClientTest) CreatePatient(ctx context.Context, name, surname string, phone string) (Patient, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -160,7 +161,7 @@ func (c *DentalProClientTest) CreatePatient(name, surname string, phone string)
 	return patient, nil
 }
 
-func (c *DentalProClientTest) PatientByPhone(phone string) (Patient, error) {
+func (c *DentalProClientTest) PatientByPhone(ctx context.Context, phone string) (Patient, error) {
 	for _, patient := range c.Patients {
 		if patient.Phone == phone {
 			return patient, nil
@@ -172,7 +173,7 @@ func (c *DentalProClientTest) PatientByPhone(phone string) (Patient, error) {
 	}
 }
 
-func (c *DentalProClientTest) FreeIntervals(
+func (c *DentalProClientTest) FreeIntervals(ctx context.Context,
 	startDate, endDate time.Time,
 	departmentID, doctorID, branchID int64, duration int,
 ) ([]DayInterval, error) {
@@ -222,15 +223,15 @@ func (c *DentalProClientTest) FreeIntervals(
 	return result, nil
 }
 
-func (c *DentalProClientTest) EditPatient(patient Patient) (EditPatientResponse, error) {
+func (c *DentalProClientTest) EditPatient(ctx context.Context, patient Patient) (EditPatientResponse, error) {
 	// Редактирование базовой информации о пациенте
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=records/editClient&target=modal
 	editPatient, ok := c.Patients[patient.ExternalID]
 	if !ok {
 		msg := fmt.Errorf("patient with externalID %d not found", patient.ExternalID)
 		return EditPatientResponse{Status: false, Message: msg.Error()}, &RequestError{
-			http.StatusNotFound,
-			msg,
+			Code: http.StatusNotFound,
+			Err:  msg,
 		}
 	}
 	editPatient.Phone = patient.Phone
@@ -242,7 +243,7 @@ func (c *DentalProClientTest) EditPatient(patient Patient) (EditPatientResponse,
 	}, nil
 }
 
-func (c *DentalProClientTest) RecordCreate(
+func (c *DentalProClientTest) RecordCreate(ctx context.Context,
 	date, timeStart, timeEnd time.Time, doctorID, clientID, appointmentID int64, isPlanned bool,
 ) (*Record, error) {
 	// Запись пациента в расписание по автоприему/по ID medical_receptions
@@ -276,7 +277,7 @@ func (c *DentalProClientTest) joinUserGroups(userGroups map[string]string) strin
 	return strings.Join(groups, "")
 }
 
-func (c *DentalProClientTest) PatientRecords(clientID int64) ([]ShortRecord, error) {
+func (c *DentalProClientTest) PatientRecords(ctx context.Context, clientID int64) ([]ShortRecord, error) {
 	// Записи пациента по ID пациента
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=i/client/records&target=modal
 	records, ok := c.Records[clientID]
@@ -307,7 +308,7 @@ func (c *DentalProClientTest) PatientRecords(clientID int64) ([]ShortRecord, err
 	return shortRecords, nil
 }
 
-func (c *DentalProClientTest) DeleteRecord(recordID int64) (ChangeRecord, error) {
+func (c *DentalProClientTest) DeleteRecord(ctx context.Context, recordID int64) (ChangeRecord, error) {
 	// Удаление записи из расписания
 	// https://olimp.crm3.dental-pro.online/apisettings/api/index#/apisettings/api/detail?method=records/deleteMedilineRecord&target=modal
 
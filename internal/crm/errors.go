@@ -0,0 +1,116 @@
+package crm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Категории ошибок DentalPro. Проверяются через errors.Is, например errors.Is(err, crm.ErrNotFound)
+var (
+	ErrNotFound     = errors.New("dental pro: not found")
+	ErrValidation   = errors.New("dental pro: validation error")
+	ErrRateLimited  = errors.New("dental pro: rate limited")
+	ErrUnauthorized = errors.New("dental pro: unauthorized")
+	ErrUpstream     = errors.New("dental pro: upstream error")
+)
+
+// Kind возвращает категорию ошибки по HTTP коду
+func (e RequestError) Kind() error {
+	switch e.Code {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	default:
+		return ErrUpstream
+	}
+}
+
+func (e RequestError) Is(target error) bool {
+	return e.Kind() == target
+}
+
+// responseEnvelope - общая часть ответов DentalPro. Status - указатель,
+// чтобы отличать явный отказ от ответа без поля status
+type responseEnvelope struct {
+	Status  *bool           `json:"status"`
+	Error   any             `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// envelopeError переводит отказ DentalPro (status: false) в RequestError.
+// Поле error бывает строкой, списком строк или объектом с code и message.
+// Некоторые методы, например records/delete, пишут причину отказа в message
+func envelopeError(path string, envelope responseEnvelope) error {
+	if envelope.Status == nil || *envelope.Status {
+		return nil
+	}
+	code, message := http.StatusUnprocessableEntity, ""
+	switch value := envelope.Error.(type) {
+	case string:
+		message = value
+	case []any:
+		messages := make([]string, 0, len(value))
+		for _, item := range value {
+			messages = append(messages, fmt.Sprint(item))
+		}
+		message = strings.Join(messages, "; ")
+	case map[string]any:
+		if errorCode, ok := envelopeCode(value["code"]); ok {
+			code = errorCode
+		}
+		for _, key := range []string{"message", "msg", "text"} {
+			if text, ok := value[key].(string); ok {
+				message = text
+				break
+			}
+		}
+	}
+	if message == "" {
+		message = envelope.Message
+	}
+	if message == "" {
+		message = "request rejected"
+	}
+	return &RequestError{Code: code, Err: fmt.Errorf("%s: %s", path, message)}
+}
+
+// envelopeCode возвращает код ошибки, если он похож на HTTP статус
+func envelopeCode(value any) (int, bool) {
+	var code int
+	switch value := value.(type) {
+	case float64:
+		code = int(value)
+	case string:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false
+		}
+		code = parsed
+	default:
+		return 0, false
+	}
+	if code < 400 || code > 599 {
+		return 0, false
+	}
+	return code, true
+}
+
+// isEmptyData проверяет, что DentalPro вернул пустые данные.
+// Вместо пустого объекта он присылает [], false или null
+func isEmptyData(data json.RawMessage) bool {
+	switch strings.TrimSpace(string(data)) {
+	case "", "null", "false", "[]", "{}":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestErrorKind(t *testing.T) {
+	cases := map[int]error{
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusUnprocessableEntity: ErrValidation,
+		http.StatusBadRequest:          ErrValidation,
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusUnauthorized:        ErrUnauthorized,
+		http.StatusForbidden:           ErrUnauthorized,
+		http.StatusBadGateway:          ErrUpstream,
+		http.StatusInternalServerError: ErrUpstream,
+	}
+	for code, kind := range cases {
+		var err error = &RequestError{Code: code, Err: errors.New("test")}
+		require.ErrorIs(t, err, kind, "status %d", code)
+		require.ErrorIs(t, fmt.Errorf("wrapped: %w", err), kind, "status %d", code)
+	}
+}
+
+func TestDentalProClientEnvelopeErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		kind   error
+	}{
+		{"rejected without code", http.StatusOK, `{"status": false, "error": "bad phone"}`, ErrValidation},
+		{"rejected with code", http.StatusOK, `{"status": false, "error": {"code": 404, "message": "no client"}}`, ErrNotFound},
+		{"rejected with message", http.StatusOK, `{"status": false, "message": "record not found"}`, ErrValidation},
+		{"rejected with string code", http.StatusOK, `{"status": false, "error": {"code": "401"}}`, ErrUnauthorized},
+		{"unauthorized", http.StatusUnauthorized, ``, ErrUnauthorized},
+		{"server error", http.StatusInternalServerError, ``, ErrUpstream},
+		{"broken body", http.StatusOK, `{"status": true, "data": `, ErrUpstream},
+		{"unexpected data", http.StatusOK, `{"status": true, "data": "doctor"}`, ErrUpstream},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newEnvelopeTestClient(t, c.status, c.body)
+			_, err := client.DoctorsList(context.Background())
+			require.ErrorIs(t, err, c.kind)
+		})
+	}
+}
+
+func TestDentalProClientEmptyData(t *testing.T) {
+	client := newEnvelopeTestClient(t, http.StatusOK, `{"status": true, "data": []}`)
+	appointments, err := client.AvailableAppointments(context.Background(), 1, []int64{2}, false)
+	require.NoError(t, err)
+	require.Empty(t, appointments)
+
+	client = newEnvelopeTestClient(t, http.StatusOK, `{"status": true, "data": []}`)
+	_, err = client.PatientByPhone(context.Background(), "79999999999")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func newEnvelopeTestClient(t *testing.T, status int, body string) *DentalProClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewDentalProClientWithLimits(server.URL, "token", "secret", DefaultRateLimitConfig())
+}
+
+func TestDentalProClientEnvelopeMessage(t *testing.T) {
+	client := newEnvelopeTestClient(t, http.StatusOK, `{"status": false, "message": "record not found"}`)
+	_, err := client.DeleteRecord(context.Background(), 10)
+	require.ErrorContains(t, err, "record not found")
+}
@@ -0,0 +1,324 @@
+package crm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeDentalProServer - HTTP сервер с API DentalPro поверх данных DentalProClientTest.
+// В отличие от DentalProClientTest, запросы проходят через настоящий DentalProClient
+type FakeDentalProServer struct {
+	*httptest.Server
+	Token     string
+	SecretKey string
+	Data      *DentalProClientTest
+
+	fixtures string
+	mu       sync.Mutex
+	faults   map[string][]fakeFault
+	hits     map[string]int
+}
+
+// fakeFault - подмененный ответ на один запрос
+type fakeFault struct {
+	status     int
+	body       string
+	retryAfter time.Duration
+}
+
+// NewFakeDentalProServer запускает сервер. path - директория с test_data, как в NewDentalProClientTest
+func NewFakeDentalProServer(token, secretKey, path string) *FakeDentalProServer {
+	s := &FakeDentalProServer{
+		Token:     token,
+		SecretKey: secretKey,
+		Data:      NewDentalProClientTest(token, path, secretKey),
+		fixtures:  path,
+		faults:    map[string][]fakeFault{},
+		hits:      map[string]int{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/mobile/doctor/list", s.doctorsList)
+	mux.HandleFunc("/api/mobile/records/appointmentsList", s.availableAppointments)
+	mux.HandleFunc("/api/records/createClient", s.createPatient)
+	mux.HandleFunc("/api/client_by_phone", s.patientByPhone)
+	mux.HandleFunc("/api/twin/freetimeintervals", s.freeIntervals)
+	mux.HandleFunc("/api/records/editClient", s.editPatient)
+	mux.HandleFunc("/api/records/create", s.recordCreate)
+	mux.HandleFunc("/api/i/client/records", s.patientRecords)
+	mux.HandleFunc("/api/records/deleteMedilineRecord", s.deleteRecord)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// Client возвращает DentalProClient, который ходит в этот сервер
+func (s *FakeDentalProServer) Client(limits RateLimitConfig) *DentalProClient {
+	return NewDentalProClientWithLimits(s.URL, s.Token, s.SecretKey, limits)
+}
+
+// InjectStatus заставляет следующие times запросов к path вернуть HTTP статус status
+func (s *FakeDentalProServer) InjectStatus(path string, status int, times int, retryAfter time.Duration) {
+	s.inject(path, fakeFault{status: status, retryAfter: retryAfter}, times)
+}
+
+// InjectBody заставляет следующие times запросов к path вернуть body со статусом 200
+func (s *FakeDentalProServer) InjectBody(path string, body string, times int) {
+	s.inject(path, fakeFault{status: http.StatusOK, body: body}, times)
+}
+
+func (s *FakeDentalProServer) inject(path string, fault fakeFault, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range times {
+		s.faults[path] = append(s.faults[path], fault)
+	}
+}
+
+// Hits возвращает, сколько запросов пришло на path
+func (s *FakeDentalProServer) Hits(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func (s *FakeDentalProServer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		var fault *fakeFault
+		if faults := s.faults[r.URL.Path]; len(faults) > 0 {
+			fault = &faults[0]
+			s.faults[r.URL.Path] = faults[1:]
+		}
+		s.mu.Unlock()
+
+		if fault != nil {
+			if fault.retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(fault.retryAfter/time.Second)))
+			}
+			w.WriteHeader(fault.status)
+			_, _ = w.Write([]byte(fault.body))
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("token") != s.Token || query.Get("secret") != s.SecretKey {
+			writeFakeError(w, http.StatusUnauthorized, "invalid token or secret")
+			return
+		}
+
+		// DentalProClientTest не рассчитан на конкурентный доступ
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *FakeDentalProServer) doctorsList(w http.ResponseWriter, _ *http.Request) {
+	data, err := os.ReadFile(filepath.Join(s.fixtures, "/test_data/doctor_list.json"))
+	if err != nil {
+		writeFakeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+func (s *FakeDentalProServer) availableAppointments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, err := fakeInt(query.Get("userID"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	doctorIDs := make([]int64, 0, len(query["doctorIDS[]"]))
+	for _, value := range query["doctorIDS[]"] {
+		doctorID, err := fakeInt(value)
+		if err != nil {
+			writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		doctorIDs = append(doctorIDs, doctorID)
+	}
+
+	appointments, _ := s.Data.AvailableAppointments(r.Context(), userID, doctorIDs, query.Get("isPlanned") == "1")
+	if len(appointments) == 0 {
+		// Пустой результат DentalPro отдает списком, а не объектом
+		writeFakeData(w, "mobile/records/appointmentsList", []any{})
+		return
+	}
+	data := make(map[string]map[string]Appointment, len(appointments))
+	for doctorID, doctorAppointments := range appointments {
+		items := make(map[string]Appointment, len(doctorAppointments))
+		for appointmentID, appointment := range doctorAppointments {
+			items[strconv.FormatInt(appointmentID, 10)] = appointment
+		}
+		data[strconv.FormatInt(doctorID, 10)] = items
+	}
+	writeFakeData(w, "mobile/records/appointmentsList", data)
+}
+
+func (s *FakeDentalProServer) createPatient(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("phone") == "" {
+		writeFakeError(w, http.StatusUnprocessableEntity, "phone is required")
+		return
+	}
+	patient, _ := s.Data.CreatePatient(r.Context(), query.Get("name"), query.Get("surname"), query.Get("phone"))
+	writeFakeData(w, "records/createClient", patient)
+}
+
+func (s *FakeDentalProServer) patientByPhone(w http.ResponseWriter, r *http.Request) {
+	patient, err := s.Data.PatientByPhone(r.Context(), r.URL.Query().Get("phone"))
+	if err != nil {
+		writeFakeData(w, "client_by_phone", []any{})
+		return
+	}
+	user := User{
+		IDClient: strconv.FormatInt(patient.ExternalID, 10),
+		Name:     patient.Name,
+		Surname:  patient.Surname,
+	}
+	user.ContactInformation.MobilePhone = patient.Phone
+	writeFakeData(w, "client_by_phone", map[string]User{user.IDClient: user})
+}
+
+func (s *FakeDentalProServer) freeIntervals(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, err := time.Parse("2006-01-02", query.Get("date_start"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	end, err := time.Parse("2006-01-02", query.Get("date_end"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	doctorID, err := fakeInt(query.Get("doctor_id"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	duration, err := strconv.Atoi(query.Get("duration"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	intervals, _ := s.Data.FreeIntervals(r.Context(), start, end, -1, doctorID, -1, duration)
+	if intervals == nil {
+		intervals = []DayInterval{}
+	}
+	writeFakeData(w, "twin/freetimeintervals", intervals)
+}
+
+func (s *FakeDentalProServer) editPatient(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientID, err := fakeInt(query.Get("clientID"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	response, _ := s.Data.EditPatient(r.Context(), Patient{
+		ExternalID: clientID,
+		Name:       query.Get("name"),
+		Surname:    query.Get("surname"),
+		Phone:      query.Get("phone"),
+	})
+	writeFakeData(w, "records/editClient", response)
+}
+
+func (s *FakeDentalProServer) recordCreate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	date, err := time.Parse("2006-01-02", query.Get("date"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	timeStart, err := time.Parse("15:04:05", query.Get("time_start"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	timeEnd, err := time.Parse("15:04:05", query.Get("time_end"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	var ids [3]int64
+	for i, key := range []string{"doctor_id", "client_id", "appointment_id"} {
+		if ids[i], err = fakeInt(query.Get(key)); err != nil {
+			writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	record, _ := s.Data.RecordCreate(r.Context(),
+		date, timeStart, timeEnd, ids[0], ids[1], ids[2], query.Get("is_planned") == "1",
+	)
+	writeFakeData(w, "records/create", record)
+}
+
+func (s *FakeDentalProServer) patientRecords(w http.ResponseWriter, r *http.Request) {
+	clientID, err := fakeInt(r.URL.Query().Get("client_id"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	records, _ := s.Data.PatientRecords(r.Context(), clientID)
+	for i := range records {
+		// DentalPro отдает длительность в секундах
+		records[i].Duration *= 60
+	}
+	writeFakeData(w, "i/client/records", records)
+}
+
+func (s *FakeDentalProServer) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	recordID, err := fakeInt(r.URL.Query().Get("mediline_record_id"))
+	if err != nil {
+		writeFakeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	response, err := s.Data.DeleteRecord(r.Context(), recordID)
+	if err != nil {
+		response.Message = err.Error()
+	}
+	writeFakeData(w, "records/deleteMedilineRecord", response)
+}
+
+func fakeInt(value string) (int64, error) {
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return number, nil
+}
+
+func writeFakeData(w http.ResponseWriter, method string, data any) {
+	response := struct {
+		Method string `json:"method"`
+		Status bool   `json:"status"`
+		Data   any    `json:"data"`
+	}{Method: method, Status: true, Data: data}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&response)
+}
+
+func writeFakeError(w http.ResponseWriter, code int, message string) {
+	response := struct {
+		Status bool `json:"status"`
+		Error  any  `json:"error"`
+	}{Error: map[string]any{"code": code, "message": message}}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&response)
+}
@@ -0,0 +1,121 @@
+package crm
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newFakeServer(t *testing.T) (*FakeDentalProServer, *DentalProClient) {
+	server := NewFakeDentalProServer("token", "secret", ".")
+	t.Cleanup(server.Close)
+	limits := DefaultRateLimitConfig()
+	limits.RequestsPerSecond = 1000
+	limits.Burst = 1000
+	limits.BaseBackoff = time.Millisecond
+	limits.MaxBackoff = 5 * time.Millisecond
+	return server, server.Client(limits)
+}
+
+func TestFakeServerDoctorsList(t *testing.T) {
+	_, client := newFakeServer(t)
+	doctors, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, doctors)
+	doctor := GetDoctorByID(doctors, 2)
+	require.NotNil(t, doctor)
+	require.Equal(t, time.Date(2023, 9, 12, 10, 22, 40, 0, time.UTC), time.Time(doctor.DateAdded))
+}
+
+func TestFakeServerFreeIntervals(t *testing.T) {
+	_, client := newFakeServer(t)
+	intervals, err := client.FreeIntervals(context.Background(),
+		time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 11, 30, 0, 0, 0, 0, time.UTC),
+		-1, 2, 3, 15,
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, intervals)
+	slot := intervals[0].Slots[0]
+	require.Equal(t, "2", slot.DoctorID)
+	begin, end := time.Time(slot.Time[0].Begin), time.Time(slot.Time[0].End)
+	require.Equal(t, 15*time.Minute, end.Sub(begin))
+}
+
+func TestFakeServerAppointments(t *testing.T) {
+	_, client := newFakeServer(t)
+	appointments, err := client.AvailableAppointments(context.Background(), -1, []int64{2, 12}, false)
+	require.NoError(t, err)
+	require.Contains(t, appointments[2], int64(41))
+	require.Contains(t, appointments[12], int64(86))
+
+	appointments, err = client.AvailableAppointments(context.Background(), -1, []int64{100}, false)
+	require.NoError(t, err)
+	require.Empty(t, appointments)
+}
+
+func TestFakeServerRecordLifecycle(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeServer(t)
+
+	_, err := client.PatientByPhone(ctx, "+7 (999) 123-45-67")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	created, err := client.CreatePatient(ctx, "Иван", "Иванов", "+7 (999) 123-45-67")
+	require.NoError(t, err)
+	patient, err := client.PatientByPhone(ctx, "+7 (999) 123-45-67")
+	require.NoError(t, err)
+	require.Equal(t, created.ExternalID, patient.ExternalID)
+	require.Equal(t, "79991234567", patient.Phone)
+
+	date := time.Date(2024, 11, 12, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 11, 12, 10, 0, 0, 0, time.UTC)
+	record, err := client.RecordCreate(ctx, date, start, start.Add(30*time.Minute), 2, patient.ExternalID, 86, false)
+	require.NoError(t, err)
+
+	records, err := client.PatientRecords(ctx, patient.ExternalID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, record.ID, records[0].ID)
+	require.Equal(t, start, time.Time(records[0].DateStart))
+
+	deleted, err := client.DeleteRecord(ctx, record.ID)
+	require.NoError(t, err)
+	require.True(t, deleted.Status)
+	deleted, err = client.DeleteRecord(ctx, record.ID)
+	require.NoError(t, err)
+	require.False(t, deleted.Status)
+}
+
+func TestFakeServerWrongCredentials(t *testing.T) {
+	server, _ := newFakeServer(t)
+	client := NewDentalProClientWithLimits(server.URL, "token", "wrong", DefaultRateLimitConfig())
+	_, err := client.DoctorsList(context.Background())
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestFakeServerRetriesTooManyRequests(t *testing.T) {
+	server, client := newFakeServer(t)
+	server.InjectStatus("/api/mobile/doctor/list", http.StatusTooManyRequests, 2, 0)
+	doctors, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, doctors)
+	require.Equal(t, 3, server.Hits("/api/mobile/doctor/list"))
+
+	server.InjectStatus("/api/mobile/doctor/list", http.StatusTooManyRequests, 10, 0)
+	_, err = client.DoctorsList(context.Background())
+	require.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestFakeServerMalformedPayload(t *testing.T) {
+	server, client := newFakeServer(t)
+	server.InjectBody("/api/i/client/records", `{"status": true, "data": [{"dateStart": "tomorrow"}]}`, 1)
+	_, err := client.PatientRecords(context.Background(), 1)
+	require.ErrorIs(t, err, ErrUpstream)
+
+	server.InjectBody("/api/i/client/records", `{"status": true, "data": [`, 1)
+	_, err = client.PatientRecords(context.Background(), 1)
+	require.ErrorIs(t, err, ErrUpstream)
+}
@@ -0,0 +1,62 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"time"
+)
+
+// secretParams - параметры запроса DentalPro, которые нельзя писать в логи: ключи API и персональные данные пациента
+var secretParams = []string{"token", "secret", "phone", "name", "surname", "secondName", "birthday", "comments"}
+
+const redacted = "***"
+
+// redactQuery возвращает query строкой, заменяя значения secretParams
+func redactQuery(query url.Values) string {
+	query = cloneValues(query)
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+		}
+	}
+	return query.Encode()
+}
+
+// redactURLError убирает секреты из адреса в ошибке http.Client: иначе они попадут в лог вместе с ошибкой
+func redactURLError(err error) error {
+	var urlError *url.Error
+	if !errors.As(err, &urlError) {
+		return err
+	}
+	parsed, parseErr := url.Parse(urlError.URL)
+	if parseErr != nil {
+		urlError.URL = redacted
+		return err
+	}
+	parsed.RawQuery = redactQuery(parsed.Query())
+	urlError.URL = parsed.String()
+	return err
+}
+
+// logRequest пишет метаданные запроса к DentalPro в логгер из ctx, чтобы они попали в лог обновления.
+// Параметры запроса пишутся только на уровне debug
+func logRequest(ctx context.Context, path string, query url.Values, attempt int, start time.Time, err error) {
+	log := pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"module":      "crm.dental_pro_client",
+		"endpoint":    path,
+		"attempt":     attempt + 1,
+		"status":      requestStatus(err),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	if log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		log = log.WithField("query", redactQuery(query))
+	}
+	if err != nil {
+		log.WithError(err).Warn("DentalPro request failed")
+		return
+	}
+	log.Info("DentalPro request")
+}
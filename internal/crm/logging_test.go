@@ -0,0 +1,64 @@
+package crm
+
+import (
+	"bytes"
+	"context"
+	"github.com/AnVladic/DentalTelegramBot/pkg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	query := url.Values{"token": {"abc"}, "secret": {"xyz"}, "id": {"5"}}
+	require.Equal(t, "id=5&secret=%2A%2A%2A&token=%2A%2A%2A", redactQuery(query))
+	require.Equal(t, "abc", query.Get("token"), "query of the request is not changed")
+
+	query = url.Values{"phone": {"79991234567"}, "name": {"Иван"}, "surname": {"Иванов"}, "clientID": {"5"}}
+	require.Equal(t, "clientID=5&name=%2A%2A%2A&phone=%2A%2A%2A&surname=%2A%2A%2A", redactQuery(query))
+}
+
+func TestDentalProClientLogsWithoutSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	client := NewDentalProClientWithLimits(server.URL, "tg-token-value", "tg-secret-value", DefaultRateLimitConfig())
+
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	ctx := pkg.ContextWithLogger(context.Background(), logger.WithField("update_id", 42))
+
+	_, err := client.DoctorsList(ctx)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "tg-token-value")
+	require.NotContains(t, err.Error(), "tg-secret-value")
+	require.Contains(t, output.String(), `"update_id":42`)
+	require.Contains(t, output.String(), `"endpoint":"/api/mobile/doctor/list"`)
+	require.NotContains(t, output.String(), "tg-token-value")
+	require.NotContains(t, output.String(), "tg-secret-value")
+	require.NotContains(t, output.String(), `"query"`, "query is logged only at debug level")
+}
+
+func TestDentalProClientLogsQueryAtDebug(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status": true, "data": {"id": 1}}`))
+	}))
+	t.Cleanup(server.Close)
+	client := NewDentalProClientWithLimits(server.URL, "token", "secret", DefaultRateLimitConfig())
+
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.DebugLevel)
+	ctx := pkg.ContextWithLogger(context.Background(), logrus.NewEntry(logger))
+
+	_, _ = client.CreatePatient(ctx, "Иван", "Иванов", "79991234567")
+	require.Contains(t, output.String(), `"query"`)
+	require.NotContains(t, output.String(), "79991234567")
+	require.NotContains(t, output.String(), "Иванов")
+}
@@ -0,0 +1,41 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dentalbot_dentalpro_request_duration_seconds",
+		Help:    "DentalPro request latency by endpoint and response status",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"tenant", "endpoint", "status"})
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dentalbot_dentalpro_retries_total",
+		Help: "DentalPro requests retried after 429 Too Many Requests",
+	}, []string{"tenant", "endpoint"})
+)
+
+func (c *DentalProClient) observeRequest(path string, start time.Time, err error) {
+	requestDuration.WithLabelValues(c.tenant, path, requestStatus(err)).Observe(time.Since(start).Seconds())
+}
+
+// requestStatus - HTTP статус ответа; ошибки без ответа DentalPro считаются отдельно
+func requestStatus(err error) string {
+	var requestError *RequestError
+	switch {
+	case err == nil:
+		return strconv.Itoa(http.StatusOK)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.As(err, &requestError):
+		return strconv.Itoa(requestError.Code)
+	}
+	return "error"
+}
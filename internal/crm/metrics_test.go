@@ -0,0 +1,63 @@
+package crm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestStatus(t *testing.T) {
+	require.Equal(t, "200", requestStatus(nil))
+	require.Equal(t, "429", requestStatus(fmt.Errorf("wrapped: %w", &RequestError{Code: http.StatusTooManyRequests})))
+	require.Equal(t, "canceled", requestStatus(&RequestError{Code: 500, Err: context.DeadlineExceeded}))
+	require.Equal(t, "error", requestStatus(errors.New("test")))
+}
+
+func TestDentalProClientRetryMetrics(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": true, "data": []}`))
+	}))
+	t.Cleanup(server.Close)
+	limits := DefaultRateLimitConfig()
+	limits.BaseBackoff = time.Millisecond
+	client := NewDentalProClientWithLimits(server.URL, "token", "secret", limits)
+	client.SetTenant("retry")
+
+	retries := retriesTotal.WithLabelValues("retry", "/api/mobile/doctor/list")
+	_, err := client.DoctorsList(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), requests.Load())
+	require.Equal(t, 1.0, testutil.ToFloat64(retries))
+}
+
+func TestDentalProClientDoesNotWaitAfterLastAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	limits := DefaultRateLimitConfig()
+	limits.MaxAttempts = 1
+	client := NewDentalProClientWithLimits(server.URL, "token", "secret", limits)
+	client.SetTenant("last_attempt")
+
+	retries := retriesTotal.WithLabelValues("last_attempt", "/api/mobile/doctor/list")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.DoctorsList(ctx)
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.NotContains(t, err.Error(), "error: error")
+	require.Equal(t, 0.0, testutil.ToFloat64(retries))
+}
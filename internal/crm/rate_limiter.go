@@ -0,0 +1,135 @@
+package crm
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig - ограничения запросов к DentalPro
+type RateLimitConfig struct {
+	// RequestsPerSecond - средняя скорость запросов, Burst - сколько запросов можно сделать подряд
+	RequestsPerSecond float64
+	Burst             int
+	// MaxConcurrent - сколько запросов может выполняться одновременно
+	MaxConcurrent int
+	// MaxAttempts - сколько раз повторять запрос, получивший 429
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		RequestsPerSecond: 5,
+		Burst:             5,
+		MaxConcurrent:     4,
+		MaxAttempts:       5,
+		BaseBackoff:       500 * time.Millisecond,
+		MaxBackoff:        10 * time.Second,
+	}
+}
+
+// tokenBucket выдает токены со скоростью rate, накапливая не больше burst.
+// После 429 с Retry-After все запросы ждут до blockedUntil
+type tokenBucket struct {
+	mu           sync.Mutex
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	now          func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		rate = 1
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+// reserve забирает токен и возвращает, сколько нужно подождать перед запросом
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if blocked := b.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	return wait
+}
+
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	return sleepContext(ctx, b.reserve())
+}
+
+// sleepContext ждет d или отмены ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *tokenBucket) BlockFor(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := b.now().Add(d); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// backoff - экспоненциальная задержка с джиттером для попытки attempt (с нуля)
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base << attempt
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay/2 + jitter
+}
+
+// parseRetryAfter разбирает заголовок Retry-After: число секунд или HTTP дату
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
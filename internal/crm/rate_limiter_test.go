@@ -0,0 +1,43 @@
+package crm
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2, 2)
+	bucket.now = func() time.Time { return now }
+
+	require.Zero(t, bucket.reserve())
+	require.Zero(t, bucket.reserve())
+	require.Equal(t, 500*time.Millisecond, bucket.reserve())
+	require.Equal(t, time.Second, bucket.reserve())
+
+	now = now.Add(10 * time.Second)
+	require.Zero(t, bucket.reserve(), "bucket refills up to burst")
+
+	bucket.BlockFor(3 * time.Second)
+	require.Equal(t, 3*time.Second, bucket.reserve())
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := range 10 {
+		delay := backoff(attempt, 100*time.Millisecond, time.Second)
+		expected := min(100*time.Millisecond<<attempt, time.Second)
+		require.GreaterOrEqual(t, delay, expected/2)
+		require.LessOrEqual(t, delay, expected)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 9, 17, 0, 0, 0, time.UTC)
+	require.Equal(t, 7*time.Second, parseRetryAfter("7", now))
+	require.Zero(t, parseRetryAfter("", now))
+	require.Zero(t, parseRetryAfter("soon", now))
+	date := now.Add(30 * time.Second).Format(http.TimeFormat)
+	require.Equal(t, 30*time.Second, parseRetryAfter(date, now))
+}
@@ -20,9 +20,148 @@ type Register struct {
 	DoctorID      *int64     `db:"doctor_id"`
 	AppointmentID *int64     `db:"appointment_id"`
 	Datetime      *time.Time `db:"datetime"`
+	MoveRecordID  *int64     `db:"move_record_id"`
+	BranchID      *int64     `db:"branch_id"`
+	Source        string     `db:"source"`
+}
+
+// BookedRecord - запись, созданная или перенесенная через бота, вместе с пациентом и врачом
+type BookedRecord struct {
+	BookingID int64
+	RecordID  int64
+	Action    string
+	TgUserID  int64
+	Name      *string
+	Lastname  *string
+	Phone     *string
+	DoctorFIO *string
+	Datetime  *time.Time
+	BookedAt  time.Time
+}
+
+// Stats - сводка по пользователям и записям бота
+type Stats struct {
+	Users         int
+	Patients      int
+	Booked        int
+	Confirmations map[string]int
 }
 
 type Doctor struct {
 	ID  int64
 	FIO string
 }
+
+type Reminder struct {
+	ID            int64
+	UserID        int64
+	RecordID      int64
+	OffsetMinutes int
+	SentAt        time.Time
+}
+
+const (
+	RecordConfirmed   = "confirmed"
+	RecordCancelled   = "cancelled"
+	RecordRescheduled = "rescheduled"
+)
+
+type RecordConfirmation struct {
+	ID        int64
+	UserID    int64
+	RecordID  int64
+	Status    string
+	UpdatedAt time.Time
+}
+
+type ChatState struct {
+	ChatID    int64
+	Step      string
+	Payload   *string
+	UpdatedAt time.Time
+}
+
+const (
+	BroadcastRunning  = "running"
+	BroadcastPaused   = "paused"
+	BroadcastFinished = "finished"
+)
+
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryBlocked = "blocked"
+	DeliveryFailed  = "failed"
+)
+
+type Broadcast struct {
+	ID          int64
+	AdminChatID int64
+	Text        string
+	Status      string
+	CreatedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+type BroadcastDelivery struct {
+	ID          int64
+	BroadcastID int64
+	UserID      int64
+	TgUserID    int64
+	Status      string
+	Error       *string
+}
+
+const (
+	BookingCreated     = "created"
+	BookingCancelled   = "cancelled"
+	BookingRescheduled = "rescheduled"
+)
+
+// Источник действия: обычный диалог с ботом или кнопка в напоминании
+const (
+	BookingSourceMenu     = "menu"
+	BookingSourceReminder = "reminder"
+)
+
+// Booking - действие бота с записью в CRM. При переносе RecordID - новая запись,
+// PreviousRecordID - перенесенная
+type Booking struct {
+	ID               int64
+	UserID           int64
+	RecordID         int64
+	Action           string
+	Source           string
+	DoctorID         *int64
+	AppointmentID    *int64
+	BranchID         *int64
+	Datetime         *time.Time
+	PreviousRecordID *int64
+	CreatedAt        time.Time
+}
+
+const (
+	WaitlistWaiting   = "waiting"
+	WaitlistBooked    = "booked"
+	WaitlistCancelled = "cancelled"
+	WaitlistExpired   = "expired"
+)
+
+// WaitlistEntry - ожидание свободного времени у врача в диапазоне дат.
+// Если AppointmentID не задан, пациент ждет появления у врача доступных приемов
+type WaitlistEntry struct {
+	ID            int64
+	UserID        int64
+	TgUserID      int64
+	DentalProID   *int64
+	ChatID        int64
+	DoctorID      int64
+	AppointmentID *int64
+	BranchID      int64
+	DateFrom      time.Time
+	DateTo        time.Time
+	Status        string
+	Slot          *time.Time
+	NotifiedAt    *time.Time
+	CreatedAt     time.Time
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationLockID - ключ pg_advisory_lock, чтобы миграции не запускались одновременно из нескольких процессов
+const migrationLockID = 72_640_118
+
+// Migration - пара up/down файлов одной версии
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus - версия схемы в базе. Version = 0, если миграций еще не было
+type MigrationStatus struct {
+	Version int64
+	Dirty   bool
+	Latest  int64
+	Pending []Migration
+}
+
+// Migrator применяет миграции. Версия хранится в schema_migrations так же, как у golang-migrate,
+// поэтому базы, мигрированные его CLI, продолжают работать
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, files fs.FS) (*Migrator, error) {
+	migrations, err := ParseMigrations(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// ParseMigrations читает файлы вида 000001_name.up.sql и 000001_name.down.sql.
+// У каждой версии должны быть оба файла
+func ParseMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	// found хранит найденные направления: down файл может быть пустым, но должен существовать
+	found := map[int64]map[string]bool{}
+	for _, name := range names {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.up.sql or .down.sql", name)
+		}
+		versionStr, title, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, versionStr)
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+			found[version] = map[string]bool{}
+		}
+		found[version][direction] = true
+		if direction == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if !found[migration.Version]["up"] || !found[migration.Version]["down"] {
+			return nil, fmt.Errorf("migration %d_%s: both up and down files are required",
+				migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	if err := m.ensureTable(ctx, m.DB); err != nil {
+		return MigrationStatus{}, err
+	}
+	return m.status(ctx, m.DB)
+}
+
+// Up применяет все еще не примененные миграции. Каждая выполняется в своей транзакции
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range status.Pending {
+			if err := m.apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает steps последних примененных миграций
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		version := status.Version
+		for range steps {
+			if version == 0 {
+				return nil
+			}
+			index := m.index(version)
+			if index < 0 {
+				return fmt.Errorf("database version %d is unknown to this binary", version)
+			}
+			migration := m.Migrations[index]
+			var previous int64
+			if index > 0 {
+				previous = m.Migrations[index-1].Version
+			}
+			if err := m.apply(ctx, conn, migration.Down, previous); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+			version = previous
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// CheckVersion возвращает ошибку, если схема базы не совпадает с последней встроенной миграцией
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("database schema version %d is dirty, fix it manually", status.Version)
+	}
+	if status.Version != status.Latest {
+		return fmt.Errorf("database schema version %d, expected %d: run \"migrate up\"",
+			status.Version, status.Latest)
+	}
+	return nil
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db queryer) error {
+	_, err := db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`)
+	return err
+}
+
+func (m *Migrator) status(ctx context.Context, db queryer) (MigrationStatus, error) {
+	status := MigrationStatus{Latest: m.Latest()}
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).
+		Scan(&status.Version, &status.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return status, err
+	}
+	for _, migration := range m.Migrations {
+		if migration.Version > status.Version {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// apply выполняет SQL миграции и записывает новую версию в одной транзакции
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if strings.TrimSpace(query) != "" {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version > 0 {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// withLock выполняет fn на отдельном соединении под pg_advisory_lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	status, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("database schema version %d is dirty, fix it manually", status.Version)
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// String возвращает имя файла миграции без направления
+func (m Migration) String() string {
+	return fmt.Sprintf("%06d_%s", m.Version, m.Name)
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"github.com/AnVladic/DentalTelegramBot/migrations"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseEmbeddedMigrations(t *testing.T) {
+	parsed, err := ParseMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, parsed)
+	for i, migration := range parsed {
+		require.Equal(t, int64(i+1), migration.Version, "migrations must be numbered without gaps")
+	}
+	require.Equal(t, "000001_user", parsed[0].String())
+}
+
+func TestParseMigrationsErrors(t *testing.T) {
+	_, err := ParseMigrations(fstest.MapFS{
+		"000001_user.up.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+	})
+	require.ErrorContains(t, err, "both up and down files are required")
+
+	_, err = ParseMigrations(fstest.MapFS{
+		"user.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+		"user.down.sql": {Data: []byte("DROP TABLE a;")},
+	})
+	require.ErrorContains(t, err, "invalid version")
+
+	parsed, err := ParseMigrations(fstest.MapFS{
+		"000002_b.up.sql":   {Data: []byte("up b")},
+		"000002_b.down.sql": {Data: []byte("down b")},
+		"000001_a.up.sql":   {Data: []byte("up a")},
+		"000001_a.down.sql": {Data: []byte("down a")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Migration{
+		{Version: 1, Name: "a", Up: "up a", Down: "down a"},
+		{Version: 2, Name: "b", Up: "up b", Down: "down b"},
+	}, parsed)
+}
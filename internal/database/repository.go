@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 )
 
 type UserRepository struct {
@@ -18,13 +20,41 @@ type DoctorRepository struct {
 	DB *sql.DB
 }
 
-func (r *UserRepository) CreateUser(user *User) error {
+type ReminderRepository struct {
+	DB *sql.DB
+}
+
+type RecordConfirmationRepository struct {
+	DB *sql.DB
+}
+
+type ChatStateRepository struct {
+	DB *sql.DB
+}
+
+type StatsRepository struct {
+	DB *sql.DB
+}
+
+type BroadcastRepository struct {
+	DB *sql.DB
+}
+
+type BookingRepository struct {
+	DB *sql.DB
+}
+
+type WaitlistRepository struct {
+	DB *sql.DB
+}
+
+func (r *UserRepository) CreateUser(ctx context.Context, user *User) error {
 	query := `
         INSERT INTO "User" (tg_user_id, name, lastname, phone)
         VALUES ($1, $2, $3, $4)
         RETURNING id;
     `
-	err := r.DB.QueryRow(
+	err := r.DB.QueryRowContext(ctx,
 		query, user.TgUserID, user.Name, user.Lastname, normalizePhone(user.Phone)).Scan(&user.ID)
 	if err != nil {
 		return err
@@ -32,14 +62,14 @@ func (r *UserRepository) CreateUser(user *User) error {
 	return nil
 }
 
-func (r *UserRepository) GetUserByTelegramID(tgUserID int64) (*User, error) {
+func (r *UserRepository) GetUserByTelegramID(ctx context.Context, tgUserID int64) (*User, error) {
 	query := `
         SELECT id, tg_user_id, dental_pro_id, name, lastname, phone, created_at
         FROM "User"
         WHERE tg_user_id = $1;
     `
 	user := &User{}
-	err := r.DB.QueryRow(query, tgUserID).Scan(
+	err := r.DB.QueryRowContext(ctx, query, tgUserID).Scan(
 		&user.ID, &user.TgUserID, &user.DentalProID, &user.Name, &user.Lastname, &user.Phone, &user.CreatedAt,
 	)
 	if err != nil {
@@ -48,10 +78,10 @@ func (r *UserRepository) GetUserByTelegramID(tgUserID int64) (*User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) GetOrCreateByTelegramID(user User) (*User, bool, error) {
-	oldUser, err := r.GetUserByTelegramID(user.TgUserID)
+func (r *UserRepository) GetOrCreateByTelegramID(ctx context.Context, user User) (*User, bool, error) {
+	oldUser, err := r.GetUserByTelegramID(ctx, user.TgUserID)
 	if errors.Is(err, sql.ErrNoRows) {
-		err := r.CreateUser(&user)
+		err := r.CreateUser(ctx, &user)
 		if err != nil {
 			return nil, false, err
 		}
@@ -60,7 +90,7 @@ func (r *UserRepository) GetOrCreateByTelegramID(user User) (*User, bool, error)
 	return oldUser, false, nil
 }
 
-func (r *UserRepository) UpsertContactByTelegramID(tgUserID int64, firstName, lastName, phone string) error {
+func (r *UserRepository) UpsertContactByTelegramID(ctx context.Context, tgUserID int64, firstName, lastName, phone string) error {
 	query := `
         INSERT INTO "User" (tg_user_id, phone, name, lastname)
         VALUES ($1, $2, $3, $4)
@@ -71,80 +101,210 @@ func (r *UserRepository) UpsertContactByTelegramID(tgUserID int64, firstName, la
             lastname = EXCLUDED.lastname;
     `
 
-	_, err := r.DB.Exec(query, tgUserID, normalizePhone(&phone), firstName, lastName)
+	_, err := r.DB.ExecContext(ctx, query, tgUserID, normalizePhone(&phone), firstName, lastName)
 	return err
 }
 
-func (r *UserRepository) UpdateLastName(tgUserID int64, lastName string) error {
+func (r *UserRepository) UpdateLastName(ctx context.Context, tgUserID int64, lastName string) error {
 	query := `
         UPDATE "User"
 		SET lastname = ($1)
 		WHERE tg_user_id = ($2);
     `
-	_, err := r.DB.Exec(query, lastName, tgUserID)
+	_, err := r.DB.ExecContext(ctx, query, lastName, tgUserID)
 	return err
 }
 
-func (r *UserRepository) UpdateFirstName(tgUserID int64, firstName string) error {
+func (r *UserRepository) UpdateFirstName(ctx context.Context, tgUserID int64, firstName string) error {
 	query := `
         UPDATE "User"
 		SET name = ($1)
 		WHERE tg_user_id = ($2);
     `
-	_, err := r.DB.Exec(query, firstName, tgUserID)
+	_, err := r.DB.ExecContext(ctx, query, firstName, tgUserID)
 	return err
 }
 
-func (r *UserRepository) UpdateDentalProIDByTelegramID(tgUserID int64, dentalProID int64) error {
+func (r *UserRepository) UpdateDentalProIDByTelegramID(ctx context.Context, tgUserID int64, dentalProID int64) error {
 	query := `
         UPDATE "User"
 		SET dental_pro_id = ($1)
 		WHERE tg_user_id = ($2);
     `
 
-	_, err := r.DB.Exec(query, dentalProID, tgUserID)
+	_, err := r.DB.ExecContext(ctx, query, dentalProID, tgUserID)
+	return err
+}
+
+func (r *UserRepository) ListWithDentalProID(ctx context.Context) ([]User, error) {
+	query := `
+        SELECT id, tg_user_id, dental_pro_id, name, lastname, phone, created_at
+        FROM "User"
+        WHERE dental_pro_id IS NOT NULL
+        ORDER BY id;
+    `
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var users []User
+	for rows.Next() {
+		user := User{}
+		err := rows.Scan(
+			&user.ID, &user.TgUserID, &user.DentalProID, &user.Name, &user.Lastname, &user.Phone, &user.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepository) List(ctx context.Context) ([]User, error) {
+	query := `
+        SELECT id, tg_user_id, dental_pro_id, name, lastname, phone, created_at
+        FROM "User"
+        ORDER BY id;
+    `
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var users []User
+	for rows.Next() {
+		user := User{}
+		err := rows.Scan(
+			&user.ID, &user.TgUserID, &user.DentalProID, &user.Name, &user.Lastname, &user.Phone, &user.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// MarkBlocked отмечает пользователя, заблокировавшего бота. Такие пользователи не попадают в рассылки
+func (r *UserRepository) MarkBlocked(ctx context.Context, userID int64, blockedAt time.Time) error {
+	query := `
+        UPDATE "User"
+        SET blocked_at = $1
+        WHERE id = $2;
+    `
+	_, err := r.DB.ExecContext(ctx, query, blockedAt, userID)
+	return err
+}
+
+func (r *UserRepository) ClearBlocked(ctx context.Context, tgUserID int64) error {
+	query := `
+        UPDATE "User"
+        SET blocked_at = NULL
+        WHERE tg_user_id = $1 AND blocked_at IS NOT NULL;
+    `
+	_, err := r.DB.ExecContext(ctx, query, tgUserID)
 	return err
 }
 
+// GetLanguage возвращает выбранный пользователем язык или nil, если он не выбран
+func (r *UserRepository) GetLanguage(ctx context.Context, tgUserID int64) (*string, error) {
+	query := `
+        SELECT language
+        FROM "User"
+        WHERE tg_user_id = $1;
+    `
+	var language *string
+	err := r.DB.QueryRowContext(ctx, query, tgUserID).Scan(&language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user language: %w", err)
+	}
+	return language, nil
+}
+
+func (r *UserRepository) SetLanguage(ctx context.Context, tgUserID int64, language string) error {
+	query := `
+        UPDATE "User"
+        SET language = $1
+        WHERE tg_user_id = $2;
+    `
+	_, err := r.DB.ExecContext(ctx, query, language, tgUserID)
+	if err != nil {
+		return fmt.Errorf("failed to set user language: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) GetByDentalProID(ctx context.Context, dentalProID int64) (*User, error) {
+	query := `
+        SELECT id, tg_user_id, dental_pro_id, name, lastname, phone, created_at
+        FROM "User"
+        WHERE dental_pro_id = $1
+        LIMIT 1;
+    `
+	user := &User{}
+	err := r.DB.QueryRowContext(ctx, query, dentalProID).Scan(
+		&user.ID, &user.TgUserID, &user.DentalProID, &user.Name, &user.Lastname, &user.Phone, &user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (r *RegisterRepository) ScanAll(row *sql.Row, register *Register) error {
 	return row.Scan(
 		&register.ID, &register.UserID, &register.MessageID, &register.ChatID,
-		&register.DoctorID, &register.AppointmentID, &register.Datetime,
+		&register.DoctorID, &register.AppointmentID, &register.Datetime, &register.MoveRecordID,
+		&register.BranchID, &register.Source,
 	)
 }
 
-func (r *RegisterRepository) Get(userID int64, chatID int64, messageID int) (*Register, error) {
+func (r *RegisterRepository) Get(ctx context.Context, userID int64, chatID int64, messageID int) (*Register, error) {
 	query := `
-        SELECT id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime
+        SELECT id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id, source
         FROM "Register"
         WHERE user_id = $1 and chat_id = $2 and message_id = $3;
     `
 	register := &Register{}
-	err := r.ScanAll(r.DB.QueryRow(query, userID, chatID, messageID), register)
+	err := r.ScanAll(r.DB.QueryRowContext(ctx, query, userID, chatID, messageID), register)
 	if err != nil {
 		return nil, err
 	}
 	return register, nil
 }
 
-func (r *RegisterRepository) Create(register *Register) error {
+func (r *RegisterRepository) Create(ctx context.Context, register *Register) error {
 	query := `
-        INSERT INTO "Register" (user_id, message_id, chat_id, doctor_id, appointment_id, datetime)
-        VALUES ($1, $2, $3, $4, $5, $6)
+        INSERT INTO "Register" (
+            user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
         RETURNING id;
     `
-	err := r.DB.QueryRow(query, register.UserID, register.MessageID, register.ChatID,
-		register.DoctorID, register.AppointmentID, register.Datetime).Scan(&register.ID)
+	err := r.DB.QueryRowContext(ctx, query, register.UserID, register.MessageID, register.ChatID,
+		register.DoctorID, register.AppointmentID, register.Datetime, register.MoveRecordID,
+		register.BranchID).Scan(&register.ID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *RegisterRepository) GetOrCreate(register Register) (*Register, bool, error) {
-	oldRegister, err := r.Get(register.UserID, register.ChatID, register.MessageID)
+func (r *RegisterRepository) GetOrCreate(ctx context.Context, register Register) (*Register, bool, error) {
+	oldRegister, err := r.Get(ctx, register.UserID, register.ChatID, register.MessageID)
 	if errors.Is(err, sql.ErrNoRows) {
-		err := r.Create(&register)
+		err := r.Create(ctx, &register)
 		if err != nil {
 			return nil, false, err
 		}
@@ -153,17 +313,17 @@ func (r *RegisterRepository) GetOrCreate(register Register) (*Register, bool, er
 	return oldRegister, false, nil
 }
 
-func (r *RegisterRepository) UpsertDoctorID(register Register) (*Register, error) {
+func (r *RegisterRepository) UpsertDoctorID(ctx context.Context, register Register) (*Register, error) {
 	query := `
         INSERT INTO "Register" (user_id, message_id, chat_id, doctor_id, appointment_id, datetime)
         VALUES ($1, $2, $3, $4, $5, $6)
         ON CONFLICT (user_id, message_id, chat_id) DO UPDATE
         SET doctor_id = EXCLUDED.doctor_id
-        RETURNING id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime;
+        RETURNING id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id, source;
     `
 
 	updatedRegister := &Register{}
-	err := r.ScanAll(r.DB.QueryRow(query,
+	err := r.ScanAll(r.DB.QueryRowContext(ctx, query,
 		register.UserID, register.MessageID, register.ChatID,
 		register.DoctorID, register.AppointmentID, register.Datetime),
 		updatedRegister)
@@ -174,59 +334,130 @@ func (r *RegisterRepository) UpsertDoctorID(register Register) (*Register, error
 	return updatedRegister, nil
 }
 
-func (r *RegisterRepository) UpdateAppointmentID(register Register) error {
+// UpsertMoveRecord начинает перенос записи: врач и прием берутся из переносимой записи,
+// выбранная ранее дата сбрасывается
+func (r *RegisterRepository) UpsertMoveRecord(ctx context.Context, register Register) (*Register, error) {
+	query := `
+        INSERT INTO "Register" (
+            user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id, source
+        )
+        VALUES ($1, $2, $3, $4, $5, NULL, $6, $7, $8)
+        ON CONFLICT (user_id, message_id, chat_id) DO UPDATE
+        SET doctor_id = EXCLUDED.doctor_id,
+            appointment_id = EXCLUDED.appointment_id,
+            datetime = NULL,
+            move_record_id = EXCLUDED.move_record_id,
+            branch_id = EXCLUDED.branch_id,
+            source = EXCLUDED.source
+        RETURNING id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id, source;
+    `
+
+	updatedRegister := &Register{}
+	err := r.ScanAll(r.DB.QueryRowContext(ctx, query,
+		register.UserID, register.MessageID, register.ChatID,
+		register.DoctorID, register.AppointmentID, register.MoveRecordID, register.BranchID,
+		register.Source),
+		updatedRegister)
+	if err != nil {
+		return &Register{}, fmt.Errorf("failed to upsert move register: %w", err)
+	}
+
+	return updatedRegister, nil
+}
+
+// UpsertBranchID начинает запись в выбранный филиал, сбрасывая выбранные ранее врача, прием и время
+func (r *RegisterRepository) UpsertBranchID(ctx context.Context, register Register) (*Register, error) {
+	query := `
+        INSERT INTO "Register" (user_id, message_id, chat_id, branch_id)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, message_id, chat_id) DO UPDATE
+        SET branch_id = EXCLUDED.branch_id,
+            doctor_id = NULL,
+            appointment_id = NULL,
+            datetime = NULL,
+            move_record_id = NULL,
+            source = DEFAULT
+        RETURNING id, user_id, message_id, chat_id, doctor_id, appointment_id, datetime, move_record_id, branch_id, source;
+    `
+
+	updatedRegister := &Register{}
+	err := r.ScanAll(r.DB.QueryRowContext(ctx, query,
+		register.UserID, register.MessageID, register.ChatID, register.BranchID),
+		updatedRegister)
+	if err != nil {
+		return &Register{}, fmt.Errorf("failed to upsert register branch: %w", err)
+	}
+
+	return updatedRegister, nil
+}
+
+func (r *RegisterRepository) UpdateAppointmentID(ctx context.Context, register Register) error {
 	query := `
         UPDATE "Register"
 		SET appointment_id = ($1)
 		WHERE user_id = ($2) and chat_id = ($3) and message_id = ($4);
     `
 
-	_, err := r.DB.Exec(query, register.AppointmentID, register.UserID, register.ChatID, register.MessageID)
+	_, err := r.DB.ExecContext(ctx, query, register.AppointmentID, register.UserID, register.ChatID, register.MessageID)
 	return err
 }
 
-func (r *RegisterRepository) UpdateDatetime(register Register) error {
+func (r *RegisterRepository) UpdateDatetime(ctx context.Context, register Register) error {
 	query := `
         UPDATE "Register"
 		SET datetime = ($1)
 		WHERE user_id = ($2) and chat_id = ($3) and message_id = ($4);
     `
 
-	_, err := r.DB.Exec(query, register.Datetime, register.UserID, register.ChatID, register.MessageID)
+	_, err := r.DB.ExecContext(ctx, query, register.Datetime, register.UserID, register.ChatID, register.MessageID)
 	return err
 }
 
-func (r *DoctorRepository) Get(id int64) (*Doctor, error) {
+// MarkBooked отмечает, что по выбору пользователя в CRM создана запись recordID
+func (r *RegisterRepository) MarkBooked(ctx context.Context, registerID, recordID int64, bookedAt time.Time) error {
+	query := `
+        UPDATE "Register"
+        SET record_id = $1, booked_at = $2
+        WHERE id = $3;
+    `
+	_, err := r.DB.ExecContext(ctx, query, recordID, bookedAt, registerID)
+	if err != nil {
+		return fmt.Errorf("failed to mark register booked: %w", err)
+	}
+	return nil
+}
+
+func (r *DoctorRepository) Get(ctx context.Context, id int64) (*Doctor, error) {
 	query := `
         SELECT id, fio
         FROM "Doctor"
         WHERE id = $1;
     `
 	doctor := &Doctor{}
-	err := r.DB.QueryRow(query, id).Scan(&doctor.ID, &doctor.FIO)
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&doctor.ID, &doctor.FIO)
 	if err != nil {
 		return nil, err
 	}
 	return doctor, nil
 }
 
-func (r *DoctorRepository) Create(doctor *Doctor) error {
+func (r *DoctorRepository) Create(ctx context.Context, doctor *Doctor) error {
 	query := `
         INSERT INTO "Doctor" (id, fio)
         VALUES ($1, $2)
         RETURNING id;
     `
-	err := r.DB.QueryRow(query, doctor.ID, doctor.FIO).Scan(&doctor.ID)
+	err := r.DB.QueryRowContext(ctx, query, doctor.ID, doctor.FIO).Scan(&doctor.ID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *DoctorRepository) GetOrCreate(doctor Doctor) (*Doctor, bool, error) {
-	oldRegister, err := r.Get(doctor.ID)
+func (r *DoctorRepository) GetOrCreate(ctx context.Context, doctor Doctor) (*Doctor, bool, error) {
+	oldRegister, err := r.Get(ctx, doctor.ID)
 	if errors.Is(err, sql.ErrNoRows) {
-		err := r.Create(&doctor)
+		err := r.Create(ctx, &doctor)
 		if err != nil {
 			return nil, false, err
 		}
@@ -235,16 +466,566 @@ func (r *DoctorRepository) GetOrCreate(doctor Doctor) (*Doctor, bool, error) {
 	return oldRegister, false, nil
 }
 
-func (r *DoctorRepository) Upsert(doctor Doctor) error {
+func (r *DoctorRepository) Upsert(ctx context.Context, doctor Doctor) error {
 	query := `
         INSERT INTO "Doctor" (id, fio)
         VALUES ($1, $2)
         ON CONFLICT (id) DO UPDATE
         SET fio = EXCLUDED.fio;
     `
-	_, err := r.DB.Exec(query, doctor.ID, doctor.FIO)
+	_, err := r.DB.ExecContext(ctx, query, doctor.ID, doctor.FIO)
 	if err != nil {
 		return fmt.Errorf("failed to upsert doctor: %w", err)
 	}
 	return nil
 }
+
+// Create сохраняет отправку напоминания. Возвращает false, если напоминание уже было записано
+func (r *ReminderRepository) Create(ctx context.Context, reminder *Reminder) (bool, error) {
+	query := `
+        INSERT INTO "Reminder" (user_id, record_id, offset_minutes)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (record_id, offset_minutes) DO NOTHING
+        RETURNING id, sent_at;
+    `
+	err := r.DB.QueryRowContext(ctx, query, reminder.UserID, reminder.RecordID, reminder.OffsetMinutes).Scan(
+		&reminder.ID, &reminder.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create reminder: %w", err)
+	}
+	return true, nil
+}
+
+func (r *ReminderRepository) Delete(ctx context.Context, id int64) error {
+	query := `
+        DELETE FROM "Reminder"
+        WHERE id = $1;
+    `
+	_, err := r.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Upsert сохраняет статус записи. UpdatedAt передается в UTC, как и время, с которым его сравнивает StatsRepository
+func (r *RecordConfirmationRepository) Upsert(ctx context.Context, confirmation RecordConfirmation) error {
+	query := `
+        INSERT INTO "RecordConfirmation" (user_id, record_id, status, updated_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (record_id) DO UPDATE
+        SET status = EXCLUDED.status,
+            updated_at = EXCLUDED.updated_at;
+    `
+	_, err := r.DB.ExecContext(ctx, query,
+		confirmation.UserID, confirmation.RecordID, confirmation.Status, confirmation.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert record confirmation: %w", err)
+	}
+	return nil
+}
+
+func (r *RecordConfirmationRepository) GetByRecordID(ctx context.Context, recordID int64) (*RecordConfirmation, error) {
+	query := `
+        SELECT id, user_id, record_id, status, updated_at
+        FROM "RecordConfirmation"
+        WHERE record_id = $1;
+    `
+	confirmation := &RecordConfirmation{}
+	err := r.DB.QueryRowContext(ctx, query, recordID).Scan(
+		&confirmation.ID, &confirmation.UserID, &confirmation.RecordID,
+		&confirmation.Status, &confirmation.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return confirmation, nil
+}
+
+func (r *ChatStateRepository) Get(ctx context.Context, chatID int64) (*ChatState, error) {
+	query := `
+        SELECT chat_id, step, payload, updated_at
+        FROM "ChatState"
+        WHERE chat_id = $1;
+    `
+	state := &ChatState{}
+	err := r.DB.QueryRowContext(ctx, query, chatID).Scan(&state.ChatID, &state.Step, &state.Payload, &state.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (r *ChatStateRepository) Upsert(ctx context.Context, state ChatState) error {
+	query := `
+        INSERT INTO "ChatState" (chat_id, step, payload, updated_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (chat_id) DO UPDATE
+        SET step = EXCLUDED.step,
+            payload = EXCLUDED.payload,
+            updated_at = EXCLUDED.updated_at;
+    `
+	_, err := r.DB.ExecContext(ctx, query, state.ChatID, state.Step, state.Payload, state.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert chat state: %w", err)
+	}
+	return nil
+}
+
+func (r *ChatStateRepository) Delete(ctx context.Context, chatID int64) error {
+	query := `
+        DELETE FROM "ChatState"
+        WHERE chat_id = $1;
+    `
+	_, err := r.DB.ExecContext(ctx, query, chatID)
+	return err
+}
+
+func (r *ChatStateRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM "ChatState";`).Scan(&count)
+	return count, err
+}
+
+func (r *ChatStateRepository) DeleteOlderThan(ctx context.Context, t time.Time) error {
+	query := `
+        DELETE FROM "ChatState"
+        WHERE updated_at < $1;
+    `
+	_, err := r.DB.ExecContext(ctx, query, t)
+	return err
+}
+
+// Get собирает статистику; Booked считает записи, созданные начиная с since
+func (r *StatsRepository) Get(ctx context.Context, since time.Time) (*Stats, error) {
+	stats := &Stats{Confirmations: map[string]int{}}
+	query := `
+        SELECT
+            (SELECT COUNT(*) FROM "User"),
+            (SELECT COUNT(*) FROM "User" WHERE dental_pro_id IS NOT NULL),
+            (SELECT COUNT(*) FROM "Booking" WHERE action IN ($2, $3) AND created_at >= $1);
+    `
+	err := r.DB.QueryRowContext(ctx, query, since, BookingCreated, BookingRescheduled).Scan(&stats.Users, &stats.Patients, &stats.Booked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+        SELECT status, COUNT(*)
+        FROM "RecordConfirmation"
+        WHERE updated_at >= $1
+        GROUP BY status;
+    `, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get confirmation stats: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats.Confirmations[status] = count
+	}
+	return stats, rows.Err()
+}
+
+// Create создает рассылку и ожидающие доставки для всех пользователей, не заблокировавших бота
+func (r *BroadcastRepository) Create(ctx context.Context, broadcast *Broadcast) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin broadcast transaction: %w", err)
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO "Broadcast" (admin_chat_id, text, status)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at;
+    `, broadcast.AdminChatID, broadcast.Text, broadcast.Status).Scan(&broadcast.ID, &broadcast.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create broadcast: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO "BroadcastDelivery" (broadcast_id, user_id, status)
+        SELECT $1, id, $2
+        FROM "User"
+        WHERE blocked_at IS NULL;
+    `, broadcast.ID, DeliveryPending)
+	if err != nil {
+		return fmt.Errorf("failed to create broadcast deliveries: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *BroadcastRepository) scan(row interface{ Scan(dest ...any) error }) (*Broadcast, error) {
+	broadcast := &Broadcast{}
+	err := row.Scan(
+		&broadcast.ID, &broadcast.AdminChatID, &broadcast.Text, &broadcast.Status,
+		&broadcast.CreatedAt, &broadcast.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+func (r *BroadcastRepository) Get(ctx context.Context, id int64) (*Broadcast, error) {
+	query := `
+        SELECT id, admin_chat_id, text, status, created_at, finished_at
+        FROM "Broadcast"
+        WHERE id = $1;
+    `
+	return r.scan(r.DB.QueryRowContext(ctx, query, id))
+}
+
+func (r *BroadcastRepository) GetLast(ctx context.Context) (*Broadcast, error) {
+	query := `
+        SELECT id, admin_chat_id, text, status, created_at, finished_at
+        FROM "Broadcast"
+        ORDER BY id DESC
+        LIMIT 1;
+    `
+	return r.scan(r.DB.QueryRowContext(ctx, query))
+}
+
+func (r *BroadcastRepository) ListByStatus(ctx context.Context, status string) ([]Broadcast, error) {
+	query := `
+        SELECT id, admin_chat_id, text, status, created_at, finished_at
+        FROM "Broadcast"
+        WHERE status = $1
+        ORDER BY id;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list broadcasts: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var broadcasts []Broadcast
+	for rows.Next() {
+		broadcast, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, *broadcast)
+	}
+	return broadcasts, rows.Err()
+}
+
+func (r *BroadcastRepository) SetStatus(ctx context.Context, id int64, status string) error {
+	query := `
+        UPDATE "Broadcast"
+        SET status = $1,
+            finished_at = CASE WHEN $2 THEN CURRENT_TIMESTAMP ELSE NULL END
+        WHERE id = $3;
+    `
+	_, err := r.DB.ExecContext(ctx, query, status, status == BroadcastFinished, id)
+	if err != nil {
+		return fmt.Errorf("failed to set broadcast status: %w", err)
+	}
+	return nil
+}
+
+// Finish завершает рассылку, только если она еще выполняется: пауза, поставленная после
+// последней отправки, не перезаписывается. Возвращает false, если статус не изменился
+func (r *BroadcastRepository) Finish(ctx context.Context, id int64) (bool, error) {
+	query := `
+        UPDATE "Broadcast"
+        SET status = $1,
+            finished_at = CURRENT_TIMESTAMP
+        WHERE id = $2 AND status = $3;
+    `
+	result, err := r.DB.ExecContext(ctx, query, BroadcastFinished, id, BroadcastRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to finish broadcast: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *BroadcastRepository) ListPending(ctx context.Context, broadcastID int64, limit int) ([]BroadcastDelivery, error) {
+	query := `
+        SELECT d.id, d.broadcast_id, d.user_id, u.tg_user_id, d.status, d.error
+        FROM "BroadcastDelivery" d
+        JOIN "User" u ON u.id = d.user_id
+        WHERE d.broadcast_id = $1 AND d.status = $2
+        ORDER BY d.id
+        LIMIT $3;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, broadcastID, DeliveryPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending deliveries: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var deliveries []BroadcastDelivery
+	for rows.Next() {
+		delivery := BroadcastDelivery{}
+		err := rows.Scan(
+			&delivery.ID, &delivery.BroadcastID, &delivery.UserID, &delivery.TgUserID,
+			&delivery.Status, &delivery.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *BroadcastRepository) UpdateDelivery(ctx context.Context, id int64, status string, deliveryErr *string) error {
+	query := `
+        UPDATE "BroadcastDelivery"
+        SET status = $1, error = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3;
+    `
+	_, err := r.DB.ExecContext(ctx, query, status, deliveryErr, id)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	return nil
+}
+
+func (r *BroadcastRepository) CountByStatus(ctx context.Context, broadcastID int64) (map[string]int, error) {
+	query := `
+        SELECT status, COUNT(*)
+        FROM "BroadcastDelivery"
+        WHERE broadcast_id = $1
+        GROUP BY status;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, broadcastID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count deliveries: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// Create сохраняет действие с записью. CreatedAt передается в UTC, как и время, с которым его сравнивает ListBookedSince
+func (r *BookingRepository) Create(ctx context.Context, booking *Booking) error {
+	query := `
+        INSERT INTO "Booking" (
+            user_id, record_id, action, source, doctor_id, appointment_id, branch_id, datetime, previous_record_id,
+            created_at
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id;
+    `
+	err := r.DB.QueryRowContext(ctx, query,
+		booking.UserID, booking.RecordID, booking.Action, booking.Source, booking.DoctorID,
+		booking.AppointmentID, booking.BranchID, booking.Datetime, booking.PreviousRecordID, booking.CreatedAt,
+	).Scan(&booking.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create booking: %w", err)
+	}
+	return nil
+}
+
+// ListBookedSince возвращает записи, созданные или перенесенные через бота начиная с since,
+// вместе с пациентом и врачом
+func (r *BookingRepository) ListBookedSince(ctx context.Context, since time.Time) ([]BookedRecord, error) {
+	query := `
+        SELECT b.id, b.record_id, b.action, u.tg_user_id, u.name, u.lastname, u.phone, d.fio, b.datetime, b.created_at
+        FROM "Booking" b
+        JOIN "User" u ON u.id = b.user_id
+        LEFT JOIN "Doctor" d ON d.id = b.doctor_id
+        WHERE b.action IN ($1, $2) AND b.created_at >= $3
+        ORDER BY b.created_at, b.id;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, BookingCreated, BookingRescheduled, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bookings: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var records []BookedRecord
+	for rows.Next() {
+		record := BookedRecord{}
+		err := rows.Scan(
+			&record.BookingID, &record.RecordID, &record.Action, &record.TgUserID, &record.Name, &record.Lastname,
+			&record.Phone, &record.DoctorFIO, &record.Datetime, &record.BookedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Join ставит пользователя в лист ожидания. Если он уже ждет того же приема, обновляется только
+// диапазон дат, а место в очереди сохраняется
+func (r *WaitlistRepository) Join(ctx context.Context, entry *WaitlistEntry) error {
+	query := `
+        INSERT INTO "Waitlist" (
+            user_id, chat_id, doctor_id, appointment_id, branch_id, date_from, date_to, status
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (user_id, doctor_id, (COALESCE(appointment_id, 0)), branch_id) WHERE status = 'waiting'
+        DO UPDATE SET chat_id = EXCLUDED.chat_id, date_from = EXCLUDED.date_from, date_to = EXCLUDED.date_to
+        RETURNING id, status, created_at;
+    `
+	err := r.DB.QueryRowContext(ctx, query,
+		entry.UserID, entry.ChatID, entry.DoctorID, entry.AppointmentID, entry.BranchID,
+		entry.DateFrom, entry.DateTo, WaitlistWaiting,
+	).Scan(&entry.ID, &entry.Status, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to join waitlist: %w", err)
+	}
+	return nil
+}
+
+// ListWaiting возвращает ожидающих в порядке очереди. Уведомленные после notifiedBefore пропускаются,
+// пока не истечет пауза между уведомлениями
+func (r *WaitlistRepository) ListWaiting(ctx context.Context, notifiedBefore time.Time) ([]WaitlistEntry, error) {
+	query := `
+        SELECT w.id, w.user_id, u.tg_user_id, u.dental_pro_id, w.chat_id, w.doctor_id, w.appointment_id,
+               w.branch_id, w.date_from, w.date_to, w.status, w.slot, w.notified_at, w.created_at
+        FROM "Waitlist" w
+        JOIN "User" u ON u.id = w.user_id
+        WHERE w.status = $1 AND (w.notified_at IS NULL OR w.notified_at < $2)
+        ORDER BY w.created_at, w.id;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, WaitlistWaiting, notifiedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list waitlist: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		entry := WaitlistEntry{}
+		err := rows.Scan(
+			&entry.ID, &entry.UserID, &entry.TgUserID, &entry.DentalProID, &entry.ChatID, &entry.DoctorID,
+			&entry.AppointmentID, &entry.BranchID, &entry.DateFrom, &entry.DateTo, &entry.Status,
+			&entry.Slot, &entry.NotifiedAt, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// ListHeldSlots возвращает время, предложенное ожидающим начиная с since.
+// Пока предложение в силе, это время не предлагается следующим в очереди
+func (r *WaitlistRepository) ListHeldSlots(
+	ctx context.Context, doctorID, appointmentID, branchID int64, since time.Time,
+) ([]time.Time, error) {
+	query := `
+        SELECT slot
+        FROM "Waitlist"
+        WHERE status = $1 AND doctor_id = $2 AND appointment_id = $3 AND branch_id = $4
+          AND slot IS NOT NULL AND notified_at >= $5;
+    `
+	rows, err := r.DB.QueryContext(ctx, query, WaitlistWaiting, doctorID, appointmentID, branchID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held waitlist slots: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var slots []time.Time
+	for rows.Next() {
+		var slot time.Time
+		if err := rows.Scan(&slot); err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, rows.Err()
+}
+
+// MarkNotified запоминает предложенное время. Пользователь остается в очереди, пока не запишется
+func (r *WaitlistRepository) MarkNotified(ctx context.Context, id int64, slot *time.Time, notifiedAt time.Time) error {
+	query := `
+        UPDATE "Waitlist"
+        SET slot = $1, notified_at = $2
+        WHERE id = $3;
+    `
+	_, err := r.DB.ExecContext(ctx, query, slot, notifiedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark waitlist notified: %w", err)
+	}
+	return nil
+}
+
+// MarkBooked завершает ожидания пользователя, которые закрывает запись к врачу на прием appointmentID:
+// ожидания этого приема и любого приема у врача в филиале
+func (r *WaitlistRepository) MarkBooked(ctx context.Context, userID, doctorID, appointmentID, branchID int64) error {
+	query := `
+        UPDATE "Waitlist"
+        SET status = $1
+        WHERE status = $2 AND user_id = $3 AND doctor_id = $4 AND branch_id = $5
+          AND (appointment_id IS NULL OR appointment_id = $6);
+    `
+	_, err := r.DB.ExecContext(ctx, query, WaitlistBooked, WaitlistWaiting, userID, doctorID, branchID, appointmentID)
+	if err != nil {
+		return fmt.Errorf("failed to mark waitlist booked: %w", err)
+	}
+	return nil
+}
+
+// Cancel убирает пользователя из листа ожидания. Возвращает false, если ожидание уже завершено
+func (r *WaitlistRepository) Cancel(ctx context.Context, id, userID int64) (bool, error) {
+	query := `
+        UPDATE "Waitlist"
+        SET status = $1
+        WHERE id = $2 AND user_id = $3 AND status = $4;
+    `
+	result, err := r.DB.ExecContext(ctx, query, WaitlistCancelled, id, userID, WaitlistWaiting)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel waitlist: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ExpireBefore завершает ожидания, диапазон которых закончился раньше date
+func (r *WaitlistRepository) ExpireBefore(ctx context.Context, date time.Time) error {
+	query := `
+        UPDATE "Waitlist"
+        SET status = $1
+        WHERE status = $2 AND date_to < $3;
+    `
+	_, err := r.DB.ExecContext(ctx, query, WaitlistExpired, WaitlistWaiting, date)
+	if err != nil {
+		return fmt.Errorf("failed to expire waitlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/AnVladic/DentalTelegramBot/migrations"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestDB подключается к TEST_DATABASE_URL и применяет миграции. Без нее тест пропускается
+func openTestDB(t *testing.T) *sql.DB {
+	err := godotenv.Load("../../configs/.env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("error loading .env file: %v", err)
+	}
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("pgx", databaseURL)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator, err := NewMigrator(db, migrations.FS)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+func TestBookingListBookedSince(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	name, phone := "Иван", "+79990000000"
+	user := &User{TgUserID: 990000001, Name: &name, Phone: &phone}
+	userRepo := UserRepository{DB: db}
+	require.NoError(t, userRepo.CreateUser(ctx, user))
+	t.Cleanup(func() {
+		_, _ = db.Exec(`DELETE FROM "User" WHERE id = $1`, user.ID)
+	})
+	doctor := Doctor{ID: 990000001, FIO: "Петров Петр Петрович"}
+	require.NoError(t, (&DoctorRepository{DB: db}).Upsert(ctx, doctor))
+	t.Cleanup(func() {
+		_, _ = db.Exec(`DELETE FROM "Doctor" WHERE id = $1`, doctor.ID)
+	})
+
+	since := time.Date(2024, 11, 9, 0, 0, 0, 0, time.UTC)
+	datetime := time.Date(2024, 11, 12, 10, 0, 0, 0, time.UTC)
+	previousRecordID := int64(100)
+	repository := BookingRepository{DB: db}
+	for _, booking := range []Booking{
+		{RecordID: 99, Action: BookingCreated, CreatedAt: since.Add(-time.Hour)},
+		{RecordID: 100, Action: BookingCreated, DoctorID: &doctor.ID, CreatedAt: since.Add(time.Hour)},
+		{RecordID: 101, Action: BookingRescheduled, PreviousRecordID: &previousRecordID,
+			Datetime: &datetime, CreatedAt: since.Add(2 * time.Hour)},
+		{RecordID: 101, Action: BookingCancelled, CreatedAt: since.Add(3 * time.Hour)},
+	} {
+		booking.UserID = user.ID
+		booking.Source = BookingSourceMenu
+		require.NoError(t, repository.Create(ctx, &booking))
+	}
+
+	records, err := repository.ListBookedSince(ctx, since)
+	require.NoError(t, err)
+	var own []BookedRecord
+	for _, record := range records {
+		if record.TgUserID == user.TgUserID {
+			own = append(own, record)
+		}
+	}
+	require.Len(t, own, 2, "cancellations and bookings before since are not listed")
+
+	require.Equal(t, int64(100), own[0].RecordID)
+	require.Equal(t, BookingCreated, own[0].Action)
+	require.Equal(t, &doctor.FIO, own[0].DoctorFIO)
+	require.Equal(t, &name, own[0].Name)
+	require.Equal(t, since.Add(time.Hour), own[0].BookedAt.UTC())
+
+	require.Equal(t, int64(101), own[1].RecordID)
+	require.Equal(t, BookingRescheduled, own[1].Action)
+	require.Nil(t, own[1].DoctorFIO)
+	require.Equal(t, datetime, own[1].Datetime.UTC())
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check возвращает ошибку, если зависимость недоступна
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker отвечает на /healthz и /readyz. Процесс жив, пока отвечает на /healthz,
+// а готов принимать трафик, только если проходят все проверки и не началась остановка
+type Checker struct {
+	timeout time.Duration
+
+	mu       sync.Mutex
+	checks   []namedCheck
+	stopping atomic.Bool
+}
+
+// Status - ответ /readyz
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+const (
+	StatusReady        = "ready"
+	StatusNotReady     = "not ready"
+	StatusShuttingDown = "shutting down"
+	checkOK            = "ok"
+)
+
+// NewChecker создает Checker. timeout ограничивает время всех проверок одного запроса
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name, check})
+}
+
+// Shutdown переводит /readyz в not ready, чтобы на процесс перестали направлять трафик
+func (c *Checker) Shutdown() {
+	c.stopping.Store(true)
+}
+
+// Ready выполняет все проверки параллельно
+func (c *Checker) Ready(ctx context.Context) Status {
+	if c.stopping.Load() {
+		return Status{Status: StatusShuttingDown}
+	}
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	results := make([]string, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checkOK
+			if err := check.check(ctx); err != nil {
+				results[i] = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := Status{Status: StatusReady, Checks: make(map[string]string, len(checks))}
+	for i, check := range checks {
+		status.Checks[check.name] = results[i]
+		if results[i] != checkOK {
+			status.Status = StatusNotReady
+		}
+	}
+	if c.stopping.Load() {
+		status.Status = StatusShuttingDown
+	}
+	return status
+}
+
+// LiveHandler отвечает на /healthz: процесс запущен и обрабатывает HTTP запросы
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(checkOK))
+	})
+}
+
+// ReadyHandler отвечает на /readyz: 200, если все проверки прошли, иначе 503
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := c.Ready(req.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if status.Status != StatusReady {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
+
+// Cached запоминает результат check на ttl. Так частые запросы /readyz
+// не расходуют лимит запросов к внешнему API
+func Cached(check Check, ttl time.Duration) Check {
+	var (
+		mu        sync.Mutex
+		err       error
+		expiresAt time.Time
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Now().Before(expiresAt) {
+			return err
+		}
+		err = check(ctx)
+		expiresAt = time.Now().Add(ttl)
+		return err
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("postgres", func(ctx context.Context) error {
+		return nil
+	})
+	dentalProErr := error(nil)
+	checker.Add("dentalpro", func(ctx context.Context) error {
+		return dentalProErr
+	})
+
+	ready := func() (int, Status) {
+		recorder := httptest.NewRecorder()
+		checker.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		var status Status
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+		return recorder.Code, status
+	}
+
+	code, status := ready()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, Status{Status: StatusReady, Checks: map[string]string{"postgres": "ok", "dentalpro": "ok"}}, status)
+
+	dentalProErr = errors.New("connection refused")
+	code, status = ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusNotReady, status.Status)
+	require.Equal(t, "connection refused", status.Checks["dentalpro"])
+
+	dentalProErr = nil
+	checker.Shutdown()
+	code, status = ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusShuttingDown, status.Status)
+
+	recorder := httptest.NewRecorder()
+	checker.LiveHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	checker := NewChecker(10 * time.Millisecond)
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	status := checker.Ready(context.Background())
+	require.Equal(t, StatusNotReady, status.Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), status.Checks["slow"])
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	check := Cached(func(ctx context.Context) error {
+		calls++
+		return errors.New("down")
+	}, time.Hour)
+	require.Error(t, check(context.Background()))
+	require.Error(t, check(context.Background()))
+	require.Equal(t, 1, calls)
+}
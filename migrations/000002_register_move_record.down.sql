@@ -0,0 +1 @@
+ALTER TABLE "Register" DROP COLUMN "move_record_id";
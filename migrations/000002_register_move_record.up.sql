@@ -0,0 +1 @@
+ALTER TABLE "Register" ADD COLUMN "move_record_id" BIGINT;
@@ -0,0 +1,9 @@
+CREATE TABLE "Reminder" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" BIGINT NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+    "record_id" BIGINT NOT NULL,
+    "offset_minutes" INTEGER NOT NULL,
+    "sent_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (record_id, offset_minutes)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE "RecordConfirmation" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" BIGINT NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+    "record_id" BIGINT UNIQUE NOT NULL,
+    "status" VARCHAR(16) NOT NULL,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
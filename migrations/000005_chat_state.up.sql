@@ -0,0 +1,6 @@
+CREATE TABLE "ChatState" (
+    "chat_id" BIGINT PRIMARY KEY,
+    "step" VARCHAR(64) NOT NULL,
+    "payload" JSONB,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
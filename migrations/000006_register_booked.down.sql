@@ -0,0 +1,2 @@
+ALTER TABLE "Register" DROP COLUMN "booked_at";
+ALTER TABLE "Register" DROP COLUMN "record_id";
@@ -0,0 +1,2 @@
+ALTER TABLE "Register" ADD COLUMN "record_id" BIGINT;
+ALTER TABLE "Register" ADD COLUMN "booked_at" TIMESTAMP;
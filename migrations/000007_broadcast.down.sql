@@ -0,0 +1,3 @@
+DROP TABLE "BroadcastDelivery";
+DROP TABLE "Broadcast";
+ALTER TABLE "User" DROP COLUMN "blocked_at";
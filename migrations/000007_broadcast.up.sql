@@ -0,0 +1,22 @@
+ALTER TABLE "User" ADD COLUMN "blocked_at" TIMESTAMP;
+
+CREATE TABLE "Broadcast" (
+    "id" SERIAL PRIMARY KEY,
+    "admin_chat_id" BIGINT NOT NULL,
+    "text" TEXT NOT NULL,
+    "status" VARCHAR(16) NOT NULL,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "finished_at" TIMESTAMP
+);
+
+CREATE TABLE "BroadcastDelivery" (
+    "id" SERIAL PRIMARY KEY,
+    "broadcast_id" BIGINT NOT NULL REFERENCES "Broadcast"("id") ON DELETE CASCADE,
+    "user_id" BIGINT NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+    "status" VARCHAR(16) NOT NULL,
+    "error" TEXT,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE ("broadcast_id", "user_id")
+);
+
+CREATE INDEX "broadcast_delivery_status_idx" ON "BroadcastDelivery" ("broadcast_id", "status");
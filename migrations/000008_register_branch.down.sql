@@ -0,0 +1 @@
+ALTER TABLE "Register" DROP COLUMN "branch_id";
@@ -0,0 +1 @@
+ALTER TABLE "Register" ADD COLUMN "branch_id" BIGINT;
@@ -0,0 +1,2 @@
+DROP TABLE "Booking";
+ALTER TABLE "Register" DROP COLUMN "source";
@@ -0,0 +1,19 @@
+ALTER TABLE "Register" ADD COLUMN "source" VARCHAR(16) NOT NULL DEFAULT 'menu';
+
+CREATE TABLE "Booking" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" BIGINT NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+    "record_id" BIGINT NOT NULL,
+    "action" VARCHAR(16) NOT NULL,
+    "source" VARCHAR(16) NOT NULL,
+    "doctor_id" BIGINT,
+    "appointment_id" BIGINT,
+    "branch_id" BIGINT,
+    "datetime" TIMESTAMP,
+    "previous_record_id" BIGINT,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "booking_record_id_idx" ON "Booking" ("record_id");
+CREATE INDEX "booking_user_id_idx" ON "Booking" ("user_id", "created_at");
+CREATE INDEX "booking_created_at_idx" ON "Booking" ("created_at");
@@ -0,0 +1,19 @@
+CREATE TABLE "Waitlist" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" BIGINT NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+    "chat_id" BIGINT NOT NULL,
+    "doctor_id" BIGINT NOT NULL,
+    "appointment_id" BIGINT,
+    "branch_id" BIGINT NOT NULL,
+    "date_from" DATE NOT NULL,
+    "date_to" DATE NOT NULL,
+    "status" VARCHAR(16) NOT NULL,
+    "slot" TIMESTAMP,
+    "notified_at" TIMESTAMP,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX "waitlist_waiting_idx" ON "Waitlist" (
+    "user_id", "doctor_id", (COALESCE("appointment_id", 0)), "branch_id"
+) WHERE "status" = 'waiting';
+CREATE INDEX "waitlist_status_idx" ON "Waitlist" ("status", "created_at");
@@ -0,0 +1 @@
+ALTER TABLE "User" ADD COLUMN "language" VARCHAR(8);
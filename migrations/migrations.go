@@ -0,0 +1,8 @@
+// Package migrations встраивает SQL миграции в бинарник. Формат имен - как у golang-migrate:
+// 000001_name.up.sql и 000001_name.down.sql
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,21 +1,74 @@
 package pkg
 
 import (
+	"context"
+	"fmt"
 	"github.com/natefinch/lumberjack"
 	"github.com/sirupsen/logrus"
+	"io"
+	"os"
 )
 
-func InitLogger(logPath string) {
-	logFile := &lumberjack.Logger{
-		Filename:   logPath, // Имя файла лога
-		MaxSize:    3,       // Максимальный размер файла в мегабайтах
-		MaxBackups: 3,       // Максимальное количество резервных копий
-		MaxAge:     28,      // Максимальный срок хранения резервных копий (в днях)
-		Compress:   true,    // Сжать резервные копии
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// LoggerOptions - настройки логгера, см. InitLogger
+type LoggerOptions struct {
+	// Format - json для сборщиков логов или console для чтения человеком
+	Format string
+	// Level - минимальный уровень: debug, info, warn, error
+	Level string
+	// Path - файл лога с ротацией. Пустой путь - писать в stderr
+	Path string
+}
+
+func InitLogger(options LoggerOptions) error {
+	level, err := logrus.ParseLevel(options.Level)
+	if err != nil {
+		return err
+	}
+	var formatter logrus.Formatter
+	switch options.Format {
+	case LogFormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	case LogFormatConsole:
+		formatter = &logrus.TextFormatter{
+			FullTimestamp: true,
+		}
+	default:
+		return fmt.Errorf("unknown log format %q", options.Format)
+	}
+
+	var output io.Writer = os.Stderr
+	if options.Path != "" {
+		output = &lumberjack.Logger{
+			Filename:   options.Path, // Имя файла лога
+			MaxSize:    3,            // Максимальный размер файла в мегабайтах
+			MaxBackups: 3,            // Максимальное количество резервных копий
+			MaxAge:     28,           // Максимальный срок хранения резервных копий (в днях)
+			Compress:   true,         // Сжать резервные копии
+		}
 	}
 
-	logrus.SetOutput(logFile)
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	logrus.SetOutput(output)
+	logrus.SetFormatter(formatter)
+	logrus.SetLevel(level)
+	return nil
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger сохраняет в ctx логгер, например с полями текущего обновления Telegram
+func ContextWithLogger(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFromContext возвращает логгер из ctx или стандартный логгер, если его там нет
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if log, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return log
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
 }